@@ -0,0 +1,197 @@
+// Package client talks to the babysitter management API, it is what sit is
+// built on
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultUserAgent identifies requests made through this package
+const DefaultUserAgent = "babysitter-client/1.0"
+
+var (
+	// ErrUnauthorized is matched by errors for requests without valid
+	// credentials
+	ErrUnauthorized = errors.New("authentication failed")
+	// ErrForbidden is matched by errors for requests the credentials do not
+	// permit
+	ErrForbidden = errors.New("not permitted with these credentials")
+	// ErrNotFound is matched by errors for lists, domains, versions or ids
+	// that don't exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is matched by errors for changes based on a version of
+	// the rules that is no longer current
+	ErrConflict = errors.New("the rules were changed by someone else")
+)
+
+// Error is a failed response from the api
+type Error struct {
+	StatusCode int
+	// Message is the error reported by babysitter, if any
+	Message string
+	// Fields lists every invalid entry of a rejected change
+	Fields []FieldError
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Message != "":
+		return e.Message
+	case e.sentinel() != nil:
+		return e.sentinel().Error()
+	}
+	return fmt.Sprintf("request failed with status %d", e.StatusCode)
+}
+
+// Is matches the Err variables by status code
+func (e *Error) Is(target error) bool {
+	return e.sentinel() != nil && e.sentinel() == target
+}
+
+func (e *Error) sentinel() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusPreconditionFailed:
+		return ErrConflict
+	}
+	return nil
+}
+
+// Client is a babysitter api client, it is safe for concurrent use
+type Client struct {
+	// BaseURL is the root of the api, like https://babysitter.lan:8443
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// Token is sent as a bearer token if set, otherwise User and Password
+	// are used for basic authentication if User is set
+	Token     string
+	User      string
+	Password  string
+	UserAgent string
+}
+
+// New creates a client for the api at baseURL
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		UserAgent: DefaultUserAgent,
+	}
+}
+
+// AnyVersion can be passed wherever a version is expected to apply a change
+// regardless of what changed in the meantime
+const AnyVersion uint64 = 0
+
+// ifMatch carries the version a change is based on
+func ifMatch(version uint64) http.Header {
+	if version == AnyVersion {
+		return nil
+	}
+	return http.Header{"If-Match": {strconv.Quote(strconv.FormatUint(version, 10))}}
+}
+
+// Version parses the version of the rules from an ETag
+func Version(etag string) (uint64, error) {
+	unquoted, err := strconv.Unquote(etag)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ETag %s", etag)
+	}
+	return strconv.ParseUint(unquoted, 10, 64)
+}
+
+// do sends in as JSON, if it isn't nil, with method to path and decodes the
+// response into out, if it isn't nil. It returns the response headers.
+func (c *Client) do(
+	ctx context.Context,
+	method, path string,
+	header http.Header,
+	in, out interface{},
+) (http.Header, error) {
+	response, err := c.send(ctx, method, path, header, in)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if out != nil && response.StatusCode != http.StatusNoContent {
+		err = json.NewDecoder(response.Body).Decode(out)
+		if err != nil {
+			return response.Header, fmt.Errorf("could not read response: %v", err)
+		}
+	}
+	return response.Header, nil
+}
+
+// send sends in as JSON, if it isn't nil, with method to path. Any response
+// other than a success is returned as an *Error, otherwise the caller has
+// to close the body.
+func (c *Client) send(
+	ctx context.Context,
+	method, path string,
+	header http.Header,
+	in interface{},
+) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	if in != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.UserAgent != "" {
+		request.Header.Set("User-Agent", c.UserAgent)
+	}
+	switch {
+	case c.Token != "":
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.User != "":
+		request.SetBasicAuth(c.User, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		defer response.Body.Close()
+		failure := &Error{StatusCode: response.StatusCode}
+		var body ErrorResponse
+		if json.NewDecoder(response.Body).Decode(&body) == nil {
+			failure.Message = body.Error
+			failure.Fields = body.Errors
+		}
+		return nil, failure
+	}
+	return response, nil
+}
@@ -0,0 +1,280 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jcline/babysitter/internal/api"
+	"github.com/jcline/babysitter/internal/apitest"
+	"github.com/jcline/babysitter/internal/events"
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// newTestClient serves the real api handlers and returns a client for them
+// authenticated with token. The rules are shared by every test, so tests
+// don't assume anything about what other tests left in them.
+func newTestClient(t *testing.T, token string) *Client {
+	server, err := api.NewServer(api.Config{
+		Listen: "localhost:0",
+		Auth: api.AuthConfig{Tokens: []api.TokenConfig{
+			{Name: "parent", Hash: api.HashToken("secret")},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	ts := httptest.NewServer(server.Handler)
+	t.Cleanup(ts.Close)
+
+	c := New(ts.URL)
+	c.Token = token
+	return c
+}
+
+func Test_Lists(t *testing.T) {
+	c := newTestClient(t, "secret")
+	ctx := context.Background()
+
+	// the rules have a version however they were left
+	_, err := c.ReplaceList(ctx, "blacklist", []string{"start.com"}, AnyVersion)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	_, first, err := c.Rules(ctx)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	change, err := c.AddDomain(ctx, "blacklist", "example.com", first)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if len(change.Added) != 1 || change.Version == first {
+		t.Fatalf("got %+v wanted example.com added in a new version", change)
+	}
+
+	// editing from the first version loses
+	_, err = c.EditList(ctx, "blacklist", ListEdit{Add: []string{"a.com"}}, first)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v wanted %v", err, ErrConflict)
+	}
+
+	change, err = c.ReplaceList(ctx, "blacklist",
+		[]string{"a.com", "b.com"}, change.Version)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	list, err := c.List(ctx, "blacklist")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if len(list.Domains) != 2 || list.Version != change.Version {
+		t.Fatalf("got %+v wanted a.com and b.com at %d", list, change.Version)
+	}
+
+	_, err = c.RemoveDomain(ctx, "blacklist", "example.com", AnyVersion)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v wanted %v", err, ErrNotFound)
+	}
+
+	snapshots, err := c.History(ctx, 2)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Version != change.Version {
+		t.Fatalf("got %+v wanted the last two versions", snapshots)
+	}
+
+	restored, err := c.Rollback(ctx, first, change.Version)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if restored.Source != rule.SourceRollback {
+		t.Fatalf("got source %s wanted %s", restored.Source, rule.SourceRollback)
+	}
+}
+
+func Test_UpdateRules(t *testing.T) {
+	c := newTestClient(t, "secret")
+	ctx := context.Background()
+
+	_, err := c.UpdateRules(ctx, &RuleUpdateRequest{
+		Rules: map[string][]string{"blacklist": {"good.com", "not a domain"}},
+	}, AnyVersion, true)
+	var failure *Error
+	if !errors.As(err, &failure) {
+		t.Fatalf("got %v wanted an *Error", err)
+	}
+	if len(failure.Fields) != 1 || failure.Fields[0].Value != "not a domain" {
+		t.Fatalf("got %+v wanted the invalid domain", failure.Fields)
+	}
+
+	update, err := c.UpdateRules(ctx, &RuleUpdateRequest{
+		Rules: map[string][]string{"blacklist": {"good.com"}},
+	}, AnyVersion, true)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	adds := false
+	for _, d := range update.Diff {
+		adds = adds || d == DiffEntry{Op: "add", Section: "blacklist", Item: "good.com"}
+	}
+	if update.Applied || !adds {
+		t.Fatalf("got %+v wanted a preview adding good.com", update)
+	}
+
+	update, err = c.UpdateRules(ctx, &RuleUpdateRequest{
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"10.0.0.0/24"}}},
+	}, AnyVersion, false)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if !update.Applied || len(update.Rules.Groups) != 1 {
+		t.Fatalf("got %+v wanted the kids group added", update)
+	}
+
+	_, err = c.UpdateRules(ctx, &RuleUpdateRequest{
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"nope"}}},
+	}, AnyVersion, false)
+	if !errors.As(err, &failure) || failure.StatusCode != 400 {
+		t.Fatalf("got %v wanted a 400", err)
+	}
+}
+
+func Test_Overrides(t *testing.T) {
+	c := newTestClient(t, "secret")
+	ctx := context.Background()
+
+	o, err := c.AddOverride(ctx, "example.com", "allow", "", time.Hour)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	overrides, err := c.Overrides(ctx)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	found := false
+	for _, existing := range overrides {
+		found = found || existing.ID == o.ID && existing.Domain == "example.com"
+	}
+	if !found {
+		t.Fatalf("got %+v wanted override %d", overrides, o.ID)
+	}
+
+	err = c.RemoveOverride(ctx, o.ID)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	err = c.RemoveOverride(ctx, o.ID)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v wanted %v", err, ErrNotFound)
+	}
+}
+
+func Test_Unauthorized(t *testing.T) {
+	c := newTestClient(t, "wrong")
+
+	_, err := c.Status(context.Background())
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("got %v wanted %v", err, ErrUnauthorized)
+	}
+}
+
+func Test_Watch(t *testing.T) {
+	c := newTestClient(t, "secret")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// publish until the watcher has subscribed and seen a decision
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				events.Record(events.Decision{Client: "10.0.0.1", Host: "a.com"})
+				events.Record(events.Decision{Client: "10.0.0.2", Host: "b.com"})
+			}
+		}
+	}()
+
+	done := errors.New("done")
+	err := c.Watch(ctx, WatchQuery{
+		Types:  []string{EventDecision},
+		Client: "10.0.0.2",
+	}, func(e *Event) error {
+		d, err := e.Decision()
+		if err != nil {
+			return err
+		}
+		if d.Host != "b.com" || e.ID == 0 {
+			t.Errorf("got %+v wanted b.com with an id", e)
+		}
+		return done
+	})
+	if err != done {
+		t.Fatalf("got %v wanted %v", err, done)
+	}
+}
+
+func Test_Types(t *testing.T) {
+	// the client declares its own types, they must still read and write
+	// what the server does
+	types := []struct {
+		client, server interface{}
+	}{
+		{RuleConfig{}, rule.RuleConfig{}},
+		{GroupConfig{}, rule.GroupConfig{}},
+		{ScheduleConfig{}, rule.ScheduleConfig{}},
+		{CategoryConfig{}, rule.CategoryConfig{}},
+		{ContentConfig{}, rule.ContentConfig{}},
+		{TermConfig{}, rule.TermConfig{}},
+		{SafeSearchConfig{}, rule.SafeSearchConfig{}},
+		{HeaderConfig{}, rule.HeaderConfig{}},
+		{RedirectConfig{}, rule.RedirectConfig{}},
+		{ListEdit{}, rule.ListEdit{}},
+		{ListChange{}, rule.ListChange{}},
+		{DiffEntry{}, rule.DiffEntry{}},
+		{Change{}, rule.Change{}},
+		{Snapshot{}, rule.Snapshot{}},
+		{FieldError{}, rule.FieldError{}},
+		{CacheStats{}, rule.CacheStats{}},
+		{Explanation{}, rule.Explanation{}},
+		{Step{}, rule.Step{}},
+		{RuleDecision{}, rule.Decision{}},
+		{Match{}, rule.Match{}},
+		{Override{}, rule.Override{}},
+		{Restriction{}, rule.Restriction{}},
+		{Decision{}, events.Decision{}},
+		{RuleUpdateRequest{}, api.RuleUpdateRequest{}},
+		{RuleUpdate{}, api.RuleUpdate{}},
+		{ListReplacement{}, api.ListReplacement{}},
+		{AccessSubmission{}, api.AccessSubmission{}},
+		{AccessDecision{}, api.AccessDecision{}},
+		{AccessRequest{}, api.AccessRequest{}},
+		{OverrideRequest{}, api.OverrideRequest{}},
+		{Me{}, api.Me{}},
+		{Status{}, api.Status{}},
+		{ErrorResponse{}, api.ErrorResponse{}},
+		{Dropped{}, api.Dropped{}},
+	}
+	for _, types := range types {
+		ct, st := reflect.TypeOf(types.client), reflect.TypeOf(types.server)
+		got := apitest.Kinds(apitest.JSONFields(ct))
+		wanted := apitest.Kinds(apitest.JSONFields(st))
+		if !reflect.DeepEqual(got, wanted) {
+			t.Errorf("got %v wanted %v for %v", got, wanted, ct)
+		}
+	}
+
+	if EventDecision != events.TypeDecision || EventRules != events.TypeRules {
+		t.Fatalf("got %s and %s wanted %s and %s", EventDecision, EventRules,
+			events.TypeDecision, events.TypeRules)
+	}
+}
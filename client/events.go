@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// the types of live events
+const (
+	EventDecision = "decision"
+	EventRules    = "rules"
+	// EventDropped reports how many events the server dropped because the
+	// watcher didn't keep up, its data is a Dropped
+	EventDropped = "dropped"
+)
+
+// Dropped is the data of an EventDropped
+type Dropped struct {
+	// Dropped is how many events were dropped since the stream started
+	Dropped uint64 `json:"dropped"`
+}
+
+// Event is a live event, Data holds the JSON of a Decision, a Snapshot
+// without rules or a Dropped depending on the Type
+type Event struct {
+	ID   uint64          `json:"id,omitempty"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Decision decodes the data of an EventDecision
+func (e *Event) Decision() (*Decision, error) {
+	var d Decision
+	return &d, e.decode(EventDecision, &d)
+}
+
+// Snapshot decodes the data of an EventRules
+func (e *Event) Snapshot() (*Snapshot, error) {
+	var s Snapshot
+	return &s, e.decode(EventRules, &s)
+}
+
+// Dropped decodes the data of an EventDropped
+func (e *Event) Dropped() (*Dropped, error) {
+	var d Dropped
+	return &d, e.decode(EventDropped, &d)
+}
+
+func (e *Event) decode(typ string, v interface{}) error {
+	if e.Type != typ {
+		return fmt.Errorf("%s event is not a %s event", e.Type, typ)
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// WatchQuery selects the live events to watch, zero values don't filter
+type WatchQuery struct {
+	// Types are EventDecision or EventRules
+	Types []string
+	// Client and Verdict only apply to decisions, like for Log
+	Client  string
+	Verdict string
+}
+
+// Watch calls fn for every live event until ctx is done, fn returns an
+// error or the stream ends. Returning an error from fn stops watching and
+// is returned by Watch.
+func (c *Client) Watch(
+	ctx context.Context,
+	q WatchQuery,
+	fn func(*Event) error,
+) error {
+	query := url.Values{}
+	if len(q.Types) > 0 {
+		query.Set("type", strings.Join(q.Types, ","))
+	}
+	if q.Client != "" {
+		query.Set("client", q.Client)
+	}
+	if q.Verdict != "" {
+		query.Set("verdict", q.Verdict)
+	}
+
+	response, err := c.send(ctx, "GET", "/events?"+query.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// an event is a block of lines ended by an empty line, lines starting
+	// with a colon are comments
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var event Event
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if event.Type != "" || len(data) > 0 {
+				if event.Type == "" {
+					event.Type = "message"
+				}
+				event.Data = json.RawMessage(strings.Join(data, "\n"))
+				if err := fn(&event); err != nil {
+					return err
+				}
+			}
+			event, data = Event{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "id":
+			event.ID, _ = strconv.ParseUint(value, 10, 64)
+		case "event":
+			event.Type = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// Me returns who the credentials belong to and the restrictions that apply
+// to them
+func (c *Client) Me(ctx context.Context) (*Me, error) {
+	var me Me
+	_, err := c.do(ctx, "GET", "/me", nil, nil, &me)
+	if err != nil {
+		return nil, err
+	}
+	return &me, nil
+}
+
+// AccessRequests returns the access requests, children only see their own
+func (c *Client) AccessRequests(ctx context.Context) ([]AccessRequest, error) {
+	var requests []AccessRequest
+	_, err := c.do(ctx, "GET", "/requests", nil, nil, &requests)
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// RequestAccess asks for domain to be unblocked
+func (c *Client) RequestAccess(
+	ctx context.Context,
+	domain, reason string,
+) (*AccessRequest, error) {
+	var ar AccessRequest
+	_, err := c.do(ctx, "POST", "/requests", nil, &AccessSubmission{
+		Domain: domain,
+		Reason: reason,
+	}, &ar)
+	if err != nil {
+		return nil, err
+	}
+	return &ar, nil
+}
+
+// DecideAccessRequest approves or denies the access request with id,
+// status is approved or denied
+func (c *Client) DecideAccessRequest(
+	ctx context.Context,
+	id uint64,
+	status string,
+) (*AccessRequest, error) {
+	var ar AccessRequest
+	_, err := c.do(ctx, "POST", fmt.Sprintf("/requests/%d", id), nil,
+		&AccessDecision{Status: status}, &ar)
+	if err != nil {
+		return nil, err
+	}
+	return &ar, nil
+}
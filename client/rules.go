@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Rules returns the current rules and their version
+func (c *Client) Rules(ctx context.Context) (*RuleConfig, uint64, error) {
+	var rc RuleConfig
+	header, err := c.do(ctx, "GET", "/rules", nil, nil, &rc)
+	if err != nil {
+		return nil, 0, err
+	}
+	version, err := Version(header.Get("ETag"))
+	if err != nil {
+		return nil, 0, err
+	}
+	return &rc, version, nil
+}
+
+// UpdateRules replaces the lists and sections in update, as long as the
+// rules are still at version or version is AnyVersion. With validateOnly
+// the change is only validated and previewed.
+func (c *Client) UpdateRules(
+	ctx context.Context,
+	update *RuleUpdateRequest,
+	version uint64,
+	validateOnly bool,
+) (*RuleUpdate, error) {
+	path := "/rules"
+	if validateOnly {
+		path += "?validate=only"
+	}
+
+	var result RuleUpdate
+	_, err := c.do(ctx, "POST", path, ifMatch(version),
+		update, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// List returns the domains in list
+func (c *Client) List(ctx context.Context, list string) (*ListChange, error) {
+	var change ListChange
+	_, err := c.do(ctx, "GET", "/rules/"+url.PathEscape(list), nil, nil, &change)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// EditList adds and removes domains from list
+func (c *Client) EditList(
+	ctx context.Context,
+	list string,
+	edit ListEdit,
+	version uint64,
+) (*ListChange, error) {
+	var change ListChange
+	_, err := c.do(ctx, "PATCH", "/rules/"+url.PathEscape(list),
+		ifMatch(version), &edit, &change)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// ReplaceList replaces every domain in list
+func (c *Client) ReplaceList(
+	ctx context.Context,
+	list string,
+	domains []string,
+	version uint64,
+) (*ListChange, error) {
+	var change ListChange
+	_, err := c.do(ctx, "PUT", "/rules/"+url.PathEscape(list),
+		ifMatch(version), &ListReplacement{Domains: domains}, &change)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// AddDomain adds domain to list
+func (c *Client) AddDomain(
+	ctx context.Context,
+	list, domain string,
+	version uint64,
+) (*ListChange, error) {
+	return c.domain(ctx, "POST", list, domain, version)
+}
+
+// RemoveDomain removes domain from list, it fails with ErrNotFound if the
+// domain isn't listed
+func (c *Client) RemoveDomain(
+	ctx context.Context,
+	list, domain string,
+	version uint64,
+) (*ListChange, error) {
+	return c.domain(ctx, "DELETE", list, domain, version)
+}
+
+func (c *Client) domain(
+	ctx context.Context,
+	method, list, domain string,
+	version uint64,
+) (*ListChange, error) {
+	var change ListChange
+	path := "/rules/" + url.PathEscape(list) + "/" + url.PathEscape(domain)
+	_, err := c.do(ctx, method, path, ifMatch(version), nil, &change)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// History returns up to limit versions of the rules, newest first, all of
+// them if limit is zero
+func (c *Client) History(ctx context.Context, limit int) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	_, err := c.do(ctx, "GET", fmt.Sprintf("/rules/history?limit=%d", limit),
+		nil, nil, &snapshots)
+	if err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// Version returns a single version of the rules, including the rules
+func (c *Client) Version(ctx context.Context, version uint64) (*Snapshot, error) {
+	var snapshot Snapshot
+	_, err := c.do(ctx, "GET", fmt.Sprintf("/rules/history?version=%d", version),
+		nil, nil, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// Rollback restores the rules of version as a new version, as long as the
+// rules are still at ifVersion or ifVersion is AnyVersion
+func (c *Client) Rollback(
+	ctx context.Context,
+	version, ifVersion uint64,
+) (*Snapshot, error) {
+	var snapshot Snapshot
+	_, err := c.do(ctx, "POST", fmt.Sprintf("/rules/rollback?version=%d", version),
+		ifMatch(ifVersion), nil, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Status returns the state of babysitter
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	_, err := c.do(ctx, "GET", "/status", nil, nil, &status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// LogQuery filters the decision log, zero values don't filter
+type LogQuery struct {
+	Limit  int
+	Client string
+	// Verdict is allow or deny
+	Verdict string
+}
+
+// Log returns recent decisions, newest first
+func (c *Client) Log(ctx context.Context, q LogQuery) ([]Decision, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(q.Limit))
+	if q.Client != "" {
+		query.Set("client", q.Client)
+	}
+	if q.Verdict != "" {
+		query.Set("verdict", q.Verdict)
+	}
+
+	var decisions []Decision
+	_, err := c.do(ctx, "GET", "/log?"+query.Encode(), nil, nil, &decisions)
+	if err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
+
+// Explain shows how the rules decide target for client, which may be empty
+func (c *Client) Explain(
+	ctx context.Context,
+	target, client string,
+) (*Explanation, error) {
+	query := url.Values{}
+	query.Set("url", target)
+	if client != "" {
+		query.Set("client", client)
+	}
+
+	var explanation Explanation
+	_, err := c.do(ctx, "GET", "/explain?"+query.Encode(), nil, nil, &explanation)
+	if err != nil {
+		return nil, err
+	}
+	return &explanation, nil
+}
+
+// Cache returns the decision cache statistics
+func (c *Client) Cache(ctx context.Context) (*CacheStats, error) {
+	var stats CacheStats
+	_, err := c.do(ctx, "GET", "/cache", nil, nil, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// FlushCache empties the decision cache
+func (c *Client) FlushCache(ctx context.Context) (*CacheStats, error) {
+	var stats CacheStats
+	_, err := c.do(ctx, "POST", "/cache/flush", nil, nil, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Overrides returns the active overrides
+func (c *Client) Overrides(ctx context.Context) ([]Override, error) {
+	var overrides []Override
+	_, err := c.do(ctx, "GET", "/overrides", nil, nil, &overrides)
+	if err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// AddOverride allows or denies domain for duration, for everyone or only for
+// group
+func (c *Client) AddOverride(
+	ctx context.Context,
+	domain, action, group string,
+	duration time.Duration,
+) (*Override, error) {
+	var o Override
+	_, err := c.do(ctx, "POST", "/overrides", nil, &OverrideRequest{
+		Domain:   domain,
+		Action:   action,
+		Group:    group,
+		Duration: duration.String(),
+	}, &o)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// RemoveOverride ends the override with id early
+func (c *Client) RemoveOverride(ctx context.Context, id uint64) error {
+	_, err := c.do(ctx, "DELETE", fmt.Sprintf("/overrides/%d", id), nil, nil, nil)
+	return err
+}
@@ -0,0 +1,319 @@
+package client
+
+import (
+	"time"
+)
+
+// The types exchanged with the api are declared here rather than shared
+// with the server, so that importing this package doesn't pull in the
+// server. The tests check that they have the fields of the server's types.
+
+// RuleConfig is the configuration of the rules
+type RuleConfig struct {
+	Blacklist  []string          `json:"blacklist,omitempty"`
+	Whitelist  []string          `json:"whitelist,omitempty"`
+	Groups     []*GroupConfig    `json:"groups,omitempty"`
+	Categories []*CategoryConfig `json:"categories,omitempty"`
+	Schedules  []*ScheduleConfig `json:"schedules,omitempty"`
+	Content    *ContentConfig    `json:"content,omitempty"`
+	SafeSearch *SafeSearchConfig `json:"safe_search,omitempty"`
+	Headers    []*HeaderConfig   `json:"headers,omitempty"`
+	Redirects  []*RedirectConfig `json:"redirects,omitempty"`
+}
+
+// GroupConfig names a set of clients by address or network, and by the
+// names of the users who authenticate to the proxy
+type GroupConfig struct {
+	Name    string   `json:"name"`
+	Clients []string `json:"clients"`
+	Users   []string `json:"users,omitempty"`
+}
+
+// ScheduleConfig is a named set of days and a time of day range
+type ScheduleConfig struct {
+	Name  string   `json:"name"`
+	Days  []string `json:"days,omitempty"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+// CategoryConfig is a named list of domains that are allowed or denied,
+// optionally only for some groups and while a schedule is active
+type CategoryConfig struct {
+	Name     string   `json:"name"`
+	Action   string   `json:"action"`
+	Domains  []string `json:"domains"`
+	Groups   []string `json:"groups,omitempty"`
+	Schedule string   `json:"schedule,omitempty"`
+}
+
+// ContentConfig blocks responses by what they contain
+type ContentConfig struct {
+	Types      []string       `json:"types,omitempty"`
+	MaxSize    int64          `json:"max_size,omitempty"`
+	Keywords   []string       `json:"keywords,omitempty"`
+	Terms      []*TermConfig  `json:"terms,omitempty"`
+	Threshold  int            `json:"threshold,omitempty"`
+	Thresholds map[string]int `json:"thresholds,omitempty"`
+	Groups     []string       `json:"groups,omitempty"`
+}
+
+// TermConfig is a weighted keyword or phrase
+type TermConfig struct {
+	Term   string `json:"term"`
+	Weight int    `json:"weight"`
+}
+
+// SafeSearchConfig enforces the safe search of search engines
+type SafeSearchConfig struct {
+	Enabled bool     `json:"enabled"`
+	Engines []string `json:"engines,omitempty"`
+	YouTube string   `json:"youtube,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+// HeaderConfig adds, sets or removes headers of allowed requests
+type HeaderConfig struct {
+	Name    string            `json:"name"`
+	Domains []string          `json:"domains,omitempty"`
+	Groups  []string          `json:"groups,omitempty"`
+	Add     map[string]string `json:"add,omitempty"`
+	Set     map[string]string `json:"set,omitempty"`
+	Remove  []string          `json:"remove,omitempty"`
+}
+
+// RedirectConfig redirects denied requests instead of blocking them
+type RedirectConfig struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Status int      `json:"status,omitempty"`
+	Rules  []string `json:"rules,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ListEdit adds and removes domains from a list, removals are applied after
+// additions
+type ListEdit struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// ListChange is what an edit actually did to a list
+type ListChange struct {
+	List    string   `json:"list"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Domains []string `json:"domains"`
+	Version uint64   `json:"version"`
+}
+
+// Changed reports whether the edit modified the list
+func (lc *ListChange) Changed() bool {
+	return len(lc.Added) > 0 || len(lc.Removed) > 0
+}
+
+// DiffEntry is a single difference between two versions of the rules
+type DiffEntry struct {
+	Op      string `json:"op"`
+	Section string `json:"section"`
+	Item    string `json:"item"`
+}
+
+// Change describes who changed the rules and how
+type Change struct {
+	Author string `json:"author,omitempty"`
+	Source string `json:"source"`
+}
+
+// Snapshot is a version of the rules and the change that produced it
+type Snapshot struct {
+	Version uint64    `json:"version"`
+	Time    time.Time `json:"time"`
+	Change
+	Diff  []DiffEntry `json:"diff"`
+	Rules *RuleConfig `json:"rules,omitempty"`
+}
+
+// FieldError is a single invalid entry in a change to the rules
+type FieldError struct {
+	Field   string `json:"field"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+// CacheStats describes the decision cache
+type CacheStats struct {
+	Entries  int           `json:"entries"`
+	Capacity int           `json:"capacity"`
+	TTL      time.Duration `json:"ttl"`
+	Hits     uint64        `json:"hits"`
+	Misses   uint64        `json:"misses"`
+	Expired  uint64        `json:"expired"`
+}
+
+// Explanation is how the rules decided a request
+type Explanation struct {
+	URL      string        `json:"url"`
+	Client   string        `json:"client,omitempty"`
+	Group    string        `json:"group,omitempty"`
+	Steps    []Step        `json:"steps"`
+	Decision *RuleDecision `json:"decision"`
+}
+
+// Step is the result of a single rule
+type Step struct {
+	Rule   string `json:"rule"`
+	Result string `json:"result"`
+}
+
+// RuleDecision is the final result of applying every rule to a request
+type RuleDecision struct {
+	Allow   bool    `json:"allow"`
+	Rule    string  `json:"rule"`
+	Group   string  `json:"group,omitempty"`
+	Reason  string  `json:"reason,omitempty"`
+	Matches []Match `json:"matches,omitempty"`
+}
+
+// Match is a term found in a page
+type Match struct {
+	Term   string `json:"term"`
+	Weight int    `json:"weight,omitempty"`
+	Count  int    `json:"count"`
+}
+
+// Override temporarily allows or denies a domain regardless of the rules
+type Override struct {
+	ID      uint64    `json:"id"`
+	Domain  string    `json:"domain"`
+	Action  string    `json:"action"`
+	Group   string    `json:"group,omitempty"`
+	Expires time.Time `json:"expires"`
+	By      string    `json:"by,omitempty"`
+}
+
+// Decision is an entry of the decision log
+type Decision struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client,omitempty"`
+	User   string    `json:"user,omitempty"`
+	Group  string    `json:"group,omitempty"`
+	Host   string    `json:"host"`
+	URL    string    `json:"url"`
+	Allow  bool      `json:"allow"`
+	Rule   string    `json:"rule"`
+	Reason string    `json:"reason,omitempty"`
+	Terms  []string  `json:"terms,omitempty"`
+}
+
+// Verdict is allow or deny
+func (d *Decision) Verdict() string {
+	if d.Allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// RuleUpdateRequest is the body of POST /rules, it replaces the lists it
+// names and every section that is present
+type RuleUpdateRequest struct {
+	Rules      map[string][]string `json:"rules,omitempty"`
+	Groups     []*GroupConfig      `json:"groups,omitempty"`
+	Schedules  []*ScheduleConfig   `json:"schedules,omitempty"`
+	Categories []*CategoryConfig   `json:"categories,omitempty"`
+	Content    *ContentConfig      `json:"content,omitempty"`
+	SafeSearch *SafeSearchConfig   `json:"safe_search,omitempty"`
+	Headers    []*HeaderConfig     `json:"headers,omitempty"`
+	Redirects  []*RedirectConfig   `json:"redirects,omitempty"`
+}
+
+// RuleUpdate is the result of changing the rules with POST /rules
+type RuleUpdate struct {
+	Applied bool        `json:"applied"`
+	Version uint64      `json:"version"`
+	Diff    []DiffEntry `json:"diff"`
+	Rules   *RuleConfig `json:"rules"`
+}
+
+// ListReplacement is the body of PUT /rules/{list}
+type ListReplacement struct {
+	Domains []string `json:"domains"`
+}
+
+// AccessSubmission is the body of POST /requests
+type AccessSubmission struct {
+	Domain string `json:"domain"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// AccessDecision is the body of POST /requests/{id}
+type AccessDecision struct {
+	// Status is approved or denied
+	Status string `json:"status"`
+}
+
+// AccessRequest is a child asking for a domain to be unblocked
+type AccessRequest struct {
+	ID        uint64     `json:"id"`
+	Domain    string     `json:"domain"`
+	Reason    string     `json:"reason,omitempty"`
+	Requester string     `json:"requester"`
+	Group     string     `json:"group,omitempty"`
+	Created   time.Time  `json:"created"`
+	Status    string     `json:"status"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	Decided   *time.Time `json:"decided,omitempty"`
+}
+
+// OverrideRequest is the body of POST /overrides
+type OverrideRequest struct {
+	Domain string `json:"domain"`
+	// Action is allow or deny
+	Action string `json:"action"`
+	Group  string `json:"group,omitempty"`
+	// Duration is how long the override lasts, like "90m"
+	Duration string `json:"duration"`
+}
+
+// Me is who the caller is and, for members of a group, which scheduled
+// restrictions apply to them
+type Me struct {
+	Name string `json:"name"`
+	// Method is how they authenticated, token, basic or none
+	Method string `json:"method"`
+	// Role is admin, viewer or child
+	Role         string        `json:"role"`
+	Group        string        `json:"group,omitempty"`
+	Restrictions []Restriction `json:"restrictions"`
+}
+
+// Restriction is a scheduled category that applies to a group
+type Restriction struct {
+	Category string `json:"category"`
+	Action   string `json:"action"`
+	Schedule string `json:"schedule"`
+	// Active is whether the category currently applies
+	Active bool `json:"active"`
+	// Changes is when Active flips next, zero if it never does
+	Changes time.Time `json:"changes,omitempty"`
+}
+
+// Status is the state of babysitter
+type Status struct {
+	Started      time.Time      `json:"started"`
+	Uptime       string         `json:"uptime"`
+	RulesVersion uint64         `json:"rules_version"`
+	Lists        map[string]int `json:"lists"`
+	Groups       int            `json:"groups"`
+	Schedules    int            `json:"schedules"`
+	Categories   int            `json:"categories"`
+	Overrides    int            `json:"overrides"`
+	Cache        CacheStats     `json:"cache"`
+}
+
+// ErrorResponse is the body of a failed response
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Errors lists every invalid entry of a rejected change
+	Errors []FieldError `json:"errors,omitempty"`
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
-	"sync"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mattn/go-isatty"
@@ -11,60 +18,220 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/jcline/babysitter/internal/api"
+	"github.com/jcline/babysitter/internal/config"
 	"github.com/jcline/babysitter/internal/icap"
 	"github.com/jcline/babysitter/internal/rule"
+	"github.com/jcline/babysitter/internal/supervisor"
+)
+
+// exit codes
+const (
+	exitOK       = iota
+	exitConfig   // the configuration could not be loaded
+	exitListener // a listener failed to start or stopped unexpectedly
+	exitShutdown // the servers did not drain before the shutdown timeout
 )
 
 func main() {
 	var err error
+	defaults := config.Default()
+	configPath := flag.String(
+		"config",
+		"",
+		"the configuration file, flags that are set override its values")
+	checkConfig := flag.Bool(
+		"check-config",
+		false,
+		"report every problem with the configuration and exit")
+	genToken := flag.Bool(
+		"gen-token",
+		false,
+		"print a new api token and the hash to put in the configuration")
+	hashPassword := flag.Bool(
+		"hash-password",
+		false,
+		"read a password from stdin and print the hash to put in the configuration")
 	debug := flag.Bool("debug", false, "enables debug logging")
 	verbose := flag.Bool("verbose", false, "enables verbose logging")
-	listen := flag.String("listen", "localhost:9001", "address to listen on")
-	apiListen := flag.String("apilisten", "localhost:80", "address to listen on")
+	listen := flag.String("listen", defaults.ICAP.Listen, "address to listen on")
+	apiListen := flag.String("apilisten", defaults.API.Listen, "address to listen on")
 	whitelist := flag.String(
 		"whitelist",
-		"/etc/babysitter/whitelist",
+		defaults.Lists.Whitelist,
 		"the file containing the domain whitelist")
 	blacklist := flag.String(
 		"blacklist",
-		"/etc/babysitter/blacklist",
+		defaults.Lists.Blacklist,
 		"the file containing the domain blacklist")
+	cacheSize := flag.Int(
+		"cachesize",
+		defaults.Cache.Size,
+		"the number of client+host decisions to cache")
+	cacheTTL := flag.Duration(
+		"cachettl",
+		defaults.Cache.TTL,
+		"how long a cached decision is valid, 0 keeps it until the rules change")
+	shutdownTimeout := flag.Duration(
+		"shutdowntimeout",
+		defaults.ShutdownTimeout,
+		"how long to wait for in flight requests when stopping")
 	flag.Parse()
 
-	if isatty.IsTerminal(os.Stdout.Fd()) {
-		log.Logger = log.Output(zerolog.ConsoleWriter{
-			Out:        os.Stderr,
-			TimeFormat: time.RFC3339,
-		})
+	if *genToken {
+		token, err := api.GenerateToken()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not generate token: %v\n", err)
+			os.Exit(exitConfig)
+		}
+		fmt.Printf("token: %s\nhash:  %s\n", token, api.HashToken(token))
+		os.Exit(exitOK)
+	}
+	if *hashPassword {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			fmt.Fprintf(os.Stderr, "could not read password: %v\n", err)
+			os.Exit(exitConfig)
+		}
+		hash, err := api.HashPassword(strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not hash password: %v\n", err)
+			os.Exit(exitConfig)
+		}
+		fmt.Printf("%s\n", hash)
+		os.Exit(exitOK)
 	}
 
-	log.Logger = log.With().Caller().Logger()
+	conf := defaults
+	var problems config.Errors
+	if *configPath != "" {
+		conf, err = config.Load(*configPath)
+		if errs, ok := err.(config.Errors); ok && conf != nil {
+			problems = append(problems, errs...)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+			os.Exit(exitConfig)
+		}
+	}
 
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	// flags that were given explicitly take precedence over the file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			conf.ICAP.Listen = *listen
+		case "apilisten":
+			conf.API.Listen = *apiListen
+		case "whitelist":
+			conf.Lists.Whitelist = *whitelist
+		case "blacklist":
+			conf.Lists.Blacklist = *blacklist
+		case "cachesize":
+			conf.Cache.Size = *cacheSize
+		case "cachettl":
+			conf.Cache.TTL = *cacheTTL
+		case "shutdowntimeout":
+			conf.ShutdownTimeout = *shutdownTimeout
+		case "verbose":
+			if *verbose {
+				conf.Logging.Level = zerolog.DebugLevel.String()
+			}
+		}
+	})
+	// debug is more verbose than verbose, so it wins regardless of order
+	if *debug {
+		conf.Logging.Level = zerolog.TraceLevel.String()
+	}
 
-	if *verbose {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	conf.Resolve()
+	problems = append(problems, conf.Validate()...)
+	source := *configPath
+	if source == "" {
+		source = "flags"
 	}
-	if *debug {
-		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", source, p)
+	}
+	if len(problems) > 0 {
+		os.Exit(exitConfig)
 	}
+	if *checkConfig {
+		fmt.Printf("configuration ok\n")
+		os.Exit(exitOK)
+	}
+
+	setupLogging(conf.Logging)
 
-	rc, err := rule.NewRuleConfig(*whitelist, *blacklist)
+	err = rule.RuleManager.ConfigureCache(conf.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("could not configure decision cache")
+		os.Exit(exitConfig)
+	}
+
+	err = rule.RuleManager.ConfigureHistory(
+		filepath.Join(conf.Storage.Dir, "history"))
+	if err != nil {
+		log.Error().Err(err).Msg("could not load rules history")
+		os.Exit(exitConfig)
+	}
+
+	rc, err := conf.RuleConfig()
 	if err != nil {
 		log.Error().Err(err).Msg("could not load rule config")
-		os.Exit(1)
+		os.Exit(exitConfig)
+	}
+
+	err = rule.RuleManager.Update(rc)
+	if err != nil {
+		log.Error().Err(err).Msg("could not apply rule config")
+		os.Exit(exitConfig)
 	}
 
-	rule.RuleManager.Update(rc)
+	apiServer, err := api.NewServer(conf.API)
+	if err != nil {
+		log.Error().Err(err).Msg("could not configure api")
+		os.Exit(exitConfig)
+	}
+
+	log.Info().
+		Str("address", conf.ICAP.Listen).
+		Str("api_address", conf.API.Listen).
+		Msg("starting babysitter")
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM)
 
-	log.Info().Str("address", *listen).Msg("starting babysitter")
-	defer log.Info().Msg("stopping")
+	s := supervisor.New(conf.ShutdownTimeout)
+	s.Add("icap", icap.NewServer(conf.ICAP))
+	s.Add("api", apiServer)
 
-	done := &sync.WaitGroup{}
+	err = s.Run(ctx)
+	stop()
+	switch {
+	case err == nil:
+		log.Info().Msg("stopped")
+		os.Exit(exitOK)
+	case errors.Is(err, supervisor.ErrShutdownTimeout):
+		log.Error().Err(err).Msg("stopped without draining")
+		os.Exit(exitShutdown)
+	default:
+		log.Error().Err(err).Msg("stopped")
+		os.Exit(exitListener)
+	}
+}
 
-	done.Add(1)
-	go icap.Start(*listen, done)
-	go api.Start(*apiListen)
+// setupLogging configures the global logger, the configuration has already
+// been validated
+func setupLogging(conf config.LoggingConfig) {
+	console := conf.Format == "console" ||
+		(conf.Format == "auto" && isatty.IsTerminal(os.Stdout.Fd()))
+	if console {
+		log.Logger = log.Output(zerolog.ConsoleWriter{
+			Out:        os.Stderr,
+			TimeFormat: time.RFC3339,
+		})
+	}
+
+	log.Logger = log.With().Caller().Logger()
 
-	done.Wait()
+	level, _ := zerolog.ParseLevel(conf.Level)
+	zerolog.SetGlobalLevel(level)
 }
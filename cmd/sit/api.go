@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jcline/babysitter/client"
+)
+
+// babysitter records changes made through sit as such
+const userAgent = "sit/1.0"
+
+// newClient builds the api client for conf
+func newClient(conf *clientConfig) (*client.Client, error) {
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	c := client.New(baseURL(conf))
+	c.HTTPClient = httpClient
+	c.UserAgent = userAgent
+	c.Token = conf.Token
+	c.User = conf.User
+	c.Password = conf.Password
+	return c, nil
+}
+
+// describe explains a failed request in terms of what to do about it,
+// listing every invalid entry of a rejected change
+func describe(err error) error {
+	var failure *client.Error
+	if !errors.As(err, &failure) {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, client.ErrUnauthorized):
+		return fmt.Errorf("authentication failed, set a token with "+
+			"`sit config set token` or $%s", tokenEnv)
+	case errors.Is(err, client.ErrConflict):
+		return fmt.Errorf("%v, try again", client.ErrConflict)
+	case failure.StatusCode != http.StatusBadRequest || failure.Message == "":
+		return err
+	case len(failure.Fields) == 0:
+		return fmt.Errorf("rejected: %s", failure.Message)
+	}
+
+	var b strings.Builder
+	b.WriteString("rejected:")
+	for _, e := range failure.Fields {
+		fmt.Fprintf(&b, "\n  %s: %s", e.Field, e.Message)
+		if e.Value != "" {
+			fmt.Fprintf(&b, " (%q)", e.Value)
+		}
+	}
+	return fmt.Errorf("%s", b.String())
+}
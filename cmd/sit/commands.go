@@ -0,0 +1,652 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcline/babysitter/client"
+)
+
+// timeFormat is how times are shown in tables
+const timeFormat = "2006-01-02 15:04:05"
+
+// ctx is the context of every request, sit has no deadlines of its own
+var ctx = context.Background()
+
+func rulesCommand(s *session, args []string) error {
+	usage := commands["rules"].usage
+	if len(args) == 0 {
+		return usagef(usage, "missing subcommand")
+	}
+
+	switch args[0] {
+	case "list":
+		return rulesList(s, args[1:])
+	case "add":
+		return rulesEdit(s, "add", args[1:])
+	case "rm":
+		return rulesEdit(s, "rm", args[1:])
+	case "history":
+		return rulesHistory(s, args[1:])
+	}
+	return usagef(usage, "unknown subcommand %s", args[0])
+}
+
+func rulesList(s *session, args []string) error {
+	args, err := s.parse(s.flags("rules"), args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return usagef(commands["rules"].usage, "too many arguments")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		list, err := conf.api.List(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return p.print(list, func(w io.Writer) {
+			for _, d := range list.Domains {
+				fmt.Fprintf(w, "%s\n", d)
+			}
+		})
+	}
+
+	rc, _, err := conf.api.Rules(ctx)
+	if err != nil {
+		return err
+	}
+	return p.print(rc, func(w io.Writer) {
+		fmt.Fprintf(w, "LIST\tDOMAIN\n")
+		for _, d := range rc.Blacklist {
+			fmt.Fprintf(w, "blacklist\t%s\n", d)
+		}
+		for _, d := range rc.Whitelist {
+			fmt.Fprintf(w, "whitelist\t%s\n", d)
+		}
+		for _, c := range rc.Categories {
+			for _, d := range c.Domains {
+				fmt.Fprintf(w, "category:%s\t%s\n", c.Name, d)
+			}
+		}
+	})
+}
+
+// rulesEdit adds or removes domains from a list, with -overwrite add
+// replaces the list
+func rulesEdit(s *session, op string, args []string) error {
+	fs := s.flags("rules")
+	overwrite := false
+	if op == "add" {
+		fs.BoolVar(&overwrite, "overwrite", false,
+			"replace the list with the domains given, do not append")
+	}
+	args, err := s.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 && !(overwrite && len(args) == 1) {
+		return usagef(commands["rules"].usage, "need a list and domains")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	list, domains := args[0], args[1:]
+	var change *client.ListChange
+	switch {
+	case overwrite:
+		change, err = conf.api.ReplaceList(ctx, list, domains, client.AnyVersion)
+	case op == "add":
+		change, err = conf.api.EditList(ctx, list,
+			client.ListEdit{Add: domains}, client.AnyVersion)
+	default:
+		change, err = conf.api.EditList(ctx, list,
+			client.ListEdit{Remove: domains}, client.AnyVersion)
+	}
+	if err != nil {
+		return err
+	}
+
+	return p.print(change, func(w io.Writer) {
+		if !change.Changed() {
+			fmt.Fprintf(w, "%s: unchanged\n", change.List)
+			return
+		}
+		fmt.Fprintf(w, "%s:\n", change.List)
+		for _, d := range change.Added {
+			fmt.Fprintf(w, "+ %s\n", d)
+		}
+		for _, d := range change.Removed {
+			fmt.Fprintf(w, "- %s\n", d)
+		}
+	})
+}
+
+// printSnapshots prints versions of the rules with their diffs
+func printSnapshots(p *printer, v interface{}, snapshots []client.Snapshot) error {
+	return p.print(v, func(w io.Writer) {
+		fmt.Fprintf(w, "VERSION\tTIME\tAUTHOR\tSOURCE\tCHANGE\n")
+		for _, snapshot := range snapshots {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t",
+				snapshot.Version,
+				snapshot.Time.Local().Format(timeFormat),
+				snapshot.Author, snapshot.Source)
+			if len(snapshot.Diff) == 0 {
+				fmt.Fprintf(w, "\n")
+			}
+			for i, d := range snapshot.Diff {
+				if i > 0 {
+					fmt.Fprintf(w, "\t\t\t\t")
+				}
+				op := map[string]string{"add": "+", "remove": "-"}[d.Op]
+				if op == "" {
+					op = "~"
+				}
+				fmt.Fprintf(w, "%s %s %s\n", op, d.Section, d.Item)
+			}
+		}
+	})
+}
+
+func rulesHistory(s *session, args []string) error {
+	fs := s.flags("rules")
+	limit := fs.Int("limit", 10, "how many versions to show")
+	args, err := s.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef(commands["rules"].usage, "too many arguments")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := conf.api.History(ctx, *limit)
+	if err != nil {
+		return err
+	}
+	return printSnapshots(p, snapshots, snapshots)
+}
+
+func rollbackCommand(s *session, args []string) error {
+	args, err := s.parse(s.flags("rollback"), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef(commands["rollback"].usage, "need a single version")
+	}
+	version, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return usagef(commands["rollback"].usage,
+			"'%s' is not a version", args[0])
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := conf.api.Rollback(ctx, version, client.AnyVersion)
+	if err != nil {
+		return err
+	}
+	return printSnapshots(p, snapshot, []client.Snapshot{*snapshot})
+}
+
+func statusCommand(s *session, args []string) error {
+	args, err := s.parse(s.flags("status"), args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef(commands["status"].usage, "too many arguments")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	status, err := conf.api.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	return p.print(status, func(w io.Writer) {
+		fmt.Fprintf(w, "started\t%s\n", status.Started.Local().Format(timeFormat))
+		fmt.Fprintf(w, "uptime\t%s\n", status.Uptime)
+		fmt.Fprintf(w, "rules version\t%d\n", status.RulesVersion)
+
+		var lists []string
+		for name := range status.Lists {
+			lists = append(lists, name)
+		}
+		sort.Strings(lists)
+		for _, name := range lists {
+			fmt.Fprintf(w, "%s\t%d domains\n", name, status.Lists[name])
+		}
+
+		fmt.Fprintf(w, "groups\t%d\n", status.Groups)
+		fmt.Fprintf(w, "schedules\t%d\n", status.Schedules)
+		fmt.Fprintf(w, "categories\t%d\n", status.Categories)
+		fmt.Fprintf(w, "overrides\t%d\n", status.Overrides)
+		fmt.Fprintf(w, "cache\t%d/%d entries, %d hits, %d misses\n",
+			status.Cache.Entries, status.Cache.Capacity,
+			status.Cache.Hits, status.Cache.Misses)
+	})
+}
+
+func logCommand(s *session, args []string) error {
+	fs := s.flags("log")
+	limit := fs.Int("limit", 20, "how many decisions to show")
+	clientIP := fs.String("client", "", "only show decisions for this client")
+	verdict := fs.String("verdict", "", "only show allow or deny decisions")
+	args, err := s.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef(commands["log"].usage, "too many arguments")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	decisions, err := conf.api.Log(ctx, client.LogQuery{
+		Limit:   *limit,
+		Client:  *clientIP,
+		Verdict: *verdict,
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.print(decisions, func(w io.Writer) {
+		fmt.Fprintf(w, "TIME\tCLIENT\tHOST\tVERDICT\tRULE\n")
+		for _, d := range decisions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				d.Time.Local().Format(timeFormat),
+				clientOf(&d), d.Host, d.Verdict(), decidedBy(&d))
+		}
+	})
+}
+
+// clientOf names the client of a decision, with the user it authenticated
+// to the proxy as if there is one
+func clientOf(d *client.Decision) string {
+	if d.User == "" {
+		return d.Client
+	}
+	return d.User + "@" + d.Client
+}
+
+// decidedBy is the rule that made a decision, with what a content rule
+// found
+func decidedBy(d *client.Decision) string {
+	if d.Reason == "" {
+		return d.Rule
+	}
+	if d.Rule == "content:score" {
+		return fmt.Sprintf("%s: %s: %s",
+			d.Rule, d.Reason, strings.Join(d.Terms, ", "))
+	}
+	return fmt.Sprintf("%s: %s", d.Rule, d.Reason)
+}
+
+func explainCommand(s *session, args []string) error {
+	fs := s.flags("explain")
+	clientIP := fs.String("client", "", "explain the decision for this client")
+	args, err := s.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef(commands["explain"].usage, "need a single url")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	explanation, err := conf.api.Explain(ctx, args[0], *clientIP)
+	if err != nil {
+		return err
+	}
+
+	return p.print(explanation, func(w io.Writer) {
+		if explanation.Group != "" {
+			fmt.Fprintf(w, "client %s is in group %s\n\n",
+				explanation.Client, explanation.Group)
+		}
+		fmt.Fprintf(w, "RULE\tRESULT\n")
+		for _, step := range explanation.Steps {
+			fmt.Fprintf(w, "%s\t%s\n", step.Rule, step.Result)
+		}
+
+		verdict := "deny"
+		if explanation.Decision.Allow {
+			verdict = "allow"
+		}
+		fmt.Fprintf(w, "\n%s by %s\n", verdict, explanation.Decision.Rule)
+	})
+}
+
+func overrideCommand(s *session, args []string) error {
+	usage := commands["override"].usage
+	if len(args) == 0 {
+		return overrideList(s, args)
+	}
+
+	switch args[0] {
+	case "list":
+		return overrideList(s, args[1:])
+	case "allow", "deny":
+		return overrideAdd(s, args[0], args[1:])
+	case "rm":
+		return overrideRemove(s, args[1:])
+	}
+	return usagef(usage, "unknown subcommand %s", args[0])
+}
+
+func printOverrides(p *printer, v interface{}, overrides []client.Override) error {
+	return p.print(v, func(w io.Writer) {
+		fmt.Fprintf(w, "ID\tDOMAIN\tACTION\tGROUP\tEXPIRES\tBY\n")
+		for _, o := range overrides {
+			group := o.Group
+			if group == "" {
+				group = "*"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				o.ID, o.Domain, o.Action, group,
+				o.Expires.Local().Format(timeFormat), o.By)
+		}
+	})
+}
+
+func overrideList(s *session, args []string) error {
+	args, err := s.parse(s.flags("override"), args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef(commands["override"].usage, "too many arguments")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	overrides, err := conf.api.Overrides(ctx)
+	if err != nil {
+		return err
+	}
+	return printOverrides(p, overrides, overrides)
+}
+
+func overrideAdd(s *session, action string, args []string) error {
+	fs := s.flags("override")
+	duration := fs.Duration("for", time.Hour, "how long the override lasts")
+	group := fs.String("group", "", "only override for this group")
+	args, err := s.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef(commands["override"].usage, "need a single domain")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	o, err := conf.api.AddOverride(ctx, args[0], action, *group, *duration)
+	if err != nil {
+		return err
+	}
+	return printOverrides(p, o, []client.Override{*o})
+}
+
+func overrideRemove(s *session, args []string) error {
+	args, err := s.parse(s.flags("override"), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef(commands["override"].usage, "need a single id")
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return usagef(commands["override"].usage, "'%s' is not an id", args[0])
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	err = conf.api.RemoveOverride(ctx, id)
+	if err != nil {
+		return err
+	}
+	return p.print(map[string]uint64{"removed": id}, func(w io.Writer) {
+		fmt.Fprintf(w, "removed override %d\n", id)
+	})
+}
+
+func configCommand(s *session, args []string) error {
+	usage := commands["config"].usage
+	args, err := s.parse(s.flags("config"), args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] == "show" {
+		return configShow(s)
+	}
+
+	var key, value string
+	switch {
+	case args[0] == "set" && len(args) == 3:
+		key, value = args[1], args[2]
+	case args[0] == "unset" && len(args) == 2:
+		key = args[1]
+	default:
+		return usagef(usage, "invalid arguments")
+	}
+
+	// edit the file as it is, without the options or the environment
+	explicit := s.opts.config != defaultConfigPath()
+	conf, err := readClientConfig(s.opts.config, explicit)
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	err = conf.set(strings.ToLower(key), value)
+	if err != nil {
+		return usagef(usage, "%v", err)
+	}
+	err = writeClientConfig(s.opts.config, conf)
+	if err != nil {
+		return fmt.Errorf("could not save config: %v", err)
+	}
+	return nil
+}
+
+func configShow(s *session) error {
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	shown := conf.redacted()
+	return p.print(shown, func(w io.Writer) {
+		fmt.Fprintf(w, "config\t%s\n", s.opts.config)
+		fmt.Fprintf(w, "host\t%s\n", shown.Host)
+		fmt.Fprintf(w, "api\t%s\n", baseURL(conf))
+		fmt.Fprintf(w, "output\t%s\n", shown.Output)
+		fmt.Fprintf(w, "token\t%s\n", shown.Token)
+		fmt.Fprintf(w, "user\t%s\n", shown.User)
+		fmt.Fprintf(w, "password\t%s\n", shown.Password)
+		fmt.Fprintf(w, "tls\t%t\n", shown.TLS)
+		fmt.Fprintf(w, "ca\t%s\n", shown.CA)
+		fmt.Fprintf(w, "fingerprint\t%s\n", shown.Fingerprint)
+	})
+}
+
+func watchCommand(s *session, args []string) error {
+	fs := s.flags("watch")
+	typ := fs.String("type", "", "only show decision or rules events")
+	clientIP := fs.String("client", "", "only show decisions for this client")
+	verdict := fs.String("verdict", "", "only show allow or deny decisions")
+	args, err := s.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef(commands["watch"].usage, "too many arguments")
+	}
+
+	conf, err := s.config()
+	if err != nil {
+		return err
+	}
+	p, err := s.printer()
+	if err != nil {
+		return err
+	}
+
+	q := client.WatchQuery{Client: *clientIP, Verdict: *verdict}
+	if *typ != "" {
+		q.Types = []string{*typ}
+	}
+
+	// interrupting is how watching is supposed to end
+	watchCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	err = conf.api.Watch(watchCtx, q, func(e *client.Event) error {
+		return printEvent(p, e)
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	if err == nil {
+		return fmt.Errorf("babysitter closed the stream")
+	}
+	return err
+}
+
+// printEvent prints a live event as a line, or as its JSON
+func printEvent(p *printer, e *client.Event) error {
+	switch e.Type {
+	case client.EventDecision:
+		d, err := e.Decision()
+		if err != nil {
+			return err
+		}
+		return p.stream(e, func(w io.Writer) {
+			fmt.Fprintf(w, "%s  %-15s  %-5s  %s  (%s)\n",
+				d.Time.Local().Format(timeFormat),
+				clientOf(d), d.Verdict(), d.Host, decidedBy(d))
+		})
+	case client.EventRules:
+		snapshot, err := e.Snapshot()
+		if err != nil {
+			return err
+		}
+		return p.stream(e, func(w io.Writer) {
+			by := snapshot.Source
+			if snapshot.Author != "" {
+				by = snapshot.Author + " via " + snapshot.Source
+			}
+			fmt.Fprintf(w, "%s  rules version %d by %s\n",
+				snapshot.Time.Local().Format(timeFormat),
+				snapshot.Version, by)
+			for _, d := range snapshot.Diff {
+				op := map[string]string{"add": "+", "remove": "-"}[d.Op]
+				if op == "" {
+					op = "~"
+				}
+				fmt.Fprintf(w, "    %s %s %s\n", op, d.Section, d.Item)
+			}
+		})
+	case client.EventDropped:
+		dropped, err := e.Dropped()
+		if err != nil {
+			return err
+		}
+		return p.stream(e, func(w io.Writer) {
+			fmt.Fprintf(w, "... %d events dropped, sit fell behind\n",
+				dropped.Dropped)
+		})
+	}
+	return nil
+}
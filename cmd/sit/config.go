@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jcline/babysitter/client"
+)
+
+// tokenEnv overrides the token in the configuration file
+const tokenEnv = "BABYSITTER_TOKEN"
+
+// defaultHost is used when neither the configuration nor -host name one
+const defaultHost = "localhost"
+
+// clientConfig holds where the api is, the credentials sit uses to talk to
+// it and how to verify the server
+type clientConfig struct {
+	Host        string `json:"host,omitempty"`
+	Output      string `json:"output,omitempty"`
+	Token       string `json:"token,omitempty"`
+	User        string `json:"user,omitempty"`
+	Password    string `json:"password,omitempty"`
+	TLS         bool   `json:"tls,omitempty"`
+	CA          string `json:"ca,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	api *client.Client
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "babysitter", "sit.json")
+}
+
+// readClientConfig reads the configuration at path, a missing file is only
+// an error if the path was given explicitly
+func readClientConfig(path string, explicit bool) (*clientConfig, error) {
+	conf := &clientConfig{}
+	if path == "" {
+		return conf, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		err = json.Unmarshal(contents, conf)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", path, err)
+		}
+	case os.IsNotExist(err) && !explicit:
+	default:
+		return nil, err
+	}
+	return conf, nil
+}
+
+// writeClientConfig saves conf to path, it is only readable by the owner
+// since it may hold credentials
+func writeClientConfig(path string, conf *clientConfig) error {
+	if path == "" {
+		return fmt.Errorf("no configuration path, use -config")
+	}
+
+	contents, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(contents, '\n'), 0600)
+}
+
+// set changes the setting called key
+func (c *clientConfig) set(key, value string) error {
+	switch key {
+	case "host":
+		c.Host = value
+	case "output":
+		if value != "" && value != outputTable && value != outputJSON {
+			return fmt.Errorf("output must be %s or %s", outputTable, outputJSON)
+		}
+		c.Output = value
+	case "token":
+		c.Token = value
+	case "user":
+		c.User = value
+	case "password":
+		c.Password = value
+	case "tls":
+		if value == "" {
+			c.TLS = false
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("tls must be true or false")
+		}
+		c.TLS = b
+	case "ca":
+		c.CA = value
+	case "fingerprint":
+		if value != "" {
+			if _, err := parseFingerprint(value); err != nil {
+				return err
+			}
+		}
+		c.Fingerprint = value
+	default:
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	return nil
+}
+
+// redacted returns a copy of c that is safe to print
+func (c *clientConfig) redacted() *clientConfig {
+	result := *c
+	if result.Token != "" {
+		result.Token = "<redacted>"
+	}
+	if result.Password != "" {
+		result.Password = "<redacted>"
+	}
+	return &result
+}
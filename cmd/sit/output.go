@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// printer writes results either as JSON for scripts or as a table for
+// people
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+// print writes v as indented JSON, or calls table to write it as tab
+// separated columns
+func (p *printer) print(v interface{}, table func(w io.Writer)) error {
+	if p.format == outputJSON {
+		encoded, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", encoded)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// stream writes v as a single line of JSON, or calls line to write it for
+// people. Unlike print nothing is buffered, so results show up as they
+// arrive.
+func (p *printer) stream(v interface{}, line func(w io.Writer)) error {
+	if p.format == outputJSON {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", encoded)
+		return err
+	}
+
+	line(p.w)
+	return nil
+}
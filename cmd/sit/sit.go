@@ -1,165 +1,288 @@
+// sit manages a babysitter through its api
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"os"
+	"sort"
 	"strings"
-
-	valid "github.com/asaskevich/govalidator"
-
-	"github.com/jcline/babysitter/internal/rule"
 )
 
-type strArray []string
+// exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
 
-func (sa *strArray) String() string {
-	return strings.Join([]string(*sa), ",")
+// usageError is returned by commands that were invoked incorrectly
+type usageError struct {
+	usage string
+	msg   string
 }
 
-func (sa *strArray) Set(value string) error {
-	values := strings.Split(value, ",")
-	for _, v := range values {
-		fmt.Printf("%s\n", v)
-		if !valid.IsDNSName(v) {
-			return fmt.Errorf("'%s' is not a valid hostname", v)
-		}
-	}
-	*sa = append(*sa, values...)
-	return nil
+func (ue *usageError) Error() string {
+	return ue.msg
 }
 
-func (sa *strArray) Len() int {
-	return len([]string(*sa))
+// errFlags is returned when the flag package already reported an error
+var errFlags = errors.New("invalid flags")
+
+func usagef(usage, format string, args ...interface{}) error {
+	return &usageError{usage: usage, msg: fmt.Sprintf(format, args...)}
 }
 
-func (sa *strArray) Merge(other []string) {
-	*sa = append(*sa, other...)
+// options are the flags every command accepts, they override the client
+// configuration
+type options struct {
+	config      string
+	host        string
+	output      string
+	tls         bool
+	ca          string
+	fingerprint string
 }
 
-type domain string
+// register adds the options to fs, the current values are the defaults so
+// a command's flags don't reset what was given before the command
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.config, "config", o.config,
+		"client configuration, $"+tokenEnv+" overrides its token")
+	fs.StringVar(&o.host, "host", o.host, "address of the babysitter api")
+	fs.StringVar(&o.output, "output", o.output,
+		"output format, "+outputTable+" or "+outputJSON)
+	fs.BoolVar(&o.tls, "tls", o.tls, "talk to the api over https")
+	fs.StringVar(&o.ca, "ca", o.ca, "PEM file with the CA to trust for the api")
+	fs.StringVar(&o.fingerprint, "fingerprint", o.fingerprint,
+		"sha256 fingerprint of the api certificate to pin")
+}
 
-func (d *domain) String() string {
-	return string(*d)
+// command is a sit subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(s *session, args []string) error
 }
 
-func (d *domain) Set(value string) error {
-	if !valid.IsURL(value) {
-		return fmt.Errorf("'%s' is not a valid url", value)
+var commands map[string]*command
+
+func init() {
+	commands = make(map[string]*command)
+	for _, c := range []*command{
+		{
+			name:    "rules",
+			usage:   "rules list [list] | add [-overwrite] <list> <domain>... | rm <list> <domain>... | history [-limit n]",
+			summary: "show and edit the domain lists",
+			run:     rulesCommand,
+		},
+		{
+			name:    "rollback",
+			usage:   "rollback <version>",
+			summary: "restore an earlier version of the rules",
+			run:     rollbackCommand,
+		},
+		{
+			name:    "status",
+			usage:   "status",
+			summary: "show the state of babysitter",
+			run:     statusCommand,
+		},
+		{
+			name:    "log",
+			usage:   "log [-limit n] [-client ip] [-verdict allow|deny]",
+			summary: "show recent decisions",
+			run:     logCommand,
+		},
+		{
+			name:    "watch",
+			usage:   "watch [-type decision|rules] [-client ip] [-verdict allow|deny]",
+			summary: "show decisions and rule changes as they happen",
+			run:     watchCommand,
+		},
+		{
+			name:    "explain",
+			usage:   "explain [-client ip] <url>",
+			summary: "show how the rules decide a url",
+			run:     explainCommand,
+		},
+		{
+			name:    "override",
+			usage:   "override [list] | allow|deny [-for duration] [-group group] <domain> | rm <id>",
+			summary: "temporarily allow or deny a domain",
+			run:     overrideCommand,
+		},
+		{
+			name:    "config",
+			usage:   "config [show] | set <key> <value> | unset <key>",
+			summary: "show and change the client configuration",
+			run:     configCommand,
+		},
+	} {
+		commands[c.name] = c
 	}
-	*d = domain(value)
-	return nil
 }
 
-type RuleRequest struct {
-	Rules map[string][]string `json:rules`
-}
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: sit [options] <command> [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
 
-func NewRuleRequest() *RuleRequest {
-	var rr RuleRequest
-	rr.Rules = make(map[string][]string)
-	return &rr
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintf(os.Stderr, "\noptions, also accepted after the command:\n")
+	fs := flag.NewFlagSet("sit", flag.ContinueOnError)
+	(&options{config: defaultConfigPath()}).register(fs)
+	fs.SetOutput(os.Stderr)
+	fs.PrintDefaults()
 }
 
-func printRules(rc *rule.RuleConfig) {
-	fmt.Printf("%s", rc)
+// session is the state shared by the commands of one invocation
+type session struct {
+	opts *options
+	conf *clientConfig
 }
 
-func getRules(host domain) (*rule.RuleConfig, error) {
-	rule := rule.RuleConfig{}
+// flags returns a flag set for the command called name that also accepts
+// the options
+func (s *session) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("sit "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	s.opts.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: sit %s\n", commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
 
-	response, err := http.Get(fmt.Sprintf("http://%s/rules", host))
+// parse parses the flags of a command and returns the remaining arguments
+func (s *session) parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	err := fs.Parse(args)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errFlags
 	}
+	return fs.Args(), nil
+}
 
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request failed with status %d", response.StatusCode)
+// config loads the client configuration the first time it is needed and
+// applies the options to it
+func (s *session) config() (*clientConfig, error) {
+	if s.conf != nil {
+		return s.conf, nil
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
+	explicit := s.opts.config != defaultConfigPath()
+	conf, err := readClientConfig(s.opts.config, explicit)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not load config: %v", err)
+	}
+
+	if token := os.Getenv(tokenEnv); token != "" {
+		conf.Token = token
+	}
+	if s.opts.host != "" {
+		conf.Host = s.opts.host
+	}
+	if conf.Host == "" {
+		conf.Host = defaultHost
+	}
+	if s.opts.output != "" {
+		conf.Output = s.opts.output
+	}
+	if conf.Output == "" {
+		conf.Output = outputTable
+	}
+	if conf.Output != outputTable && conf.Output != outputJSON {
+		return nil, usagef("", "output must be %s or %s",
+			outputTable, outputJSON)
+	}
+	conf.TLS = conf.TLS || s.opts.tls
+	if s.opts.ca != "" {
+		conf.CA = s.opts.ca
+	}
+	if s.opts.fingerprint != "" {
+		conf.Fingerprint = s.opts.fingerprint
 	}
 
-	err = json.Unmarshal(body, &rule)
+	conf.api, err = newClient(conf)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not set up tls: %v", err)
 	}
 
-	return &rule, nil
+	s.conf = conf
+	return conf, nil
 }
 
-func updateRules(host domain, blacklist, whitelist strArray) error {
-	rc, err := getRules(host)
+// printer returns a printer for the configured output format
+func (s *session) printer() (*printer, error) {
+	conf, err := s.config()
 	if err != nil {
-		return fmt.Errorf("could not get rules: %v", err)
+		return nil, err
 	}
+	return &printer{format: conf.Output, w: os.Stdout}, nil
+}
 
-	rules := NewRuleRequest()
-	if len(blacklist) > 0 {
-		if rc.Blacklist != nil {
-			blacklist.Merge(rc.Blacklist)
-		}
-		rules.Rules["blacklist"] = blacklist
-	}
+func run(args []string) int {
+	s := &session{opts: &options{config: defaultConfigPath()}}
 
-	if len(whitelist) > 0 {
-		if rc.Whitelist != nil {
-			whitelist.Merge(rc.Whitelist)
+	fs := flag.NewFlagSet("sit", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = usage
+	s.opts.register(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
 		}
-		rules.Rules["whitelist"] = whitelist
+		return exitUsage
 	}
 
-	body, err := json.Marshal(rules)
-	if err != nil {
-		return fmt.Errorf("could not build request: %v", err)
+	args = fs.Args()
+	if len(args) == 0 {
+		usage()
+		return exitUsage
 	}
-
-	response, err := http.Post(
-		fmt.Sprintf("http://%s/rules", host),
-		"application/json",
-		bytes.NewReader(body),
-	)
-	if err != nil {
-		return fmt.Errorf("request failed: %v", err)
+	c, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "sit: unknown command %s\n\n", args[0])
+		usage()
+		return exitUsage
 	}
 
-	switch response.StatusCode {
-	case http.StatusOK:
-		fmt.Printf("success\n")
+	err = c.run(s, args[1:])
+	var ue *usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errFlags):
+		return exitUsage
+	case errors.As(err, &ue):
+		fmt.Fprintf(os.Stderr, "sit %s: %s\n", c.name, ue.msg)
+		if ue.usage != "" {
+			fmt.Fprintf(os.Stderr, "usage: sit %s\n", ue.usage)
+		}
+		return exitUsage
 	default:
-		return fmt.Errorf("shit's broke capn'")
+		fmt.Fprintf(os.Stderr, "sit %s: %s\n", c.name,
+			strings.TrimSpace(describe(err).Error()))
+		return exitFailure
 	}
-
-	return nil
 }
 
 func main() {
-	var whitelist strArray
-	var blacklist strArray
-	var host domain
-
-	flag.Bool("overwrite", false, "replace rules, do not append")
-	flag.Var(&whitelist, "whitelsist", "whitelist domain[s]")
-	flag.Var(&blacklist, "blacklist", "blacklist domain[s]")
-	flag.Var(&host, "host", "where to send the request")
-	flag.Parse()
-
-	if len(blacklist) > 0 || len(whitelist) > 0 {
-		err := updateRules(host, blacklist, whitelist)
-		if err != nil {
-			fmt.Printf("could not update rules: %v\n", err)
-		}
-	} else {
-		rc, err := getRules(host)
-		if err != nil {
-			fmt.Printf("could not get rules: %v\n", err)
-		}
-
-		printRules(rc)
-	}
+	os.Exit(run(os.Args[1:]))
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// parseFingerprint accepts a sha256 fingerprint as printed by babysitter,
+// with or without colons and in either case
+func parseFingerprint(value string) ([]byte, error) {
+	clean := strings.ToLower(strings.Replace(value, ":", "", -1))
+	fingerprint, err := hex.DecodeString(clean)
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("'%s' is not a sha256 fingerprint", value)
+	}
+	return fingerprint, nil
+}
+
+// newHTTPClient builds the client used to talk to the api. A CA file
+// replaces the system roots, a fingerprint pins the server certificate and
+// replaces chain verification altogether.
+func newHTTPClient(conf *clientConfig) (*http.Client, error) {
+	if conf.CA == "" && conf.Fingerprint == "" {
+		return http.DefaultClient, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if conf.CA != "" {
+		pem, err := ioutil.ReadFile(conf.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", conf.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if conf.Fingerprint != "" {
+		fingerprint, err := parseFingerprint(conf.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(
+			rawCerts [][]byte,
+			_ [][]*x509.Certificate,
+		) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("server sent no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], fingerprint) {
+				return fmt.Errorf(
+					"server certificate does not match the pinned fingerprint")
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
+
+// baseURL turns the configured host into the api root, an explicit scheme
+// is kept and otherwise https is used whenever the client was told how to
+// verify the server
+func baseURL(conf *clientConfig) string {
+	h := strings.TrimSuffix(conf.Host, "/")
+	if strings.HasPrefix(h, "http://") || strings.HasPrefix(h, "https://") {
+		return h
+	}
+	if conf.TLS || conf.CA != "" || conf.Fingerprint != "" {
+		return "https://" + h
+	}
+	return "http://" + h
+}
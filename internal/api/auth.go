@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const tokenHashPrefix = "sha256:"
+
+// TokenConfig is a bearer token, only the hash of the token is stored
+type TokenConfig struct {
+	Name string `yaml:"name"`
+	// Hash is "sha256:" followed by the hex encoded digest of the token,
+	// see HashToken
+	Hash string `yaml:"hash"`
+	// Role is admin, viewer or child, admin if empty
+	Role string `yaml:"role"`
+	// Group is the client group a child belongs to
+	Group string `yaml:"group"`
+}
+
+// UserConfig is a user allowed to use HTTP basic authentication
+type UserConfig struct {
+	Name string `yaml:"name"`
+	// Password is a bcrypt hash of the password
+	Password string `yaml:"password"`
+	// Role is admin, viewer or child, admin if empty
+	Role string `yaml:"role"`
+	// Group is the client group a child belongs to
+	Group string `yaml:"group"`
+}
+
+// AuthConfig lists the credentials accepted by the API, if it is empty the
+// API does not require authentication
+type AuthConfig struct {
+	Tokens []TokenConfig `yaml:"tokens"`
+	Users  []UserConfig  `yaml:"users"`
+}
+
+// Principal is whoever made an authenticated request
+type Principal struct {
+	Name string `json:"name"`
+	// Method is how they authenticated, token, basic or none if
+	// authentication is disabled
+	Method string `json:"method"`
+	Role   Role   `json:"role"`
+	Group  string `json:"group,omitempty"`
+}
+
+type principalKey struct{}
+
+// PrincipalFromRequest returns who made request, it is nil if the request
+// didn't pass through the authentication handler
+func PrincipalFromRequest(request *http.Request) *Principal {
+	p, _ := request.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+// GenerateToken returns a new random token
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the value to store in TokenConfig.Hash for token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return tokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// HashPassword returns the value to store in UserConfig.Password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(
+		[]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// ValidateTokenHash checks that hash looks like the result of HashToken
+func ValidateTokenHash(hash string) error {
+	if !strings.HasPrefix(hash, tokenHashPrefix) {
+		return fmt.Errorf("token hash must start with %q", tokenHashPrefix)
+	}
+	digest, err := hex.DecodeString(strings.TrimPrefix(hash, tokenHashPrefix))
+	if err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("token hash must be %d hex encoded bytes",
+			sha256.Size)
+	}
+	return nil
+}
+
+// ValidatePasswordHash checks that hash is a bcrypt hash
+func ValidatePasswordHash(hash string) error {
+	_, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return fmt.Errorf("password must be a bcrypt hash: %v", err)
+	}
+	return nil
+}
+
+// Authenticator checks the credentials of API requests
+type Authenticator struct {
+	// tokens maps the digest of each token to who it belongs to
+	tokens map[[sha256.Size]byte]*Principal
+	users  map[string]*user
+	// dummy is compared against when a user doesn't exist, so that unknown
+	// users take as long to reject as wrong passwords
+	dummy []byte
+}
+
+func NewAuthenticator(conf AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		tokens: make(map[[sha256.Size]byte]*Principal),
+		users:  make(map[string]*user),
+	}
+
+	for _, t := range conf.Tokens {
+		if err := ValidateTokenHash(t.Hash); err != nil {
+			return nil, fmt.Errorf("token %s: %v", t.Name, err)
+		}
+		role, err := ParseRole(t.Role)
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %v", t.Name, err)
+		}
+		var digest [sha256.Size]byte
+		hex.Decode(digest[:], []byte(strings.TrimPrefix(t.Hash, tokenHashPrefix)))
+		a.tokens[digest] = &Principal{
+			Name:   t.Name,
+			Method: "token",
+			Role:   role,
+			Group:  t.Group,
+		}
+	}
+
+	for _, u := range conf.Users {
+		if err := ValidatePasswordHash(u.Password); err != nil {
+			return nil, fmt.Errorf("user %s: %v", u.Name, err)
+		}
+		role, err := ParseRole(u.Role)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %v", u.Name, err)
+		}
+		a.users[u.Name] = &user{
+			hash: []byte(u.Password),
+			principal: &Principal{
+				Name:   u.Name,
+				Method: "basic",
+				Role:   role,
+				Group:  u.Group,
+			},
+		}
+	}
+
+	if len(a.users) > 0 {
+		var err error
+		a.dummy, err = bcrypt.GenerateFromPassword(
+			[]byte("dummy"), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+type user struct {
+	hash      []byte
+	principal *Principal
+}
+
+// Enabled reports whether any credentials are configured
+func (a *Authenticator) Enabled() bool {
+	return len(a.tokens) > 0 || len(a.users) > 0
+}
+
+func (a *Authenticator) authenticate(request *http.Request) (*Principal, error) {
+	header := request.Header.Get("Authorization")
+	if header == "" {
+		return nil, fmt.Errorf("no credentials")
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+	scheme := parts[0]
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed bearer credentials")
+		}
+		digest := sha256.Sum256([]byte(strings.TrimSpace(parts[1])))
+		p, ok := a.tokens[digest]
+		if !ok {
+			return nil, fmt.Errorf("unknown token")
+		}
+		return p, nil
+	case "basic":
+		name, password, ok := request.BasicAuth()
+		if !ok {
+			return nil, fmt.Errorf("malformed basic credentials")
+		}
+		hash := a.dummy
+		u, known := a.users[name]
+		if known {
+			hash = u.hash
+		}
+		err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+		if err != nil || !known {
+			return nil, fmt.Errorf("invalid password for %q", name)
+		}
+		return u.principal, nil
+	}
+
+	return nil, fmt.Errorf("unsupported authorization scheme %q", scheme)
+}
+
+// challenge lists the schemes the client may use in WWW-Authenticate.
+// Basic is left out for scripts in a browser, like the dashboard, since
+// browsers answer it with a password prompt of their own.
+func (a *Authenticator) challenge(request *http.Request) string {
+	challenges := []string{`Bearer realm="babysitter"`}
+	if len(a.users) > 0 && request.Header.Get("X-Requested-With") == "" {
+		challenges = append(challenges, `Basic realm="babysitter"`)
+	}
+	return strings.Join(challenges, ", ")
+}
+
+// refuse writes a 401 or 403 response and logs why
+func refuse(
+	response http.ResponseWriter,
+	request *http.Request,
+	status int,
+	reason string,
+) {
+	event := hlog.FromRequest(request).Warn().
+		Int("status", status).
+		Str("reason", reason)
+	if p := PrincipalFromRequest(request); p != nil {
+		event.Str("principal", p.Name)
+	}
+	event.Msg("refused api request")
+
+	http.Error(response, http.StatusText(status), status)
+}
+
+// handler rejects requests without valid credentials and records who made
+// the others
+func (a *Authenticator) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		// without authentication everyone is an admin, as they were
+		// before authentication existed
+		p := &Principal{Name: "anonymous", Method: "none", Role: RoleAdmin}
+		if a.Enabled() {
+			var err error
+			p, err = a.authenticate(request)
+			if err != nil {
+				response.Header().Set("WWW-Authenticate", a.challenge(request))
+				refuse(response, request, http.StatusUnauthorized,
+					err.Error())
+				return
+			}
+		}
+
+		hlog.FromRequest(request).UpdateContext(
+			func(c zerolog.Context) zerolog.Context {
+				return c.Str("principal", p.Name).
+					Str("role", string(p.Role))
+			})
+		ctx := context.WithValue(request.Context(), principalKey{}, p)
+		next.ServeHTTP(response, request.WithContext(ctx))
+	})
+}
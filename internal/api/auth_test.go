@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Authenticator(t *testing.T) {
+	password, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	auth, err := NewAuthenticator(AuthConfig{
+		Tokens: []TokenConfig{{Name: "phone", Hash: HashToken("secret")}},
+		Users:  []UserConfig{{Name: "parent", Password: password}},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	var seen *Principal
+	handler := auth.handler(http.HandlerFunc(
+		func(response http.ResponseWriter, request *http.Request) {
+			seen = PrincipalFromRequest(request)
+		}))
+
+	tc := []struct {
+		name      string
+		setup     func(*http.Request)
+		status    int
+		principal string
+	}{
+		{
+			name:   "no credentials",
+			setup:  func(*http.Request) {},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "valid token",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer secret")
+			},
+			status:    http.StatusOK,
+			principal: "phone",
+		},
+		{
+			name: "wrong token",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer guess")
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "valid password",
+			setup: func(r *http.Request) {
+				r.SetBasicAuth("parent", "hunter2")
+			},
+			status:    http.StatusOK,
+			principal: "parent",
+		},
+		{
+			name: "wrong password",
+			setup: func(r *http.Request) {
+				r.SetBasicAuth("parent", "hunter3")
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown user",
+			setup: func(r *http.Request) {
+				r.SetBasicAuth("child", "hunter2")
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tc {
+		seen = nil
+		request := httptest.NewRequest("GET", "http://localhost/rules", nil)
+		test.setup(request)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Fatalf("%s got %d wanted %d", test.name, recorder.Code, test.status)
+		}
+		if test.status == http.StatusUnauthorized &&
+			recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("%s got no challenge", test.name)
+		}
+		if test.principal != "" &&
+			(seen == nil || seen.Name != test.principal) {
+			t.Fatalf("%s got %+v wanted %s", test.name, seen, test.principal)
+		}
+	}
+}
+
+func Test_Authenticator_Disabled(t *testing.T) {
+	auth, err := NewAuthenticator(AuthConfig{})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	auth.handler(http.HandlerFunc(
+		func(response http.ResponseWriter, request *http.Request) {}),
+	).ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost/rules", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got %d wanted %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func Test_Authenticator_Challenge(t *testing.T) {
+	password, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	auth, err := NewAuthenticator(AuthConfig{
+		Users: []UserConfig{{Name: "parent", Password: password}},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	handler := auth.handler(http.HandlerFunc(
+		func(response http.ResponseWriter, request *http.Request) {}))
+
+	request := httptest.NewRequest("GET", "http://localhost/rules", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if got := recorder.Header().Get("WWW-Authenticate"); !strings.Contains(got, "Basic") {
+		t.Fatalf("got %q wanted a Basic challenge", got)
+	}
+
+	// scripts in a browser don't want the browser to prompt for a password
+	request.Header.Set("X-Requested-With", "babysitter")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if got := recorder.Header().Get("WWW-Authenticate"); strings.Contains(got, "Basic") {
+		t.Fatalf("got %q wanted no Basic challenge", got)
+	}
+}
+
+func Test_NewAuthenticator_Invalid(t *testing.T) {
+	tests := []AuthConfig{
+		{Tokens: []TokenConfig{{Name: "a", Hash: "secret"}}},
+		{Tokens: []TokenConfig{{Name: "a", Hash: "sha256:abcd"}}},
+		{Users: []UserConfig{{Name: "a", Password: "hunter2"}}},
+	}
+
+	for _, conf := range tests {
+		if _, err := NewAuthenticator(conf); err == nil {
+			t.Fatalf("got nil wanted error for %+v", conf)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+func cacheHandler(response http.ResponseWriter, request *http.Request) {
+	writeJSON(response, request, http.StatusOK, rule.RuleManager.CacheStats())
+}
+
+func cacheFlushHandler(response http.ResponseWriter, request *http.Request) {
+	rule.RuleManager.FlushCache()
+	hlog.FromRequest(request).Info().Msg("flushed decision cache")
+	writeJSON(response, request, http.StatusOK, rule.RuleManager.CacheStats())
+}
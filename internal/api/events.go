@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/jcline/babysitter/internal/events"
+)
+
+// heartbeat is how often an idle event stream gets a comment, so that
+// proxies keep it open and a client that went away is noticed
+var heartbeat = 15 * time.Second
+
+// Dropped is sent on the event stream when events were dropped because the
+// client didn't keep up
+type Dropped struct {
+	// Dropped is how many events were dropped since the stream started
+	Dropped uint64 `json:"dropped"`
+}
+
+// eventsHandler streams live events as server-sent events. The type query
+// parameter selects decision or rules events, client and verdict filter
+// decisions like they do for /log. Streams end when done is closed, since
+// the server waits for them when it shuts down.
+func eventsHandler(done <-chan struct{}) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		streamEvents(done, response, request)
+	}
+}
+
+// streamEvents serves a single stream for eventsHandler
+func streamEvents(
+	done <-chan struct{},
+	response http.ResponseWriter,
+	request *http.Request,
+) {
+	query := request.URL.Query()
+
+	types := map[string]bool{}
+	if value := query.Get("type"); value != "" {
+		for _, t := range strings.Split(value, ",") {
+			if t != events.TypeDecision && t != events.TypeRules {
+				hlog.FromRequest(request).Error().
+					Str("type", t).
+					Msg("invalid event type")
+				response.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			types[t] = true
+		}
+	}
+	client := query.Get("client")
+	verdict := query.Get("verdict")
+	if verdict != "" && verdict != "allow" && verdict != "deny" {
+		hlog.FromRequest(request).Error().
+			Str("verdict", verdict).
+			Msg("invalid verdict")
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		hlog.FromRequest(request).Error().Msg("response can't be streamed")
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sub := events.Live.Subscribe(events.DefaultBuffer, func(e *events.Event) bool {
+		if len(types) > 0 && !types[e.Type] {
+			return false
+		}
+		d, ok := e.Data.(*events.Decision)
+		if !ok {
+			return true
+		}
+		return (client == "" || d.Client == client) &&
+			(verdict == "" || d.Verdict() == verdict)
+	})
+	defer sub.Close()
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	fmt.Fprintf(response, ": watching\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	var dropped uint64
+	for {
+		var err error
+		select {
+		case <-request.Context().Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err = fmt.Fprintf(response, ": heartbeat\n\n")
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if now := sub.Dropped(); now != dropped {
+				dropped = now
+				err = writeEvent(response, 0, "dropped", &Dropped{dropped})
+			}
+			if err == nil {
+				err = writeEvent(response, event.ID, event.Type, event.Data)
+			}
+		}
+		if err != nil {
+			hlog.FromRequest(request).Debug().
+				Err(err).
+				Msg("event stream closed")
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a single server-sent event, without an id if id is 0
+func writeEvent(
+	response http.ResponseWriter,
+	id uint64,
+	typ string,
+	data interface{},
+) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if id != 0 {
+		fmt.Fprintf(&b, "id: %d\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", typ, body)
+	_, err = response.Write([]byte(b.String()))
+	return err
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_Events_Shutdown(t *testing.T) {
+	server, err := NewServer(Config{Listen: "localhost:0"})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	go server.Serve(listener)
+
+	response, err := http.Get("http://" + listener.Addr().String() + "/events")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	defer response.Body.Close()
+	body := bufio.NewReader(response.Body)
+	line, err := body.ReadString('\n')
+	if err != nil || line != ": watching\n" {
+		t.Fatalf("got %q and %v wanted the stream to start", line, err)
+	}
+
+	// the stream ends rather than holding up the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if _, err := io.ReadAll(body); err != nil {
+		t.Fatalf("got %v wanted the stream to end", err)
+	}
+}
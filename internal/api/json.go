@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// writeJSON serializes v as the body of a response with status
+func writeJSON(
+	response http.ResponseWriter,
+	request *http.Request,
+	status int,
+	v interface{},
+) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		hlog.FromRequest(request).Error().
+			Err(err).
+			Msg("could not serialize response")
+		response.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	b, err := response.Write(body)
+	if b != len(body) || err != nil {
+		hlog.FromRequest(request).Error().
+			Int("written", b).
+			Int("expected", len(body)).
+			Err(err).
+			Msg("writing failed")
+	}
+}
+
+// readJSON deserializes the body of request into v, on failure it writes a
+// 400 response and returns false
+func readJSON(
+	response http.ResponseWriter,
+	request *http.Request,
+	v interface{},
+) bool {
+	err := json.NewDecoder(request.Body).Decode(v)
+	if err != nil {
+		hlog.FromRequest(request).Error().
+			Err(err).
+			Msg("could not deserialize request body")
+		response.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
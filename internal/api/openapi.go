@@ -0,0 +1,27 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPI describes the api, it has to be kept in step with the routes in
+// NewServer and the types they exchange
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// OpenAPI returns the OpenAPI document describing the api
+func OpenAPI() []byte {
+	return openAPI
+}
+
+// openAPIHandler serves the OpenAPI document, it needs no credentials
+func openAPIHandler(response http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		response.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.Write(openAPI)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jcline/babysitter/internal/apitest"
+	"github.com/jcline/babysitter/internal/events"
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+func Test_OpenAPI(t *testing.T) {
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components map[string]map[string]json.RawMessage `json:"components"`
+	}
+	err := json.Unmarshal(OpenAPI(), &doc)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// every reference has to resolve
+	refs := regexp.MustCompile(`"\$ref": "#/components/(\w+)/(\w+)"`)
+	for _, m := range refs.FindAllStringSubmatch(string(OpenAPI()), -1) {
+		if _, ok := doc.Components[m[1]][m[2]]; !ok {
+			t.Errorf("unresolved reference %s", m[0])
+		}
+	}
+
+	server, err := NewServer(Config{Listen: "localhost:0"})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// every documented operation has to be routed, the requests are
+	// cancelled up front so that streams return right away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	params := strings.NewReplacer(
+		"{list}", "blacklist", "{domain}", "example.com", "{id}", "1")
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			request := httptest.NewRequest(
+				strings.ToUpper(method), params.Replace(path), nil).WithContext(ctx)
+			recorder := httptest.NewRecorder()
+			server.Handler.ServeHTTP(recorder, request)
+			// handlers answer 404 with an empty or JSON body, the mux with
+			// text
+			notRouted := recorder.Code == http.StatusNotFound &&
+				strings.HasPrefix(recorder.Body.String(), "404 page not found")
+			if recorder.Code == http.StatusMethodNotAllowed || notRouted {
+				t.Errorf("%s %s: got %d", method, path, recorder.Code)
+			}
+		}
+	}
+}
+
+// schema is the part of a JSON schema that describes the fields of objects
+type schema struct {
+	Ref        string             `json:"$ref"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+}
+
+// checkSchema reports the properties of s that aren't the json fields of t,
+// the schemas it references are checked on their own
+func checkSchema(t *testing.T, path string, s *schema, typ reflect.Type) {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	if s.Ref != "" {
+		return
+	}
+	if s.Items != nil {
+		checkSchema(t, path+"[]", s.Items, typ)
+		return
+	}
+	if s.Properties == nil || typ.Kind() != reflect.Struct {
+		return
+	}
+
+	fields := apitest.JSONFields(typ)
+	var got, wanted []string
+	for name := range s.Properties {
+		got = append(got, name)
+	}
+	for name := range fields {
+		wanted = append(wanted, name)
+	}
+	sort.Strings(got)
+	sort.Strings(wanted)
+	if !reflect.DeepEqual(got, wanted) {
+		t.Errorf("%s: got properties %v wanted %v from %v", path, got, wanted, typ)
+		return
+	}
+	for name, property := range s.Properties {
+		checkSchema(t, path+"."+name, property, fields[name])
+	}
+}
+
+func Test_OpenAPI_Schemas(t *testing.T) {
+	var doc struct {
+		Components struct {
+			Schemas map[string]*schema `json:"schemas"`
+		} `json:"components"`
+	}
+	err := json.Unmarshal(OpenAPI(), &doc)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// the properties of every schema are the json fields of what the
+	// handlers read or write
+	types := map[string]interface{}{
+		"Error":             ErrorResponse{},
+		"FieldError":        rule.FieldError{},
+		"RuleConfig":        rule.RuleConfig{},
+		"Group":             rule.GroupConfig{},
+		"Schedule":          rule.ScheduleConfig{},
+		"Category":          rule.CategoryConfig{},
+		"Content":           rule.ContentConfig{},
+		"Term":              rule.TermConfig{},
+		"SafeSearch":        rule.SafeSearchConfig{},
+		"Header":            rule.HeaderConfig{},
+		"Redirect":          rule.RedirectConfig{},
+		"RuleUpdateRequest": RuleUpdateRequest{},
+		"RuleUpdate":        RuleUpdate{},
+		"ListEdit":          rule.ListEdit{},
+		"ListReplacement":   ListReplacement{},
+		"ListChange":        rule.ListChange{},
+		"DiffEntry":         rule.DiffEntry{},
+		"Snapshot":          rule.Snapshot{},
+		"CacheStats":        rule.CacheStats{},
+		"AccessSubmission":  AccessSubmission{},
+		"AccessDecision":    AccessDecision{},
+		"AccessRequest":     AccessRequest{},
+		"Me":                Me{},
+		"Restriction":       rule.Restriction{},
+		"Status":            Status{},
+		"Decision":          events.Decision{},
+		"Explanation":       rule.Explanation{},
+		"OverrideRequest":   OverrideRequest{},
+		"Override":          rule.Override{},
+		"Dropped":           Dropped{},
+	}
+	for name, s := range doc.Components.Schemas {
+		typ, ok := types[name]
+		if !ok {
+			t.Errorf("%s: got no type for the schema", name)
+			continue
+		}
+		checkSchema(t, name, s, reflect.TypeOf(typ))
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// overridesHandler lists the active overrides or adds one
+func overridesHandler(response http.ResponseWriter, request *http.Request) {
+	if request.Method == "GET" {
+		writeJSON(response, request, http.StatusOK,
+			rule.RuleManager.Overrides(time.Now()))
+		return
+	}
+
+	var create OverrideRequest
+	if !readJSON(response, request, &create) {
+		return
+	}
+
+	duration, err := time.ParseDuration(create.Duration)
+	if err != nil || duration <= 0 {
+		hlog.FromRequest(request).Error().
+			Str("duration", create.Duration).
+			Msg("invalid override duration")
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	o, err := rule.RuleManager.AddOverride(rule.Override{
+		Domain:  create.Domain,
+		Action:  create.Action,
+		Group:   create.Group,
+		Expires: time.Now().Add(duration),
+		By:      PrincipalFromRequest(request).Name,
+	})
+	if err != nil {
+		hlog.FromRequest(request).Error().
+			Err(err).
+			Msg("could not add override")
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	hlog.FromRequest(request).Info().
+		Uint64("id", o.ID).
+		Str("domain", o.Domain).
+		Str("action", o.Action).
+		Str("group", o.Group).
+		Time("expires", o.Expires).
+		Msg("override added")
+	writeJSON(response, request, http.StatusCreated, o)
+}
+
+// overrideHandler ends /overrides/{id} early
+func overrideHandler(response http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseUint(
+		strings.TrimPrefix(request.URL.Path, "/overrides/"), 10, 64)
+	if err != nil || !rule.RuleManager.RemoveOverride(id) {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	hlog.FromRequest(request).Info().Uint64("id", id).Msg("override removed")
+	response.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// access request states
+const (
+	statusPending  = "pending"
+	statusApproved = "approved"
+	statusDenied   = "denied"
+)
+
+const (
+	// MaxPendingRequests is how many access requests a requester may have
+	// waiting for a decision
+	MaxPendingRequests = 20
+	// maxSubmissionSize limits the body of POST /requests
+	maxSubmissionSize = 4 << 10
+)
+
+// AccessRequest is a child asking for a domain to be unblocked
+type AccessRequest struct {
+	ID        uint64     `json:"id"`
+	Domain    string     `json:"domain"`
+	Reason    string     `json:"reason,omitempty"`
+	Requester string     `json:"requester"`
+	Group     string     `json:"group,omitempty"`
+	Created   time.Time  `json:"created"`
+	Status    string     `json:"status"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	Decided   *time.Time `json:"decided,omitempty"`
+}
+
+type accessRequests struct {
+	lock     sync.Mutex
+	next     uint64
+	requests []*AccessRequest
+}
+
+// pending holds every access request since babysitter started
+var pending = &accessRequests{}
+
+// add records request, it returns nil if its requester already has
+// MaxPendingRequests pending requests
+func (ar *accessRequests) add(request *AccessRequest) *AccessRequest {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+
+	waiting := 0
+	for _, r := range ar.requests {
+		if r.Requester == request.Requester && r.Status == statusPending {
+			waiting++
+		}
+	}
+	if waiting >= MaxPendingRequests {
+		return nil
+	}
+
+	ar.next++
+	request.ID = ar.next
+	ar.requests = append(ar.requests, request)
+	result := *request
+	return &result
+}
+
+// list returns copies of the requests made by requester, or every request
+// if requester is empty
+func (ar *accessRequests) list(requester string) []AccessRequest {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+
+	result := []AccessRequest{}
+	for _, r := range ar.requests {
+		if requester == "" || r.Requester == requester {
+			result = append(result, *r)
+		}
+	}
+	return result
+}
+
+// decide moves a pending request to status, it returns nil if there is no
+// pending request with id
+func (ar *accessRequests) decide(id uint64, status, by string) *AccessRequest {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+
+	for _, r := range ar.requests {
+		if r.ID == id && r.Status == statusPending {
+			now := time.Now()
+			r.Status = status
+			r.DecidedBy = by
+			r.Decided = &now
+			result := *r
+			return &result
+		}
+	}
+	return nil
+}
+
+// reopen puts a request that was decided back to pending, for when the
+// decision couldn't be applied
+func (ar *accessRequests) reopen(id uint64) {
+	ar.lock.Lock()
+	defer ar.lock.Unlock()
+
+	for _, r := range ar.requests {
+		if r.ID == id {
+			r.Status = statusPending
+			r.DecidedBy = ""
+			r.Decided = nil
+			return
+		}
+	}
+}
+
+func requestsHandler(response http.ResponseWriter, request *http.Request) {
+	p := PrincipalFromRequest(request)
+
+	switch request.Method {
+	case "GET":
+		// children only get to see what they asked for
+		requester := ""
+		if p.Role == RoleChild {
+			requester = p.Name
+		}
+		writeJSON(response, request, http.StatusOK, pending.list(requester))
+	case "POST":
+		request.Body = http.MaxBytesReader(
+			response, request.Body, maxSubmissionSize)
+		var submission AccessSubmission
+		if !readJSON(response, request, &submission) {
+			return
+		}
+		domain, err := rule.NormalizeDomain(submission.Domain)
+		if err != nil {
+			hlog.FromRequest(request).Error().
+				Str("domain", submission.Domain).
+				Msg("invalid domain in access request")
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ar := pending.add(&AccessRequest{
+			Domain:    domain,
+			Reason:    submission.Reason,
+			Requester: p.Name,
+			Group:     p.Group,
+			Created:   time.Now(),
+			Status:    statusPending,
+		})
+		if ar == nil {
+			hlog.FromRequest(request).Error().
+				Str("requester", p.Name).
+				Int("max_pending", MaxPendingRequests).
+				Msg("too many pending access requests")
+			response.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		hlog.FromRequest(request).Info().
+			Uint64("id", ar.ID).
+			Str("domain", ar.Domain).
+			Msg("access requested")
+		writeJSON(response, request, http.StatusCreated, ar)
+	}
+}
+
+// decideRequestHandler approves or denies /requests/{id}. Approving allows
+// the domain for the group of the requester only, a requester who isn't in
+// a group gets the domain whitelisted since the rules don't know them apart
+// from everyone else.
+func decideRequestHandler(response http.ResponseWriter, request *http.Request) {
+	id, err := strconv.ParseUint(
+		strings.TrimPrefix(request.URL.Path, "/requests/"), 10, 64)
+	if err != nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var decision AccessDecision
+	if !readJSON(response, request, &decision) {
+		return
+	}
+	if decision.Status != statusApproved && decision.Status != statusDenied {
+		hlog.FromRequest(request).Error().
+			Str("status", decision.Status).
+			Msg("invalid access request decision")
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	p := PrincipalFromRequest(request)
+	ar := pending.decide(id, decision.Status, p.Name)
+	if ar == nil {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if ar.Status == statusApproved {
+		err = approve(ar, changeOf(request))
+		if err != nil {
+			// deciding it marked the request so that nobody else
+			// decides it meanwhile, it can be approved again
+			pending.reopen(ar.ID)
+			hlog.FromRequest(request).Error().
+				Err(err).
+				Str("domain", ar.Domain).
+				Msg("could not allow approved domain")
+			response.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	hlog.FromRequest(request).Info().
+		Uint64("id", ar.ID).
+		Str("domain", ar.Domain).
+		Str("status", ar.Status).
+		Msg("access request decided")
+	writeJSON(response, request, http.StatusOK, ar)
+}
+
+// approve allows the domain of ar for the group of its requester, or for
+// everyone if they're not in a group
+func approve(ar *AccessRequest, change rule.Change) error {
+	if ar.Group != "" {
+		return rule.RuleManager.Approve(ar.Group, ar.Domain, change)
+	}
+	_, err := rule.RuleManager.EditList("whitelist", rule.ListEdit{
+		Add: []string{ar.Domain},
+	}, rule.AnyVersion, change)
+	return err
+}
+
+// meHandler tells the caller who they are and, for members of a group, which
+// scheduled restrictions apply to them
+func meHandler(response http.ResponseWriter, request *http.Request) {
+	p := PrincipalFromRequest(request)
+	me := Me{Principal: p, Restrictions: []rule.Restriction{}}
+	if p.Group != "" {
+		me.Restrictions = rule.RuleManager.Restrictions(p.Group, time.Now())
+	}
+	writeJSON(response, request, http.StatusOK, me)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+func Test_Requests(t *testing.T) {
+	saved := pending
+	pending = &accessRequests{}
+	defer func() { pending = saved }()
+
+	err := rule.RuleManager.Update(&rule.RuleConfig{
+		DomainBlacklistConfig: &rule.DomainBlacklistConfig{
+			Blacklist: []string{"games.com"},
+		},
+		Groups: []*rule.GroupConfig{
+			{Name: "kids", Clients: []string{"10.0.0.2"}},
+			{Name: "teens", Clients: []string{"10.0.0.3"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	server, err := NewServer(Config{
+		Listen: "localhost:0",
+		Auth: AuthConfig{
+			Tokens: []TokenConfig{
+				{Name: "parent", Hash: HashToken("admin"), Role: "admin"},
+				{Name: "kid", Hash: HashToken("child"), Role: "child", Group: "kids"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	send := func(token, method, path, body string) int {
+		request := httptest.NewRequest(
+			method, "http://localhost"+path, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := send("child", "POST", "/requests", `{"domain":"games.com"}`); code != http.StatusCreated {
+		t.Fatalf("got %d wanted %d", code, http.StatusCreated)
+	}
+	if code := send("admin", "POST", "/requests/1", `{"status":"approved"}`); code != http.StatusOK {
+		t.Fatalf("got %d wanted %d", code, http.StatusOK)
+	}
+
+	// the domain is approved for the requester's group only
+	kid := httptest.NewRequest("GET", "http://games.com", nil)
+	kid.RemoteAddr = "10.0.0.2:1234"
+	teen := httptest.NewRequest("GET", "http://games.com", nil)
+	teen.RemoteAddr = "10.0.0.3:1234"
+	if !rule.RuleManager.Allow(kid) {
+		t.Fatalf("got deny wanted allow for the requester's group")
+	}
+	if rule.RuleManager.Allow(teen) {
+		t.Fatalf("got allow wanted deny for another group")
+	}
+
+	// a request that can't be approved stays pending
+	if code := send("child", "POST", "/requests", `{"domain":"other.com"}`); code != http.StatusCreated {
+		t.Fatalf("got %d wanted %d", code, http.StatusCreated)
+	}
+	// the category of approved domains was shared with another group
+	rules, _ := rule.RuleManager.Rules()
+	categories := rules.Categories
+	shared := *categories[0]
+	shared.Groups = []string{"kids", "teens"}
+	changed := *rules
+	changed.Categories = []*rule.CategoryConfig{&shared}
+	if err := rule.RuleManager.Update(&changed); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if code := send("admin", "POST", "/requests/2", `{"status":"approved"}`); code != http.StatusInternalServerError {
+		t.Fatalf("got %d wanted %d", code, http.StatusInternalServerError)
+	}
+	changed.Categories = categories
+	if err := rule.RuleManager.Update(&changed); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if code := send("admin", "POST", "/requests/2", `{"status":"approved"}`); code != http.StatusOK {
+		t.Fatalf("got %d wanted %d once the category is back", code, http.StatusOK)
+	}
+
+	big := fmt.Sprintf(`{"domain":"example.com","reason":%q}`,
+		strings.Repeat("please ", maxSubmissionSize))
+	if code := send("child", "POST", "/requests", big); code != http.StatusBadRequest {
+		t.Fatalf("got %d wanted %d for a large body", code, http.StatusBadRequest)
+	}
+
+	for i := 0; i < MaxPendingRequests; i++ {
+		body := fmt.Sprintf(`{"domain":"site%d.com"}`, i)
+		if code := send("child", "POST", "/requests", body); code != http.StatusCreated {
+			t.Fatalf("got %d wanted %d for request %d", code, http.StatusCreated, i)
+		}
+	}
+	if code := send("child", "POST", "/requests", `{"domain":"more.com"}`); code != http.StatusTooManyRequests {
+		t.Fatalf("got %d wanted %d", code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Role decides what a credential may do with the API
+type Role string
+
+const (
+	// RoleAdmin may do anything, including changing the rules
+	RoleAdmin Role = "admin"
+	// RoleViewer may read the rules, access requests and reports
+	RoleViewer Role = "viewer"
+	// RoleChild may only submit access requests and see their own status
+	RoleChild Role = "child"
+)
+
+// ParseRole converts the name of a role, an empty name is an admin so that
+// credentials configured before roles existed keep working
+func ParseRole(name string) (Role, error) {
+	switch Role(name) {
+	case "", RoleAdmin:
+		return RoleAdmin, nil
+	case RoleViewer, RoleChild:
+		return Role(name), nil
+	}
+	return "", fmt.Errorf("invalid role %q, expected admin, viewer or child", name)
+}
+
+var (
+	admins  = []Role{RoleAdmin}
+	viewers = []Role{RoleAdmin, RoleViewer}
+	anyone  = []Role{RoleAdmin, RoleViewer, RoleChild}
+)
+
+// access maps an HTTP method to the roles allowed to use it, methods that
+// aren't listed are refused
+type access map[string][]Role
+
+// handler refuses requests whose principal's role isn't allowed to use the
+// request's method, it must come after the authentication handler
+func (a access) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		roles, ok := a[request.Method]
+		if !ok {
+			response.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		p := PrincipalFromRequest(request)
+		if p == nil {
+			refuse(response, request, http.StatusForbidden, "no principal")
+			return
+		}
+		for _, r := range roles {
+			if p.Role == r {
+				next.ServeHTTP(response, request)
+				return
+			}
+		}
+
+		refuse(response, request, http.StatusForbidden,
+			fmt.Sprintf("role %s may not %s", p.Role, request.Method))
+	})
+}
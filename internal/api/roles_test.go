@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_Roles(t *testing.T) {
+	server, err := NewServer(Config{
+		Listen: "localhost:0",
+		Auth: AuthConfig{
+			Tokens: []TokenConfig{
+				{Name: "parent", Hash: HashToken("admin"), Role: "admin"},
+				{Name: "grandma", Hash: HashToken("viewer"), Role: "viewer"},
+				{Name: "kid", Hash: HashToken("child"), Role: "child"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	tc := []struct {
+		token  string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"viewer", "GET", "/rules", "", http.StatusOK},
+		{"viewer", "POST", "/rules", `{"rules":{}}`, http.StatusForbidden},
+		{"viewer", "POST", "/cache/flush", "", http.StatusForbidden},
+		{"viewer", "GET", "/requests", "", http.StatusOK},
+		{"viewer", "POST", "/requests", `{"domain":"example.com"}`, http.StatusForbidden},
+		{"child", "GET", "/rules", "", http.StatusForbidden},
+		{"child", "GET", "/metrics", "", http.StatusForbidden},
+		{"child", "POST", "/requests", `{"domain":"example.com"}`, http.StatusCreated},
+		{"child", "POST", "/requests/{request}", `{"status":"approved"}`, http.StatusForbidden},
+		{"child", "GET", "/me", "", http.StatusOK},
+		{"child", "GET", "/log", "", http.StatusForbidden},
+		{"child", "POST", "/overrides", `{"domain":"example.com","action":"allow","duration":"1h"}`, http.StatusForbidden},
+		{"viewer", "GET", "/status", "", http.StatusOK},
+		{"viewer", "GET", "/explain?url=example.com", "", http.StatusOK},
+		{"viewer", "DELETE", "/overrides/{override}", "", http.StatusForbidden},
+		{"admin", "POST", "/overrides", `{"domain":"example.com","action":"allow","duration":"1h"}`, http.StatusCreated},
+		{"admin", "DELETE", "/overrides/{override}", "", http.StatusNoContent},
+		{"admin", "POST", "/cache/flush", "", http.StatusOK},
+		{"admin", "DELETE", "/cache/flush", "", http.StatusMethodNotAllowed},
+		{"admin", "POST", "/requests/{request}", `{"status":"denied"}`, http.StatusOK},
+		{"admin", "POST", "/requests/{request}", `{"status":"denied"}`, http.StatusNotFound},
+	}
+
+	// requests and overrides are numbered across tests, {request} and
+	// {override} are the ones the test created last
+	created := map[string]string{}
+	for _, test := range tc {
+		path := strings.NewReplacer(
+			"{request}", created["/requests"],
+			"{override}", created["/overrides"],
+		).Replace(test.path)
+		request := httptest.NewRequest(
+			test.method,
+			"http://localhost"+path,
+			strings.NewReader(test.body))
+		request.Header.Set("Authorization", "Bearer "+test.token)
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Fatalf("%s %s %s got %d wanted %d",
+				test.token, test.method, test.path,
+				recorder.Code, test.status)
+		}
+		if recorder.Code == http.StatusCreated {
+			var result struct {
+				ID uint64 `json:"id"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+				t.Fatalf("got %v wanted nil", err)
+			}
+			created[test.path] = strconv.FormatUint(result.ID, 10)
+		}
+	}
+}
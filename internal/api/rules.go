@@ -0,0 +1,377 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// etag is the entity tag of a version of the rules
+func etag(version uint64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatch returns the version named by the If-Match header of request, or
+// rule.AnyVersion if there is none or it is "*". Only a single strong tag
+// is accepted, since there is only ever one current version.
+func ifMatch(request *http.Request) (uint64, error) {
+	value := strings.TrimSpace(request.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return rule.AnyVersion, nil
+	}
+
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return 0, fmt.Errorf("If-Match must be a single strong entity tag")
+	}
+	version, err := strconv.ParseUint(value[1:len(value)-1], 10, 64)
+	if err != nil || version == rule.AnyVersion {
+		// a tag we never handed out can't match
+		return 0, rule.ErrVersionMismatch
+	}
+	return version, nil
+}
+
+// changeOf describes the change request makes to the rules, sit identifies
+// itself through the User-Agent
+func changeOf(request *http.Request) rule.Change {
+	source := rule.SourceAPI
+	if strings.HasPrefix(request.UserAgent(), "sit/") {
+		source = rule.SourceSit
+	}
+	return rule.Change{
+		Author: PrincipalFromRequest(request).Name,
+		Source: source,
+	}
+}
+
+// ruleError writes the response for an error changing the rules
+func ruleError(response http.ResponseWriter, request *http.Request, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, rule.ErrVersionMismatch):
+		status = http.StatusPreconditionFailed
+		_, version := rule.RuleManager.Rules()
+		response.Header().Set("ETag", etag(version))
+	case errors.Is(err, rule.ErrUnknownList),
+		errors.Is(err, rule.ErrUnknownVersion):
+		status = http.StatusNotFound
+	}
+
+	body := &ErrorResponse{Error: err.Error()}
+	var ve *rule.ValidationError
+	if errors.As(err, &ve) {
+		body.Errors = ve.Errors
+	}
+
+	hlog.FromRequest(request).Error().
+		Err(err).
+		Int("status", status).
+		Msg("could not change rules")
+	writeJSON(response, request, status, body)
+}
+
+// RuleUpdate is the result of changing the rules with POST /rules
+type RuleUpdate struct {
+	// Applied is false if the change was only validated
+	Applied bool             `json:"applied"`
+	Version uint64           `json:"version"`
+	Diff    []rule.DiffEntry `json:"diff"`
+	// Rules is the effective configuration after the change
+	Rules *rule.RuleConfig `json:"rules"`
+}
+
+func ruleHandler(response http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+		getRulesHandler(response, request)
+	case "POST":
+		updateRulesHandler(response, request)
+	default:
+		response.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func getRulesHandler(response http.ResponseWriter, request *http.Request) {
+	rules, version := rule.RuleManager.Rules()
+	response.Header().Set("ETag", etag(version))
+	writeJSON(response, request, http.StatusOK, rules)
+}
+
+// updateRulesHandler replaces the lists and sections in the request, with
+// ?validate=only the change is only validated and previewed
+func updateRulesHandler(response http.ResponseWriter, request *http.Request) {
+	validateOnly := false
+	switch request.URL.Query().Get("validate") {
+	case "":
+	case "only":
+		validateOnly = true
+	default:
+		ruleError(response, request,
+			fmt.Errorf("validate must be \"only\" if it is set"))
+		return
+	}
+
+	version, err := ifMatch(request)
+	if err != nil {
+		ruleError(response, request, err)
+		return
+	}
+
+	var update RuleUpdateRequest
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&update)
+	if err != nil {
+		ruleError(response, request,
+			fmt.Errorf("could not read update: %v", err))
+		return
+	}
+
+	rc, err := rule.NewRuleConfigFromMap(update.Rules)
+	if err != nil {
+		ruleError(response, request, err)
+		return
+	}
+	rc.Groups = update.Groups
+	rc.Schedules = update.Schedules
+	rc.Categories = update.Categories
+	rc.Content = update.Content
+	rc.SafeSearch = update.SafeSearch
+	rc.Headers = update.Headers
+	rc.Redirects = update.Redirects
+
+	if validateOnly {
+		preview, err := rule.RuleManager.Preview(rc, version)
+		if err != nil {
+			ruleError(response, request, err)
+			return
+		}
+		response.Header().Set("ETag", etag(preview.Version))
+		writeJSON(response, request, http.StatusOK, &RuleUpdate{
+			Version: preview.Version,
+			Diff:    preview.Diff,
+			Rules:   preview.Rules,
+		})
+		return
+	}
+
+	version, err = rule.RuleManager.UpdateIf(rc, version, changeOf(request))
+	if err != nil {
+		ruleError(response, request, err)
+		return
+	}
+	snapshot, err := rule.RuleManager.Version(version)
+	if err != nil {
+		ruleError(response, request, err)
+		return
+	}
+
+	hlog.FromRequest(request).Info().
+		Uint64("version", version).
+		Int("changes", len(snapshot.Diff)).
+		Msg("rules updated")
+	response.Header().Set("ETag", etag(version))
+	writeJSON(response, request, http.StatusOK, &RuleUpdate{
+		Applied: true,
+		Version: version,
+		Diff:    snapshot.Diff,
+		Rules:   snapshot.Rules,
+	})
+}
+
+// listHandler serves a single list:
+//
+//	GET    /rules/{list}           the domains in the list
+//	PUT    /rules/{list}           replace every domain in the list
+//	PATCH  /rules/{list}           add and remove sets of domains
+//	POST   /rules/{list}/{domain}  add a domain
+//	DELETE /rules/{list}/{domain}  remove a domain
+//
+// Changes honour If-Match and every response carries the ETag of the
+// resulting version.
+func listHandler(response http.ResponseWriter, request *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(request.URL.Path, "/rules/"), "/")
+	parts := strings.SplitN(path, "/", 2)
+	list := parts[0]
+	domain := ""
+	if len(parts) == 2 {
+		domain = parts[1]
+	}
+
+	switch {
+	case domain == "" && request.Method == "GET":
+		rc, version := rule.RuleManager.Rules()
+		domains, err := rc.List(list)
+		if err != nil {
+			ruleError(response, request, err)
+			return
+		}
+		if domains == nil {
+			domains = []string{}
+		}
+		response.Header().Set("ETag", etag(version))
+		writeJSON(response, request, http.StatusOK, &rule.ListChange{
+			List:    list,
+			Added:   []string{},
+			Removed: []string{},
+			Domains: domains,
+			Version: version,
+		})
+		return
+	case domain == "" && request.Method == "PUT":
+		var replacement ListReplacement
+		if !readJSON(response, request, &replacement) {
+			return
+		}
+		changeList(response, request, false,
+			func(version uint64) (*rule.ListChange, error) {
+				return rule.RuleManager.ReplaceList(
+					list, replacement.Domains, version, changeOf(request))
+			})
+		return
+	case domain == "" && request.Method == "PATCH":
+		var edit rule.ListEdit
+		if !readJSON(response, request, &edit) {
+			return
+		}
+		editList(response, request, list, edit, false)
+		return
+	case domain != "" && request.Method == "POST":
+		editList(response, request, list, rule.ListEdit{
+			Add: []string{domain},
+		}, false)
+		return
+	case domain != "" && request.Method == "DELETE":
+		editList(response, request, list, rule.ListEdit{
+			Remove: []string{domain},
+		}, true)
+		return
+	}
+
+	response.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// editList applies edit, if mustExist is set removing a domain that isn't
+// in the list is reported as not found
+func editList(
+	response http.ResponseWriter,
+	request *http.Request,
+	list string,
+	edit rule.ListEdit,
+	mustExist bool,
+) {
+	changeList(response, request, mustExist,
+		func(version uint64) (*rule.ListChange, error) {
+			return rule.RuleManager.EditList(
+				list, edit, version, changeOf(request))
+		})
+}
+
+// changeList calls apply with the version from If-Match and writes the
+// resulting change
+func changeList(
+	response http.ResponseWriter,
+	request *http.Request,
+	mustExist bool,
+	apply func(version uint64) (*rule.ListChange, error),
+) {
+	version, err := ifMatch(request)
+	if err != nil {
+		ruleError(response, request, err)
+		return
+	}
+
+	change, err := apply(version)
+	if err != nil {
+		ruleError(response, request, err)
+		return
+	}
+
+	response.Header().Set("ETag", etag(change.Version))
+	if mustExist && len(change.Removed) == 0 {
+		response.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	hlog.FromRequest(request).Info().
+		Str("list", change.List).
+		Strs("added", change.Added).
+		Strs("removed", change.Removed).
+		Str("by", PrincipalFromRequest(request).Name).
+		Msg("list edited")
+	writeJSON(response, request, http.StatusOK, change)
+}
+
+// historyHandler lists the versions of the rules, newest first and capped
+// by the limit query parameter, or returns a single version including its
+// rules if the version query parameter is set
+func historyHandler(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	if value := query.Get("version"); value != "" {
+		version, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		snapshot, err := rule.RuleManager.Version(version)
+		if err != nil {
+			ruleError(response, request, err)
+			return
+		}
+		writeJSON(response, request, http.StatusOK, snapshot)
+		return
+	}
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(response, request, http.StatusOK, rule.RuleManager.History(limit))
+}
+
+// rollbackHandler restores the rules of the version query parameter as a
+// new version, it honours If-Match
+func rollbackHandler(response http.ResponseWriter, request *http.Request) {
+	version, err := strconv.ParseUint(request.URL.Query().Get("version"), 10, 64)
+	if err != nil {
+		hlog.FromRequest(request).Error().
+			Str("version", request.URL.Query().Get("version")).
+			Msg("invalid version to roll back to")
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ifVersion, err := ifMatch(request)
+	if err != nil {
+		ruleError(response, request, err)
+		return
+	}
+
+	change := changeOf(request)
+	change.Source = rule.SourceRollback
+	snapshot, err := rule.RuleManager.Rollback(version, ifVersion, change)
+	if err != nil {
+		ruleError(response, request, err)
+		return
+	}
+
+	hlog.FromRequest(request).Info().
+		Uint64("to", version).
+		Uint64("version", snapshot.Version).
+		Msg("rules rolled back")
+	response.Header().Set("ETag", etag(snapshot.Version))
+	writeJSON(response, request, http.StatusOK, snapshot)
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+func Test_ListHandler(t *testing.T) {
+	server, err := NewServer(Config{Listen: "localhost:0"})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	err = rule.RuleManager.Update(&rule.RuleConfig{
+		DomainBlacklistConfig: &rule.DomainBlacklistConfig{},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	do := func(method, path, body, match string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if match != "" {
+			request.Header.Set("If-Match", match)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	result := do("GET", "/rules/blacklist", "", "")
+	if result.Code != http.StatusOK {
+		t.Fatalf("got %d wanted %d", result.Code, http.StatusOK)
+	}
+	first := result.Header().Get("ETag")
+
+	result = do("POST", "/rules/blacklist/example.com", "", first)
+	if result.Code != http.StatusOK {
+		t.Fatalf("got %d wanted %d", result.Code, http.StatusOK)
+	}
+	second := result.Header().Get("ETag")
+	if second == first {
+		t.Fatalf("got ETag %s wanted a new one", second)
+	}
+
+	// a second parent editing from the first version loses
+	result = do("PATCH", "/rules/blacklist", `{"add":["twitter.com"]}`, first)
+	if result.Code != http.StatusPreconditionFailed {
+		t.Fatalf("got %d wanted %d", result.Code, http.StatusPreconditionFailed)
+	}
+	if result.Header().Get("ETag") != second {
+		t.Fatalf("got ETag %s wanted %s",
+			result.Header().Get("ETag"), second)
+	}
+
+	result = do("PATCH", "/rules/blacklist",
+		`{"add":["twitter.com"],"remove":["example.com"]}`, second)
+	if result.Code != http.StatusOK {
+		t.Fatalf("got %d wanted %d", result.Code, http.StatusOK)
+	}
+	if !strings.Contains(result.Body.String(), `"domains":["twitter.com"]`) {
+		t.Fatalf("got %s wanted only twitter.com", result.Body.String())
+	}
+
+	result = do("PUT", "/rules/blacklist",
+		`{"domains":["example.com","twitter.com"]}`, "")
+	if result.Code != http.StatusOK {
+		t.Fatalf("got %d wanted %d", result.Code, http.StatusOK)
+	}
+	if !strings.Contains(result.Body.String(),
+		`"added":["example.com"],"removed":[]`) {
+		t.Fatalf("got %s wanted example.com added", result.Body.String())
+	}
+
+	tc := []struct {
+		method string
+		path   string
+		match  string
+		status int
+	}{
+		{"DELETE", "/rules/blacklist/example.com", "", http.StatusOK},
+		{"DELETE", "/rules/blacklist/example.com", "", http.StatusNotFound},
+		{"POST", "/rules/greylist/example.com", "", http.StatusNotFound},
+		{"POST", "/rules/blacklist/not_a_domain!", "", http.StatusBadRequest},
+		{"POST", "/rules/blacklist/example.com", "W/\"1\"", http.StatusBadRequest},
+		{"PUT", "/rules/blacklist/example.com", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tc {
+		result := do(test.method, test.path, "", test.match)
+		if result.Code != test.status {
+			t.Errorf("%s %s: got %d wanted %d",
+				test.method, test.path, result.Code, test.status)
+		}
+	}
+}
+
+func Test_UpdateRules(t *testing.T) {
+	server, err := NewServer(Config{Listen: "localhost:0"})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	err = rule.RuleManager.Update(&rule.RuleConfig{
+		DomainBlacklistConfig: &rule.DomainBlacklistConfig{
+			Blacklist: []string{"example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	_, version := rule.RuleManager.Rules()
+
+	post := func(query, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(
+			"POST", "/rules"+query, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	tc := []struct {
+		name   string
+		query  string
+		body   string
+		status int
+		// contains is expected in the response body
+		contains string
+	}{
+		{
+			name:     "invalid domains",
+			body:     `{"rules":{"blacklist":["ok.com","not a domain","-x-"]}}`,
+			status:   http.StatusBadRequest,
+			contains: `"field":"blacklist[1]"`,
+		},
+		{
+			name:     "unknown list",
+			body:     `{"rules":{"greylist":["ok.com"]}}`,
+			status:   http.StatusBadRequest,
+			contains: `"field":"greylist"`,
+		},
+		{
+			name:     "invalid group",
+			body:     `{"groups":[{"name":"kids","clients":["300.1.1.1"]}]}`,
+			status:   http.StatusBadRequest,
+			contains: `"field":"groups[0]","value":"kids"`,
+		},
+		{
+			name: "invalid category",
+			body: `{"categories":[{"name":"ok","action":"deny","domains":["a.com"]},` +
+				`{"name":"games","action":"deny","domains":["b.com"],"groups":["nobody"]}]}`,
+			status:   http.StatusBadRequest,
+			contains: `"field":"categories[1]","value":"games"`,
+		},
+		{
+			name:     "invalid content",
+			body:     `{"content":{"types":["video"]}}`,
+			status:   http.StatusBadRequest,
+			contains: `"field":"content"`,
+		},
+		{
+			name:     "unknown field",
+			body:     `{"rulez":{}}`,
+			status:   http.StatusBadRequest,
+			contains: `"error"`,
+		},
+		{
+			name:     "bad validate",
+			query:    "?validate=maybe",
+			body:     `{"rules":{}}`,
+			status:   http.StatusBadRequest,
+			contains: `"error"`,
+		},
+		{
+			name:     "validate only",
+			query:    "?validate=only",
+			body:     `{"rules":{"blacklist":["twitter.com"]}}`,
+			status:   http.StatusOK,
+			contains: `"applied":false`,
+		},
+	}
+	for _, test := range tc {
+		result := post(test.query, test.body)
+		if result.Code != test.status {
+			t.Errorf("%s: got %d wanted %d", test.name, result.Code, test.status)
+		}
+		if !strings.Contains(result.Body.String(), test.contains) {
+			t.Errorf("%s: got %s wanted it to contain %s",
+				test.name, result.Body.String(), test.contains)
+		}
+	}
+
+	if _, current := rule.RuleManager.Rules(); current != version {
+		t.Fatalf("got version %d wanted %d, nothing should be applied",
+			current, version)
+	}
+
+	result := post("", `{"rules":{"blacklist":["Twitter.com"]}}`)
+	if result.Code != http.StatusOK {
+		t.Fatalf("got %d wanted %d", result.Code, http.StatusOK)
+	}
+	for _, wanted := range []string{
+		`"applied":true`,
+		`{"op":"add","section":"blacklist","item":"twitter.com"}`,
+		`{"op":"remove","section":"blacklist","item":"example.com"}`,
+	} {
+		if !strings.Contains(result.Body.String(), wanted) {
+			t.Errorf("got %s wanted it to contain %s",
+				result.Body.String(), wanted)
+		}
+	}
+}
@@ -1,19 +1,53 @@
 package api
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"crypto/tls"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/justinas/alice"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/hlog"
 	"github.com/rs/zerolog/log"
 
-	"github.com/jcline/babysitter/internal/rule"
+	"github.com/jcline/babysitter/internal/metrics"
 )
 
-func Start(address string) error {
+// Config is the configuration of the management API
+type Config struct {
+	Listen string     `yaml:"listen"`
+	Auth   AuthConfig `yaml:"auth"`
+	TLS    TLSConfig  `yaml:"tls"`
+}
+
+// Server is the management API, served over TLS if it has a certificate
+type Server struct {
+	*http.Server
+}
+
+// ListenAndServe serves the API until Shutdown is called
+func (s *Server) ListenAndServe() error {
+	if s.TLSConfig != nil {
+		return s.Server.ListenAndServeTLS("", "")
+	}
+	return s.Server.ListenAndServe()
+}
+
+// NewServer builds the management API server, the caller is responsible for
+// calling ListenAndServe and Shutdown
+func NewServer(conf Config) (*Server, error) {
+	auth, err := NewAuthenticator(conf.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if !auth.Enabled() {
+		log.Warn().Str("address", conf.Listen).
+			Msg("api authentication is disabled, anyone who can reach " +
+				"the api can change the rules")
+	}
 
 	chain := alice.New().
 		Append(hlog.NewHandler(log.Logger)).
@@ -35,97 +69,113 @@ func Start(address string) error {
 		Append(hlog.RemoteAddrHandler("ip")).
 		Append(hlog.UserAgentHandler("user_agent")).
 		Append(hlog.RefererHandler("referer")).
-		Append(hlog.RequestIDHandler("req_id", "Request-Id")).
-		Then(http.HandlerFunc(ruleHandler))
-
-	mux := http.NewServeMux()
-	mux.Handle("/rules", chain)
-	return http.ListenAndServe(address, mux)
-}
+		Append(hlog.RequestIDHandler("req_id", "Request-Id"))
 
-func getRulesHandler(response http.ResponseWriter, request *http.Request) {
-	type RuleResponse struct {
-		rules map[string][]string
-	}
-	rules := rule.RuleManager.GetRules()
+	// closed when the server shuts down, to end the event streams
+	shutdown := make(chan struct{})
+	var once sync.Once
 
-	body, err := json.Marshal(rules)
-	if err != nil {
-		response.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	response.WriteHeader(http.StatusOK)
-	b, err := response.Write(body)
-	if b != len(body) || err != nil {
-		hlog.FromRequest(request).Error().
-			Int("written", b).
-			Int("expected", len(body)).
-			Err(err).
-			Msg("writing failed")
-		return
-	}
-}
-
-func updateRulesHandler(response http.ResponseWriter, request *http.Request) {
-	type RuleUpdate struct {
-		Rules map[string][]string
-	}
-
-	body, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		hlog.FromRequest(request).Error().
-			Err(err).
-			Msg("could not read update request body")
-		response.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	var update RuleUpdate
-	err = json.Unmarshal(body, &update)
-	if err != nil {
-		hlog.FromRequest(request).Error().
-			Err(err).
-			Msg("could not deserialize update request body")
-		response.WriteHeader(http.StatusBadRequest)
-		return
+	mux := http.NewServeMux()
+	route := func(path string, a access, handler http.Handler) {
+		mux.Handle(path, chain.
+			Append(instrument(path), auth.handler, a.handler).
+			Then(handler))
 	}
 
-	rc, err := rule.NewRuleConfigFromMap(update.Rules)
-	if err != nil {
-		hlog.FromRequest(request).Error().
-			Err(err).
-			Msg("could not create rule config")
-		response.WriteHeader(http.StatusBadRequest)
+	route("/rules",
+		access{"GET": viewers, "POST": admins},
+		http.HandlerFunc(ruleHandler))
+	route("/rules/",
+		access{
+			"GET":    viewers,
+			"PUT":    admins,
+			"PATCH":  admins,
+			"POST":   admins,
+			"DELETE": admins,
+		},
+		http.HandlerFunc(listHandler))
+	route("/rules/history",
+		access{"GET": viewers},
+		http.HandlerFunc(historyHandler))
+	route("/rules/rollback",
+		access{"POST": admins},
+		http.HandlerFunc(rollbackHandler))
+	route("/cache",
+		access{"GET": viewers},
+		http.HandlerFunc(cacheHandler))
+	route("/cache/flush",
+		access{"POST": admins},
+		http.HandlerFunc(cacheFlushHandler))
+	route("/requests",
+		access{"GET": anyone, "POST": {RoleAdmin, RoleChild}},
+		http.HandlerFunc(requestsHandler))
+	route("/requests/",
+		access{"POST": admins},
+		http.HandlerFunc(decideRequestHandler))
+	route("/me",
+		access{"GET": anyone},
+		http.HandlerFunc(meHandler))
+	route("/status",
+		access{"GET": viewers},
+		http.HandlerFunc(statusHandler))
+	route("/log",
+		access{"GET": viewers},
+		http.HandlerFunc(logHandler))
+	route("/events",
+		access{"GET": viewers},
+		eventsHandler(shutdown))
+	route("/explain",
+		access{"GET": viewers},
+		http.HandlerFunc(explainHandler))
+	route("/overrides",
+		access{"GET": viewers, "POST": admins},
+		http.HandlerFunc(overridesHandler))
+	route("/overrides/",
+		access{"DELETE": admins},
+		http.HandlerFunc(overrideHandler))
+	route("/metrics",
+		access{"GET": viewers},
+		promhttp.Handler())
+	mux.Handle("/openapi.json", chain.
+		Append(instrument("/openapi.json")).
+		ThenFunc(openAPIHandler))
+	mux.Handle("/ui/", chain.
+		Append(instrument("/ui/")).
+		Then(uiHandler()))
+	mux.Handle("/", chain.
+		Append(instrument("/")).
+		ThenFunc(rootHandler))
+
+	server := &http.Server{
+		Addr:    conf.Listen,
+		Handler: mux,
 	}
-
-	err = rule.RuleManager.Update(rc)
-	if err != nil {
-		hlog.FromRequest(request).Error().
-			Err(err).
-			Msg("could not create rule config")
-		response.WriteHeader(http.StatusBadRequest)
+	server.RegisterOnShutdown(func() {
+		once.Do(func() { close(shutdown) })
+	})
+
+	if conf.TLS.Enabled() {
+		cert, err := loadCertificate(conf.TLS, conf.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("could not load api certificate: %v", err)
+		}
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	} else if auth.Enabled() {
+		log.Warn().Str("address", conf.Listen).
+			Msg("api credentials are sent in cleartext, configure api.tls")
 	}
 
-	response.WriteHeader(http.StatusOK)
-	b, err := response.Write(body)
-	if b != len(body) || err != nil {
-		hlog.FromRequest(request).Error().
-			Int("written", b).
-			Int("expected", len(body)).
-			Err(err).
-			Msg("writing failed")
-		return
-	}
+	return &Server{Server: server}, nil
 }
 
-func ruleHandler(response http.ResponseWriter, request *http.Request) {
-	switch request.Method {
-	case "GET":
-		getRulesHandler(response, request)
-	case "POST":
-		updateRulesHandler(response, request)
-	default:
-		response.WriteHeader(http.StatusBadRequest)
+// instrument counts requests to handler by method and status code
+func instrument(handler string) alice.Constructor {
+	counter := metrics.APIRequests.MustCurryWith(
+		prometheus.Labels{"handler": handler})
+	return func(next http.Handler) http.Handler {
+		return promhttp.InstrumentHandlerCounter(counter, next)
 	}
 }
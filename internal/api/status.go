@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+
+	"github.com/jcline/babysitter/internal/events"
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// started is roughly when babysitter started
+var started = time.Now()
+
+// Status summarizes the state of babysitter
+type Status struct {
+	Started      time.Time       `json:"started"`
+	Uptime       string          `json:"uptime"`
+	RulesVersion uint64          `json:"rules_version"`
+	Lists        map[string]int  `json:"lists"`
+	Groups       int             `json:"groups"`
+	Schedules    int             `json:"schedules"`
+	Categories   int             `json:"categories"`
+	Overrides    int             `json:"overrides"`
+	Cache        rule.CacheStats `json:"cache"`
+}
+
+func statusHandler(response http.ResponseWriter, request *http.Request) {
+	now := time.Now()
+	rc, version := rule.RuleManager.Rules()
+
+	status := &Status{
+		Started:      started,
+		Uptime:       now.Sub(started).Round(time.Second).String(),
+		RulesVersion: version,
+		Lists:        make(map[string]int),
+		Groups:       len(rc.Groups),
+		Schedules:    len(rc.Schedules),
+		Categories:   len(rc.Categories),
+		Overrides:    len(rule.RuleManager.Overrides(now)),
+		Cache:        rule.RuleManager.CacheStats(),
+	}
+	for _, name := range rule.Lists {
+		domains, _ := rc.List(name)
+		status.Lists[name] = len(domains)
+	}
+
+	writeJSON(response, request, http.StatusOK, status)
+}
+
+// logHandler returns the most recent decisions, filtered by the client and
+// verdict query parameters and capped by limit
+func logHandler(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			hlog.FromRequest(request).Error().
+				Str("limit", value).
+				Msg("invalid limit")
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	client := query.Get("client")
+	verdict := query.Get("verdict")
+	if verdict != "" && verdict != "allow" && verdict != "deny" {
+		hlog.FromRequest(request).Error().
+			Str("verdict", verdict).
+			Msg("invalid verdict")
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	decisions := events.Decisions.Recent(limit, func(d *events.Decision) bool {
+		return (client == "" || d.Client == client) &&
+			(verdict == "" || d.Verdict() == verdict)
+	})
+	writeJSON(response, request, http.StatusOK, decisions)
+}
+
+// explainHandler shows how the rules decide the url query parameter for the
+// client query parameter
+func explainHandler(response http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	target := query.Get("url")
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	explained, err := http.NewRequest("GET", target, nil)
+	if err != nil || explained.Host == "" {
+		hlog.FromRequest(request).Error().
+			Err(err).
+			Str("url", query.Get("url")).
+			Msg("invalid url to explain")
+		response.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if client := query.Get("client"); client != "" {
+		if net.ParseIP(client) == nil {
+			hlog.FromRequest(request).Error().
+				Str("client", client).
+				Msg("invalid client to explain")
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		explained.RemoteAddr = client
+	}
+
+	writeJSON(response, request, http.StatusOK,
+		rule.RuleManager.Explain(explained))
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// selfSignedValidity is how long a generated certificate is valid for
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// TLSConfig enables TLS on the API when a certificate is configured
+type TLSConfig struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// SelfSigned generates a certificate at Cert and Key if they don't
+	// exist yet, it is reused on later starts so that its fingerprint
+	// stays the same
+	SelfSigned bool `yaml:"self_signed"`
+	// Hosts are extra names and addresses put in a generated certificate
+	Hosts []string `yaml:"hosts"`
+}
+
+// Enabled reports whether the API should be served over TLS
+func (tc *TLSConfig) Enabled() bool {
+	return tc.SelfSigned || tc.Cert != "" || tc.Key != ""
+}
+
+// Fingerprint is the hex encoded sha256 digest of a DER certificate, the
+// format sit expects for pinning
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// loadCertificate loads the configured certificate, generating it first if
+// it is self signed and doesn't exist
+func loadCertificate(tc TLSConfig, address string) (tls.Certificate, error) {
+	if tc.Cert == "" || tc.Key == "" {
+		return tls.Certificate{}, fmt.Errorf("tls needs both a cert and a key")
+	}
+
+	if tc.SelfSigned {
+		_, err := os.Stat(tc.Cert)
+		if os.IsNotExist(err) {
+			err = generateCertificate(tc, address)
+		}
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(tc.Cert, tc.Key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	log.Info().
+		Str("cert", tc.Cert).
+		Str("fingerprint", Fingerprint(cert.Certificate[0])).
+		Msg("api certificate")
+	return cert, nil
+}
+
+// generateCertificate writes a new self signed certificate valid for the
+// listen address, this machine and the configured hosts
+func generateCertificate(tc TLSConfig, address string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "babysitter"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	hosts := append([]string{"localhost", "127.0.0.1", "::1"}, tc.Hosts...)
+	if host, _, err := net.SplitHostPort(address); err == nil && host != "" {
+		hosts = append(hosts, host)
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(
+		rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(tc.Key,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		0600)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(tc.Cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0644)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("cert", tc.Cert).Msg("generated self signed api certificate")
+	return nil
+}
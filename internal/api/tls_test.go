@@ -0,0 +1,42 @@
+package api
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+)
+
+func Test_SelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	tc := TLSConfig{
+		Cert:       filepath.Join(dir, "api.crt"),
+		Key:        filepath.Join(dir, "api.key"),
+		SelfSigned: true,
+		Hosts:      []string{"babysitter.lan"},
+	}
+
+	first, err := loadCertificate(tc, "192.168.1.2:8443")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	leaf, err := x509.ParseCertificate(first.Certificate[0])
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	for _, host := range []string{"localhost", "babysitter.lan", "192.168.1.2"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Errorf("%s: got %v wanted nil", host, err)
+		}
+	}
+
+	// a second start reuses the certificate instead of generating a new one
+	second, err := loadCertificate(tc, "192.168.1.2:8443")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	a, b := Fingerprint(first.Certificate[0]), Fingerprint(second.Certificate[0])
+	if a != b {
+		t.Errorf("got %s wanted %s", b, a)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// RuleUpdateRequest is the body of POST /rules, it replaces the lists it
+// names and every section that is present, an empty section removes
+// everything in it
+type RuleUpdateRequest struct {
+	Rules      map[string][]string    `json:"rules,omitempty"`
+	Groups     []*rule.GroupConfig    `json:"groups,omitempty"`
+	Schedules  []*rule.ScheduleConfig `json:"schedules,omitempty"`
+	Categories []*rule.CategoryConfig `json:"categories,omitempty"`
+	// Content replaces the content rules, an empty object removes them
+	Content *rule.ContentConfig `json:"content,omitempty"`
+	// SafeSearch replaces the safe search settings, disabling it turns it off
+	SafeSearch *rule.SafeSearchConfig `json:"safe_search,omitempty"`
+	// Headers replaces the header rules, an empty list removes them
+	Headers []*rule.HeaderConfig `json:"headers,omitempty"`
+	// Redirects replaces the redirects, an empty list removes them
+	Redirects []*rule.RedirectConfig `json:"redirects,omitempty"`
+}
+
+// ListReplacement is the body of PUT /rules/{list}
+type ListReplacement struct {
+	Domains []string `json:"domains"`
+}
+
+// AccessSubmission is the body of POST /requests
+type AccessSubmission struct {
+	Domain string `json:"domain"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// AccessDecision is the body of POST /requests/{id}
+type AccessDecision struct {
+	// Status is approved or denied
+	Status string `json:"status"`
+}
+
+// OverrideRequest is the body of POST /overrides
+type OverrideRequest struct {
+	Domain string `json:"domain"`
+	// Action is allow or deny
+	Action string `json:"action"`
+	Group  string `json:"group,omitempty"`
+	// Duration is how long the override lasts, like "90m"
+	Duration string `json:"duration"`
+}
+
+// Me is the response of GET /me
+type Me struct {
+	*Principal
+	Restrictions []rule.Restriction `json:"restrictions"`
+}
+
+// ErrorResponse is the body of a failed response
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Errors lists every invalid entry of a rejected change
+	Errors []rule.FieldError `json:"errors,omitempty"`
+}
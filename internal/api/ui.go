@@ -0,0 +1,46 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// ui is the dashboard, a static page using the api with the credentials
+// entered into it
+//
+//go:embed ui
+var ui embed.FS
+
+// uiHandler serves the dashboard under /ui/. The assets hold no secrets and
+// need no credentials, everything they show comes from the api.
+func uiHandler() http.Handler {
+	assets, err := fs.Sub(ui, "ui")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/ui/", http.FileServer(http.FS(assets)))
+
+	return http.HandlerFunc(func(
+		response http.ResponseWriter,
+		request *http.Request,
+	) {
+		if request.Method != "GET" && request.Method != "HEAD" {
+			response.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		response.Header().Set("Content-Security-Policy",
+			"default-src 'self'; frame-ancestors 'none'")
+		response.Header().Set("X-Content-Type-Options", "nosniff")
+		files.ServeHTTP(response, request)
+	})
+}
+
+// rootHandler sends browsers at the root of the api to the dashboard
+func rootHandler(response http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != "/" {
+		http.NotFound(response, request)
+		return
+	}
+	http.Redirect(response, request, "/ui/", http.StatusFound)
+}
@@ -0,0 +1,565 @@
+// The babysitter dashboard. It only talks to the management api, with the
+// credentials entered at sign in kept for the browser session.
+"use strict";
+
+const credentialsKey = "babysitter.authorization";
+
+// ApiError is a failed api response, carrying the field errors of a
+// rejected change
+class ApiError extends Error {
+  constructor(status, body) {
+    super((body && body.error) || "request failed with status " + status);
+    this.status = status;
+    this.fields = (body && body.errors) || [];
+  }
+
+  describe() {
+    if (this.status === 401) {
+      return "Your credentials were not accepted, sign in again.";
+    }
+    if (this.status === 403) {
+      return "You are not permitted to do that.";
+    }
+    if (this.status === 412) {
+      return "The rules were changed by someone else, the page was reloaded.";
+    }
+    const fields = this.fields.map(
+      (f) => f.field + ": " + f.message + (f.value ? " (" + f.value + ")" : ""));
+    return [this.message].concat(fields).join("\n");
+  }
+}
+
+// api sends body as JSON with method to path and returns the decoded
+// response together with the version of the rules it carried
+async function api(method, path, body, version) {
+  const headers = {
+    Authorization: sessionStorage.getItem(credentialsKey),
+    // keeps the browser from prompting for a password on a 401
+    "X-Requested-With": "babysitter",
+  };
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  if (version !== undefined) {
+    headers["If-Match"] = '"' + version + '"';
+  }
+
+  const response = await fetch(path, {
+    method: method,
+    headers: headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  let data = null;
+  if (response.status !== 204) {
+    data = await response.json().catch(() => null);
+  }
+  if (!response.ok) {
+    throw new ApiError(response.status, data);
+  }
+  const etag = response.headers.get("ETag");
+  return { data: data, version: etag ? JSON.parse(etag) : undefined };
+}
+
+// h creates an element, strings among the children become text so nothing
+// from the api is ever interpreted as markup
+function h(tag, attrs, ...children) {
+  const el = document.createElement(tag);
+  for (const [k, v] of Object.entries(attrs || {})) {
+    if (k.startsWith("on")) {
+      el.addEventListener(k.slice(2), v);
+    } else if (v !== false && v !== undefined && v !== null) {
+      el.setAttribute(k, v === true ? "" : v);
+    }
+  }
+  for (const child of children.flat()) {
+    if (child !== null && child !== undefined) {
+      el.append(typeof child === "string" || typeof child === "number"
+        ? document.createTextNode(String(child)) : child);
+    }
+  }
+  return el;
+}
+
+function formatTime(value) {
+  return value ? new Date(value).toLocaleString() : "";
+}
+
+function showMessage(text) {
+  const message = document.getElementById("message");
+  message.textContent = text || "";
+  message.hidden = !text;
+}
+
+// attempt runs action and reports a failure instead of throwing, the view
+// is reloaded after a conflict so the change can be retried
+async function attempt(action) {
+  showMessage("");
+  try {
+    await action();
+    return true;
+  } catch (err) {
+    if (err instanceof ApiError) {
+      showMessage(err.describe());
+      if (err.status === 401) {
+        signOut();
+      } else if (err.status === 412) {
+        render();
+      }
+    } else {
+      showMessage(String(err));
+    }
+    return false;
+  }
+}
+
+// words splits the text of an input on whitespace and commas
+function words(text) {
+  return text.split(/[\s,]+/).map((l) => l.trim()).filter((l) => l !== "");
+}
+
+function panel(title, ...children) {
+  return h("section", { class: "panel" }, h("h2", {}, title), ...children);
+}
+
+// views render a tab into the main element, each only shows what the role
+// of the signed in user may see
+const views = {
+  lists: {
+    title: "Lists",
+    roles: ["admin", "viewer"],
+    async render(main, me) {
+      const { data: rules, version } = await api("GET", "/rules");
+      for (const name of ["blacklist", "whitelist"]) {
+        const domains = rules[name] || [];
+        const rows = domains.map((d) => h("tr", {},
+          h("td", {}, d),
+          h("td", { class: "actions" }, me.role === "admin" &&
+            h("button", {
+              onclick: () => attempt(async () => {
+                await api("DELETE", "/rules/" + name + "/" + encodeURIComponent(d),
+                  undefined, version);
+                render();
+              }),
+            }, "Remove"))));
+
+        const form = me.role === "admin" && h("form", {
+          onsubmit: (e) => {
+            e.preventDefault();
+            const add = words(e.target.domains.value);
+            attempt(async () => {
+              await api("PATCH", "/rules/" + name, { add: add }, version);
+              render();
+            });
+          },
+        },
+        h("label", {}, "Add domains", h("input", { name: "domains", required: true })),
+        h("button", { type: "submit" }, "Add"));
+
+        main.append(panel(name,
+          domains.length ? h("table", {}, h("tbody", {}, rows))
+            : h("p", { class: "muted" }, "No domains."),
+          form));
+      }
+
+      for (const c of rules.categories || []) {
+        main.append(panel("category " + c.name,
+          h("p", { class: "muted" },
+            c.action + (c.schedule ? " during " + c.schedule : "") +
+            (c.groups && c.groups.length ? " for " + c.groups.join(", ") : "")),
+          h("p", {}, (c.domains || []).join(", "))));
+      }
+    },
+  },
+
+  groups: {
+    title: "Groups",
+    roles: ["admin", "viewer"],
+    async render(main, me) {
+      const { data: rules, version } = await api("GET", "/rules");
+      main.append(sectionEditor(me, version, "groups", rules.groups || [], {
+        fields: ["name", "clients", "users"],
+        lists: ["clients", "users"],
+        empty: { name: "", clients: [], users: [] },
+        hint: "Clients are addresses or networks like 192.168.1.0/24. " +
+          "Users are names they log in to the proxy with, a user is in " +
+          "their group on any device.",
+      }));
+    },
+  },
+
+  schedules: {
+    title: "Schedules",
+    roles: ["admin", "viewer"],
+    async render(main, me) {
+      const { data: rules, version } = await api("GET", "/rules");
+      main.append(sectionEditor(me, version, "schedules", rules.schedules || [], {
+        fields: ["name", "days", "start", "end"],
+        lists: ["days"],
+        empty: { name: "", days: [], start: "", end: "" },
+        hint: "Days like mon tue, every day if empty. Times like 21:00, " +
+          "a window ending before it starts runs past midnight.",
+      }));
+    },
+  },
+
+  requests: {
+    title: "Requests",
+    roles: ["admin", "viewer", "child"],
+    async render(main, me) {
+      if (me.role === "child") {
+        main.append(panel("Ask for access", h("form", {
+          onsubmit: (e) => {
+            e.preventDefault();
+            const body = {
+              domain: e.target.domain.value,
+              reason: e.target.reason.value,
+            };
+            attempt(async () => {
+              await api("POST", "/requests", body);
+              render();
+            });
+          },
+        },
+        h("label", {}, "Domain", h("input", { name: "domain", required: true })),
+        h("label", {}, "Why", h("input", { name: "reason" })),
+        h("button", { type: "submit" }, "Ask"))));
+
+        if (me.restrictions && me.restrictions.length) {
+          main.append(panel("Restrictions", h("table", {}, h("tbody", {},
+            me.restrictions.map((r) => h("tr", {},
+              h("td", {}, r.category),
+              h("td", {}, r.action + " during " + r.schedule),
+              h("td", {}, r.active ? "active now" : "not active"),
+              h("td", { class: "muted" },
+                r.changes ? "until " + formatTime(r.changes) : "")))))));
+        }
+      }
+
+      const { data: requests } = await api("GET", "/requests");
+      const decide = (id, status) => attempt(async () => {
+        await api("POST", "/requests/" + id, { status: status });
+        render();
+      });
+      const rows = requests.slice().reverse().map((r) => h("tr", {},
+        h("td", {}, formatTime(r.created)),
+        h("td", {}, r.requester + (r.group ? " (" + r.group + ")" : "")),
+        h("td", {}, r.domain),
+        h("td", {}, r.reason || ""),
+        h("td", { class: r.status === "approved" ? "allow"
+          : r.status === "denied" ? "deny" : "" },
+        r.status + (r.decided_by ? " by " + r.decided_by : "")),
+        h("td", { class: "actions" }, me.role === "admin" && r.status === "pending" && [
+          h("button", { onclick: () => decide(r.id, "approved") }, "Approve"),
+          h("button", { onclick: () => decide(r.id, "denied") }, "Deny"),
+        ])));
+
+      main.append(panel("Access requests", rows.length
+        ? h("table", {},
+          h("thead", {}, h("tr", {},
+            ["Asked", "By", "Domain", "Why", "Status", ""].map((t) => h("th", {}, t)))),
+          h("tbody", {}, rows))
+        : h("p", { class: "muted" }, "No requests.")));
+    },
+  },
+
+  overrides: {
+    title: "Overrides",
+    roles: ["admin", "viewer"],
+    async render(main, me) {
+      const { data: overrides } = await api("GET", "/overrides");
+      const rows = overrides.map((o) => h("tr", {},
+        h("td", {}, o.domain),
+        h("td", { class: o.action }, o.action),
+        h("td", {}, o.group || "everyone"),
+        h("td", {}, formatTime(o.expires)),
+        h("td", {}, o.by || ""),
+        h("td", { class: "actions" }, me.role === "admin" &&
+          h("button", {
+            onclick: () => attempt(async () => {
+              await api("DELETE", "/overrides/" + o.id);
+              render();
+            }),
+          }, "End now"))));
+
+      main.append(panel("Active overrides", rows.length
+        ? h("table", {},
+          h("thead", {}, h("tr", {},
+            ["Domain", "Action", "For", "Until", "By", ""].map((t) => h("th", {}, t)))),
+          h("tbody", {}, rows))
+        : h("p", { class: "muted" }, "No overrides.")));
+
+      if (me.role === "admin") {
+        main.append(panel("Add an override", h("form", {
+          onsubmit: (e) => {
+            e.preventDefault();
+            const f = e.target;
+            const body = {
+              domain: f.domain.value,
+              action: f.action.value,
+              group: f.group.value,
+              duration: f.duration.value,
+            };
+            attempt(async () => {
+              await api("POST", "/overrides", body);
+              render();
+            });
+          },
+        },
+        h("label", {}, "Domain", h("input", { name: "domain", required: true })),
+        h("label", {}, "Action", h("select", { name: "action" },
+          h("option", { value: "allow" }, "allow"),
+          h("option", { value: "deny" }, "deny"))),
+        h("label", {}, "Group", h("input", { name: "group", placeholder: "everyone" })),
+        h("label", {}, "For", h("input", { name: "duration", value: "1h", required: true })),
+        h("button", { type: "submit" }, "Add"))));
+      }
+    },
+  },
+
+  reports: {
+    title: "Reports",
+    roles: ["admin", "viewer"],
+    async render(main) {
+      const [{ data: status }, { data: decisions }] = await Promise.all([
+        api("GET", "/status"), api("GET", "/log?limit=100")]);
+
+      main.append(panel("Status", h("table", {}, h("tbody", {},
+        [
+          ["Running since", formatTime(status.started) + " (" + status.uptime + ")"],
+          ["Rules version", status.rules_version],
+          ["Groups", status.groups],
+          ["Schedules", status.schedules],
+          ["Categories", status.categories],
+          ["Overrides", status.overrides],
+          ["Cache", status.cache.entries + "/" + status.cache.capacity +
+            " entries, " + status.cache.hits + " hits, " +
+            status.cache.misses + " misses"],
+        ].concat(Object.entries(status.lists).map(
+          ([name, n]) => [name, n + " domains"]))
+          .map(([k, v]) => h("tr", {}, h("th", {}, k), h("td", {}, v)))))));
+
+      const body = h("tbody", {}, decisions.map(decisionRow));
+      const live = h("input", { type: "checkbox" });
+      live.addEventListener("change", () => {
+        if (live.checked) {
+          watch((d) => {
+            body.prepend(decisionRow(d));
+            while (body.children.length > 500) {
+              body.lastChild.remove();
+            }
+          });
+        } else {
+          stopWatching();
+        }
+      });
+
+      main.append(panel("Decisions",
+        h("label", {}, h("span", {}, live, " Live")),
+        h("table", {},
+          h("thead", {}, h("tr", {},
+            ["Time", "Client", "Host", "Verdict", "Rule"].map((t) => h("th", {}, t)))),
+          body)));
+    },
+  },
+};
+
+function decisionRow(d) {
+  const verdict = d.allow ? "allow" : "deny";
+  return h("tr", {},
+    h("td", {}, formatTime(d.time)),
+    h("td", {}, (d.user ? d.user + "@" : "") + (d.client || "")),
+    h("td", { title: d.url }, d.host),
+    h("td", { class: verdict }, verdict),
+    h("td", { title: (d.terms || []).join(", ") },
+      d.reason ? d.rule + ": " + d.reason : d.rule));
+}
+
+// sectionEditor edits a whole section of the rules, like the groups, as a
+// table. Saving replaces the section in a single change.
+function sectionEditor(me, version, section, items, spec) {
+  const editable = me.role === "admin";
+  const rows = items.map((item) => Object.assign({}, item));
+
+  const body = h("tbody");
+  const draw = () => {
+    body.replaceChildren(...rows.map((item, i) => h("tr", {},
+      spec.fields.map((field) => {
+        const list = spec.lists.includes(field);
+        const value = list ? (item[field] || []).join(" ") : item[field] || "";
+        if (!editable) {
+          return h("td", {}, value);
+        }
+        const input = h(list ? "textarea" : "input", { "aria-label": field });
+        input.value = value;
+        input.addEventListener("change", () => {
+          item[field] = list ? words(input.value) : input.value.trim();
+        });
+        return h("td", {}, input);
+      }),
+      h("td", { class: "actions" }, editable && h("button", {
+        onclick: () => {
+          rows.splice(i, 1);
+          draw();
+        },
+      }, "Remove")))));
+  };
+  draw();
+
+  return panel(section,
+    h("p", { class: "muted" }, spec.hint),
+    h("table", {},
+      h("thead", {}, h("tr", {}, spec.fields.map((f) => h("th", {}, f)), h("th"))),
+      body),
+    editable && h("p", {},
+      h("button", {
+        onclick: () => {
+          rows.push(Object.assign({}, spec.empty));
+          draw();
+        },
+      }, "New"),
+      " ",
+      h("button", {
+        onclick: () => attempt(async () => {
+          const update = {};
+          update[section] = rows;
+          await api("POST", "/rules", update, version);
+          render();
+        }),
+      }, "Save")));
+}
+
+// watching is the controller of the live event stream, if any
+let watching = null;
+
+function stopWatching() {
+  if (watching) {
+    watching.abort();
+    watching = null;
+  }
+}
+
+// watch calls fn for every live decision. EventSource can't send the
+// Authorization header, so the stream is read with fetch.
+async function watch(fn) {
+  stopWatching();
+  const controller = new AbortController();
+  watching = controller;
+
+  try {
+    const response = await fetch("/events?type=decision", {
+      headers: {
+        Authorization: sessionStorage.getItem(credentialsKey),
+        "X-Requested-With": "babysitter",
+      },
+      signal: controller.signal,
+    });
+    if (!response.ok) {
+      throw new ApiError(response.status, null);
+    }
+
+    const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buffer = "";
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) {
+        break;
+      }
+      buffer += value;
+      let end;
+      while ((end = buffer.indexOf("\n\n")) >= 0) {
+        const block = buffer.slice(0, end);
+        buffer = buffer.slice(end + 2);
+        let type = "message";
+        const data = [];
+        for (const line of block.split("\n")) {
+          if (line.startsWith("event:")) {
+            type = line.slice(6).trim();
+          } else if (line.startsWith("data:")) {
+            data.push(line.slice(5).trim());
+          }
+        }
+        if (type === "decision") {
+          fn(JSON.parse(data.join("\n")));
+        } else if (type === "dropped") {
+          showMessage("Some decisions were skipped, the page fell behind.");
+        }
+      }
+    }
+  } catch (err) {
+    if (!controller.signal.aborted) {
+      showMessage(err instanceof ApiError ? err.describe() : String(err));
+    }
+  }
+}
+
+let me = null;
+let current = null;
+
+async function render() {
+  stopWatching();
+  const main = document.getElementById("view");
+  const tabs = document.getElementById("tabs");
+
+  const visible = Object.keys(views).filter((v) => views[v].roles.includes(me.role));
+  if (!visible.includes(current)) {
+    current = visible[0];
+  }
+  tabs.replaceChildren(...visible.map((v) => h("button", {
+    class: v === current ? "active" : false,
+    onclick: () => {
+      current = v;
+      showMessage("");
+      render();
+    },
+  }, views[v].title)));
+
+  const fresh = h("div");
+  await attempt(() => views[current].render(fresh, me));
+  main.replaceChildren(fresh);
+}
+
+async function signIn(authorization) {
+  sessionStorage.setItem(credentialsKey, authorization);
+  const { data } = await api("GET", "/me");
+  me = data;
+
+  document.getElementById("login").hidden = true;
+  document.getElementById("tabs").hidden = false;
+  document.getElementById("logout").hidden = false;
+  document.getElementById("who").textContent = me.name + " (" + me.role + ")";
+  render();
+}
+
+function signOut() {
+  stopWatching();
+  sessionStorage.removeItem(credentialsKey);
+  me = null;
+  document.getElementById("login").hidden = false;
+  document.getElementById("tabs").hidden = true;
+  document.getElementById("logout").hidden = true;
+  document.getElementById("who").textContent = "";
+  document.getElementById("view").replaceChildren();
+}
+
+document.getElementById("login-form").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const f = e.target;
+  const authorization = f.token.value
+    ? "Bearer " + f.token.value
+    : "Basic " + btoa(f.user.value + ":" + f.password.value);
+  f.reset();
+  const error = document.getElementById("login-error");
+  error.textContent = "";
+  try {
+    await signIn(authorization);
+  } catch (err) {
+    signOut();
+    error.textContent = err instanceof ApiError ? err.describe() : String(err);
+  }
+});
+
+document.getElementById("logout").addEventListener("click", signOut);
+
+// without authentication every request is made as an admin, so try
+// signing in with whatever is stored, or nothing, first
+signIn(sessionStorage.getItem(credentialsKey) || "").catch(signOut);
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_UI(t *testing.T) {
+	server, err := NewServer(Config{
+		Listen: "localhost:0",
+		Auth: AuthConfig{
+			Tokens: []TokenConfig{{Name: "phone", Hash: HashToken("secret")}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	// the dashboard itself needs no credentials
+	for _, path := range []string{"/ui/", "/ui/app.js", "/ui/style.css"} {
+		result := get(path)
+		if result.Code != http.StatusOK {
+			t.Fatalf("%s got %d wanted %d", path, result.Code, http.StatusOK)
+		}
+		if result.Header().Get("Content-Security-Policy") == "" {
+			t.Fatalf("%s got no Content-Security-Policy", path)
+		}
+	}
+	if body := get("/ui/").Body.String(); !strings.Contains(body, "app.js") {
+		t.Fatalf("got %q wanted the dashboard page", body)
+	}
+
+	result := get("/")
+	if result.Code != http.StatusFound || result.Header().Get("Location") != "/ui/" {
+		t.Fatalf("got %d to %s wanted a redirect to /ui/",
+			result.Code, result.Header().Get("Location"))
+	}
+	if result := get("/nothing"); result.Code != http.StatusNotFound {
+		t.Fatalf("got %d wanted %d", result.Code, http.StatusNotFound)
+	}
+
+	// but the api it uses does
+	if result := get("/rules"); result.Code != http.StatusUnauthorized {
+		t.Fatalf("got %d wanted %d", result.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,47 @@
+// Package apitest has helpers for the tests of the api and its clients
+package apitest
+
+import (
+	"reflect"
+	"strings"
+)
+
+// JSONFields returns the types of the fields of t by their json names, like
+// encoding/json sees them. The fields of embedded structs are included.
+func JSONFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for n, embedded := range JSONFields(ft) {
+				fields[n] = embedded
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// Kinds returns the kinds of fields, pointers are followed
+func Kinds(fields map[string]reflect.Type) map[string]reflect.Kind {
+	kinds := make(map[string]reflect.Kind, len(fields))
+	for name, t := range fields {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		kinds[name] = t.Kind()
+	}
+	return kinds
+}
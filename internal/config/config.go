@@ -0,0 +1,778 @@
+package config
+
+import (
+	"bytes"
+	cryptotls "crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jcline/babysitter/internal/api"
+	"github.com/jcline/babysitter/internal/icap"
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// Config is everything babysitter needs to start. It is read from a YAML
+// file, which means JSON files work as well.
+type Config struct {
+	ICAP    icap.Config      `yaml:"icap"`
+	API     api.Config       `yaml:"api"`
+	Lists   ListsConfig      `yaml:"lists"`
+	Cache   rule.CacheConfig `yaml:"cache"`
+	Logging LoggingConfig    `yaml:"logging"`
+	Storage StorageConfig    `yaml:"storage"`
+	// ShutdownTimeout is how long to wait for in flight requests when
+	// stopping
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	Groups     []*rule.GroupConfig    `yaml:"groups"`
+	Schedules  []*rule.ScheduleConfig `yaml:"schedules"`
+	Categories []*rule.CategoryConfig `yaml:"categories"`
+	Content    *rule.ContentConfig    `yaml:"content"`
+	SafeSearch *rule.SafeSearchConfig `yaml:"safe_search"`
+	Headers    []*rule.HeaderConfig   `yaml:"headers"`
+	Redirects  []*rule.RedirectConfig `yaml:"redirects"`
+
+	// root is the parsed document, it is used to find the line a value
+	// came from when reporting errors
+	root *yaml.Node
+}
+
+// ListsConfig points to the files containing the domain lists, and to the
+// users file putting the users who authenticate to the proxy in groups
+type ListsConfig struct {
+	Whitelist string `yaml:"whitelist"`
+	Blacklist string `yaml:"blacklist"`
+	Users     string `yaml:"users"`
+}
+
+// StorageConfig is where babysitter keeps the state it creates itself
+type StorageConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+type LoggingConfig struct {
+	// Level is one of trace, debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is console, json or auto, auto uses the console format when
+	// stdout is a terminal
+	Format string `yaml:"format"`
+}
+
+// Default returns the configuration used when no file is given
+func Default() *Config {
+	return &Config{
+		ICAP: icap.Config{
+			Listen:         "localhost:9001",
+			Preview:        icap.DefaultPreview,
+			ScanLimit:      icap.DefaultScanLimit,
+			IdleTimeout:    icap.DefaultIdleTimeout,
+			MaxConnections: icap.DefaultMaxConnections,
+			MaxQueued:      icap.DefaultMaxQueued,
+			QueueTimeout:   icap.DefaultQueueTimeout,
+			KeepAlive:      icap.DefaultKeepAlive,
+		},
+		API: api.Config{Listen: "localhost:80"},
+		Lists: ListsConfig{
+			Whitelist: "/etc/babysitter/whitelist",
+			Blacklist: "/etc/babysitter/blacklist",
+		},
+		Cache:           rule.CacheConfig{Size: rule.DefaultCacheSize},
+		Logging:         LoggingConfig{Level: "info", Format: "auto"},
+		Storage:         StorageConfig{Dir: "/var/lib/babysitter"},
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+// Error is a problem with a single value in the configuration
+type Error struct {
+	// Line is where the value is in the file, 0 if it didn't come from
+	// the file
+	Line    int
+	Path    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if e.Path != "" {
+		fmt.Fprintf(&b, "%s: ", e.Path)
+	}
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// Errors is every problem found in a configuration
+type Errors []*Error
+
+func (es Errors) Error() string {
+	messages := make([]string, len(es))
+	for i, e := range es {
+		messages[i] = e.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+var lineRe = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors converts the messages of the yaml package, which embed the line
+// number, into Errors
+func yamlErrors(messages ...string) Errors {
+	var errs Errors
+	for _, m := range messages {
+		e := &Error{Message: m}
+		if match := lineRe.FindStringSubmatch(m); match != nil {
+			e.Line, _ = strconv.Atoi(match[1])
+			e.Message = match[2]
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// Load reads the configuration at path on top of the defaults. Unknown keys
+// and values of the wrong type are returned as Errors along with the rest of
+// the configuration so that they can be reported together with the result
+// of Validate.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse is Load for a configuration that has already been read
+func Parse(data []byte) (*Config, error) {
+	c := Default()
+
+	var root yaml.Node
+	err := yaml.Unmarshal(data, &root)
+	if err != nil {
+		return nil, yamlErrors(err.Error())
+	}
+	c.root = &root
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(c)
+	switch e := err.(type) {
+	case nil:
+	case *yaml.TypeError:
+		return c, yamlErrors(e.Errors...)
+	default:
+		if err == io.EOF {
+			// an empty file is just the defaults
+			break
+		}
+		return nil, yamlErrors(err.Error())
+	}
+
+	return c, nil
+}
+
+// Resolve fills in the values that default to something derived from other
+// values, it must be called after any overrides and before Validate
+func (c *Config) Resolve() {
+	if c.API.TLS.SelfSigned {
+		if c.API.TLS.Cert == "" {
+			c.API.TLS.Cert = filepath.Join(c.Storage.Dir, "api.crt")
+		}
+		if c.API.TLS.Key == "" {
+			c.API.TLS.Key = filepath.Join(c.Storage.Dir, "api.key")
+		}
+	}
+}
+
+// line finds the line of the value at path, or of its closest parent if the
+// value isn't in the file
+func (c *Config) line(path ...interface{}) int {
+	if c.root == nil || len(c.root.Content) == 0 {
+		return 0
+	}
+
+	node := c.root.Content[0]
+	line := node.Line
+	for _, p := range path {
+		var next *yaml.Node
+		switch key := p.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				return line
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+		if next == nil {
+			return line
+		}
+		node = next
+		line = node.Line
+	}
+	return line
+}
+
+// validator collects errors along with where they were found
+type validator struct {
+	c    *Config
+	errs Errors
+}
+
+func (v *validator) errorf(path []interface{}, format string, args ...interface{}) {
+	var name strings.Builder
+	for _, p := range path {
+		switch key := p.(type) {
+		case string:
+			if name.Len() > 0 {
+				name.WriteString(".")
+			}
+			name.WriteString(key)
+		case int:
+			fmt.Fprintf(&name, "[%d]", key)
+		}
+	}
+
+	v.errs = append(v.errs, &Error{
+		Line:    v.c.line(path...),
+		Path:    name.String(),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// at appends to a path without sharing the underlying array
+func at(path []interface{}, more ...interface{}) []interface{} {
+	result := make([]interface{}, 0, len(path)+len(more))
+	result = append(result, path...)
+	return append(result, more...)
+}
+
+// Validate checks every value in the configuration and returns all the
+// problems it found, it does not stop at the first one
+func (c *Config) Validate() Errors {
+	v := &validator{c: c}
+
+	v.address(at(nil, "icap", "listen"), c.ICAP.Listen)
+	if c.ICAP.Preview <= 0 {
+		v.errorf(at(nil, "icap", "preview"), "must be positive")
+	}
+	if c.ICAP.ScanLimit <= 0 {
+		v.errorf(at(nil, "icap", "scan_limit"), "must be positive")
+	}
+	if c.ICAP.ReadTimeout < 0 {
+		v.errorf(at(nil, "icap", "read_timeout"), "cannot be negative")
+	}
+	if c.ICAP.WriteTimeout < 0 {
+		v.errorf(at(nil, "icap", "write_timeout"), "cannot be negative")
+	}
+	if c.ICAP.MaxConnections <= 0 {
+		v.errorf(at(nil, "icap", "max_connections"), "must be positive")
+	}
+	if c.ICAP.QueueTimeout <= 0 {
+		v.errorf(at(nil, "icap", "queue_timeout"), "must be positive")
+	}
+	v.address(at(nil, "api", "listen"), c.API.Listen)
+	v.tls()
+
+	if !filepath.IsAbs(c.Storage.Dir) {
+		v.errorf(at(nil, "storage", "dir"),
+			"must be an absolute path, got %q", c.Storage.Dir)
+	}
+
+	if c.Lists.Whitelist != "" {
+		if _, err := rule.LoadWhitelist(c.Lists.Whitelist); err != nil {
+			v.errorf(at(nil, "lists", "whitelist"), "%v", err)
+		}
+	}
+	if c.Lists.Blacklist != "" {
+		if _, err := rule.LoadBlacklist(c.Lists.Blacklist); err != nil {
+			v.errorf(at(nil, "lists", "blacklist"), "%v", err)
+		}
+	}
+
+	if c.Cache.Size <= 0 {
+		v.errorf(at(nil, "cache", "size"), "must be positive")
+	}
+	if c.Cache.TTL < 0 {
+		v.errorf(at(nil, "cache", "ttl"), "cannot be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		v.errorf(at(nil, "shutdown_timeout"), "must be positive")
+	}
+
+	if _, err := zerolog.ParseLevel(c.Logging.Level); err != nil ||
+		c.Logging.Level == "" {
+		v.errorf(at(nil, "logging", "level"),
+			"invalid level %q", c.Logging.Level)
+	}
+	switch c.Logging.Format {
+	case "auto", "console", "json":
+	default:
+		v.errorf(at(nil, "logging", "format"),
+			"invalid format %q, expected auto, console or json",
+			c.Logging.Format)
+	}
+
+	groups := v.groups()
+	v.users(groups)
+	v.services(groups)
+	v.auth(groups)
+	schedules := v.schedules()
+	v.categories(groups, schedules)
+	v.content(groups)
+	v.safeSearch(groups)
+	v.headers(groups)
+	v.redirects(groups)
+
+	return v.errs
+}
+
+func (v *validator) address(path []interface{}, address string) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		v.errorf(path, "invalid address %q: %v", address, err)
+	}
+}
+
+func (v *validator) tls() {
+	tc := v.c.API.TLS
+	path := at(nil, "api", "tls")
+	if !tc.Enabled() {
+		return
+	}
+
+	if tc.Cert == "" {
+		v.errorf(at(path, "cert"), "a key needs a certificate")
+	}
+	if tc.Key == "" {
+		v.errorf(at(path, "key"), "a certificate needs a key")
+	}
+	if tc.Cert == "" || tc.Key == "" {
+		return
+	}
+
+	if tc.SelfSigned {
+		// it's fine for a self signed certificate not to exist yet, but
+		// we have to be able to create it
+		if _, err := os.Stat(tc.Cert); err == nil {
+			return
+		}
+		if _, err := os.Stat(filepath.Dir(tc.Cert)); err != nil {
+			v.errorf(at(path, "cert"), "cannot create certificate: %v", err)
+		}
+		return
+	}
+
+	if _, err := cryptotls.LoadX509KeyPair(tc.Cert, tc.Key); err != nil {
+		v.errorf(at(path, "cert"), "%v", err)
+	}
+}
+
+func (v *validator) auth(groups map[string]bool) {
+	names := make(map[string]bool)
+	for i, t := range v.c.API.Auth.Tokens {
+		path := at(nil, "api", "auth", "tokens", i)
+		if t.Name == "" {
+			v.errorf(path, "token must have a name")
+		} else if names[t.Name] {
+			v.errorf(at(path, "name"), "duplicate credential %q", t.Name)
+		}
+		names[t.Name] = true
+
+		if err := api.ValidateTokenHash(t.Hash); err != nil {
+			v.errorf(at(path, "hash"), "%v", err)
+		}
+		v.role(path, t.Role, t.Group, groups)
+	}
+
+	for i, u := range v.c.API.Auth.Users {
+		path := at(nil, "api", "auth", "users", i)
+		if u.Name == "" {
+			v.errorf(path, "user must have a name")
+		} else if names[u.Name] {
+			v.errorf(at(path, "name"), "duplicate credential %q", u.Name)
+		}
+		names[u.Name] = true
+
+		if err := api.ValidatePasswordHash(u.Password); err != nil {
+			v.errorf(at(path, "password"), "%v", err)
+		}
+		v.role(path, u.Role, u.Group, groups)
+	}
+}
+
+func (v *validator) role(
+	path []interface{},
+	role, group string,
+	groups map[string]bool,
+) {
+	if _, err := api.ParseRole(role); err != nil {
+		v.errorf(at(path, "role"), "%v", err)
+	}
+	if group != "" && !groups[group] {
+		v.errorf(at(path, "group"), "unknown group %q", group)
+	}
+}
+
+func (v *validator) services(groups map[string]bool) {
+	paths := make(map[string]bool)
+	// every service needs a connection, those without max_connections
+	// share what the others leave
+	connections := 0
+	overcommitted := false
+	for i, s := range v.c.ICAP.Services {
+		path := at(nil, "icap", "services", i)
+		if !strings.HasPrefix(s.Path, "/") {
+			v.errorf(at(path, "path"), "invalid path %q, it must start with /", s.Path)
+		} else if paths[s.Path] {
+			v.errorf(at(path, "path"), "duplicate service %q", s.Path)
+		}
+		paths[s.Path] = true
+
+		switch s.Method {
+		case icap.MethodReqmod, icap.MethodRespmod:
+		default:
+			v.errorf(at(path, "method"),
+				"invalid method %q, expected reqmod or respmod", s.Method)
+		}
+		if s.Group != "" && !groups[s.Group] {
+			v.errorf(at(path, "group"), "unknown group %q", s.Group)
+		}
+		if strings.ContainsAny(s.Name, "\r\n") {
+			v.errorf(at(path, "name"), "invalid name %q", s.Name)
+		}
+		if s.MaxConnections < 0 {
+			v.errorf(at(path, "max_connections"), "cannot be negative")
+		} else if s.MaxConnections > 0 {
+			connections += s.MaxConnections
+		} else {
+			connections++
+		}
+		if !overcommitted && v.c.ICAP.MaxConnections > 0 &&
+			connections > v.c.ICAP.MaxConnections {
+			v.errorf(at(path, "max_connections"),
+				"the services add up to more than icap.max_connections %d",
+				v.c.ICAP.MaxConnections)
+			overcommitted = true
+		}
+		if s.OptionsTTL < 0 || s.OptionsTTL%time.Second != 0 {
+			v.errorf(at(path, "options_ttl"),
+				"must be a positive number of seconds")
+		}
+	}
+}
+
+func (v *validator) groups() map[string]bool {
+	names := make(map[string]bool)
+	for i, g := range v.c.Groups {
+		path := at(nil, "groups", i)
+		if g.Name == "" {
+			v.errorf(path, "group must have a name")
+		} else if names[g.Name] {
+			v.errorf(at(path, "name"), "duplicate group %q", g.Name)
+		}
+		names[g.Name] = true
+
+		for j, client := range g.Clients {
+			if _, err := rule.ParseClient(client); err != nil {
+				v.errorf(at(path, "clients", j), "%v", err)
+			}
+		}
+		for j, user := range g.Users {
+			if err := rule.ValidateUser(user); err != nil {
+				v.errorf(at(path, "users", j), "%v", err)
+			}
+		}
+	}
+	return names
+}
+
+func (v *validator) users(groups map[string]bool) {
+	if v.c.Lists.Users == "" {
+		return
+	}
+	path := at(nil, "lists", "users")
+	users, err := rule.LoadUsers(v.c.Lists.Users)
+	if err != nil {
+		v.errorf(path, "%v", err)
+		return
+	}
+	names := make([]string, 0, len(users))
+	for user := range users {
+		names = append(names, user)
+	}
+	sort.Strings(names)
+	for _, user := range names {
+		if !groups[users[user]] {
+			v.errorf(path, "unknown group %q of user %s", users[user], user)
+		}
+	}
+}
+
+func (v *validator) schedules() map[string]bool {
+	names := make(map[string]bool)
+	for i, s := range v.c.Schedules {
+		path := at(nil, "schedules", i)
+		if s.Name == "" {
+			v.errorf(path, "schedule must have a name")
+		} else if names[s.Name] {
+			v.errorf(at(path, "name"), "duplicate schedule %q", s.Name)
+		}
+		names[s.Name] = true
+
+		for j, d := range s.Days {
+			if _, err := rule.ParseWeekday(d); err != nil {
+				v.errorf(at(path, "days", j), "%v", err)
+			}
+		}
+		start, startErr := rule.ParseClock(s.Start, time.Local)
+		if startErr != nil {
+			v.errorf(at(path, "start"), "%v", startErr)
+		}
+		end, endErr := rule.ParseClock(s.End, time.Local)
+		if endErr != nil {
+			v.errorf(at(path, "end"), "%v", endErr)
+		}
+		if startErr == nil && endErr == nil && start.Equal(end) {
+			v.errorf(at(path, "end"), "start and end cannot be the same")
+		}
+	}
+	return names
+}
+
+func (v *validator) categories(groups, schedules map[string]bool) {
+	names := make(map[string]bool)
+	for i, c := range v.c.Categories {
+		path := at(nil, "categories", i)
+		if c.Name == "" {
+			v.errorf(path, "category must have a name")
+		} else if names[c.Name] {
+			v.errorf(at(path, "name"), "duplicate category %q", c.Name)
+		}
+		names[c.Name] = true
+
+		if _, err := rule.ParseAction(c.Action); err != nil {
+			v.errorf(at(path, "action"), "%v", err)
+		}
+		for j, d := range c.Domains {
+			if rule.ValidateDomain(d) != nil {
+				v.errorf(at(path, "domains", j), "invalid host %q", d)
+			}
+		}
+		for j, g := range c.Groups {
+			if !groups[g] {
+				v.errorf(at(path, "groups", j), "unknown group %q", g)
+			}
+		}
+		if c.Schedule != "" && !schedules[c.Schedule] {
+			v.errorf(at(path, "schedule"),
+				"unknown schedule %q", c.Schedule)
+		}
+	}
+}
+
+func (v *validator) content(groups map[string]bool) {
+	c := v.c.Content
+	if c == nil {
+		return
+	}
+	path := at(nil, "content")
+
+	for i, t := range c.Types {
+		single := &rule.ContentConfig{Types: []string{t}}
+		if err := single.Validate(); err != nil {
+			v.errorf(at(path, "types", i), "%v", err)
+		}
+	}
+	if c.MaxSize < 0 {
+		v.errorf(at(path, "max_size"), "cannot be negative")
+	}
+	valid := true
+	for i, k := range c.Keywords {
+		if strings.TrimSpace(k) == "" {
+			v.errorf(at(path, "keywords", i), "cannot be empty")
+			valid = false
+		}
+	}
+	for i, t := range c.Terms {
+		single := &rule.ContentConfig{Terms: []*rule.TermConfig{t}}
+		if err := single.Validate(); err != nil {
+			v.errorf(at(path, "terms", i), "%v", err)
+			valid = false
+		}
+	}
+	// duplicates are only found by validating the terms together
+	terms := &rule.ContentConfig{Keywords: c.Keywords, Terms: c.Terms}
+	if err := terms.Validate(); err != nil && valid {
+		v.errorf(path, "%v", err)
+	}
+	if c.Threshold < 0 {
+		v.errorf(at(path, "threshold"), "cannot be negative")
+	}
+	for g, threshold := range c.Thresholds {
+		if !groups[g] {
+			v.errorf(at(path, "thresholds", g), "unknown group %q", g)
+		} else if threshold < 0 {
+			v.errorf(at(path, "thresholds", g), "cannot be negative")
+		}
+	}
+	for i, g := range c.Groups {
+		if !groups[g] {
+			v.errorf(at(path, "groups", i), "unknown group %q", g)
+		}
+	}
+}
+
+func (v *validator) safeSearch(groups map[string]bool) {
+	s := v.c.SafeSearch
+	if s == nil {
+		return
+	}
+	path := at(nil, "safe_search")
+
+	for i, e := range s.Engines {
+		single := &rule.SafeSearchConfig{Engines: []string{e}}
+		if err := single.Validate(); err != nil {
+			v.errorf(at(path, "engines", i), "%v", err)
+		}
+	}
+	mode := &rule.SafeSearchConfig{YouTube: s.YouTube}
+	if err := mode.Validate(); err != nil {
+		v.errorf(at(path, "youtube"), "%v", err)
+	}
+	for i, g := range s.Groups {
+		if !groups[g] {
+			v.errorf(at(path, "groups", i), "unknown group %q", g)
+		}
+	}
+}
+
+func (v *validator) headers(groups map[string]bool) {
+	names := make(map[string]bool)
+	for i, h := range v.c.Headers {
+		path := at(nil, "headers", i)
+		if h.Name == "" {
+			v.errorf(path, "header rule must have a name")
+		} else if names[h.Name] {
+			v.errorf(at(path, "name"), "duplicate header rule %q", h.Name)
+		}
+		names[h.Name] = true
+
+		for j, d := range h.Domains {
+			if rule.ValidateDomain(d) != nil {
+				v.errorf(at(path, "domains", j), "invalid host %q", d)
+			}
+		}
+		for j, g := range h.Groups {
+			if !groups[g] {
+				v.errorf(at(path, "groups", j), "unknown group %q", g)
+			}
+		}
+		if len(h.Add) == 0 && len(h.Set) == 0 && len(h.Remove) == 0 {
+			v.errorf(path, "header rule changes nothing")
+		}
+		changes := []struct {
+			op      string
+			headers map[string]string
+		}{{"add", h.Add}, {"set", h.Set}}
+		for _, c := range changes {
+			for name, value := range c.headers {
+				if err := rule.ValidateHeader(name); err != nil {
+					v.errorf(at(path, c.op, name), "%v", err)
+				} else if err := rule.ValidateHeaderValue(value); err != nil {
+					v.errorf(at(path, c.op, name), "%v", err)
+				}
+			}
+		}
+		for j, name := range h.Remove {
+			if err := rule.ValidateHeader(name); err != nil {
+				v.errorf(at(path, "remove", j), "%v", err)
+			}
+		}
+	}
+}
+
+func (v *validator) redirects(groups map[string]bool) {
+	names := make(map[string]bool)
+	for i, r := range v.c.Redirects {
+		path := at(nil, "redirects", i)
+		if r.Name == "" {
+			v.errorf(path, "redirect must have a name")
+		} else if names[r.Name] {
+			v.errorf(at(path, "name"), "duplicate redirect %q", r.Name)
+		}
+		names[r.Name] = true
+
+		if err := rule.ValidateRedirectURL(r.URL); err != nil {
+			v.errorf(at(path, "url"), "%v", err)
+		}
+		if r.Status != 0 && r.Status != http.StatusFound &&
+			r.Status != http.StatusTemporaryRedirect {
+			v.errorf(at(path, "status"),
+				"invalid status %d, expected 302 or 307", r.Status)
+		}
+		for j, g := range r.Groups {
+			if !groups[g] {
+				v.errorf(at(path, "groups", j), "unknown group %q", g)
+			}
+		}
+	}
+}
+
+// RuleConfig loads the domain lists and combines them with the groups,
+// schedules and categories. The users in the users file are added to their
+// groups.
+func (c *Config) RuleConfig() (*rule.RuleConfig, error) {
+	rc, err := rule.NewRuleConfig(c.Lists.Whitelist, c.Lists.Blacklist)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty slice rather than nil, so that updating the rules with this
+	// clears sections that were removed from the file
+	rc.Groups = append([]*rule.GroupConfig{}, c.Groups...)
+	if c.Lists.Users != "" {
+		users, err := rule.LoadUsers(c.Lists.Users)
+		if err != nil {
+			return nil, err
+		}
+		rc.Groups, err = rule.AddUsers(rc.Groups, users)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rc.Schedules = append([]*rule.ScheduleConfig{}, c.Schedules...)
+	rc.Categories = append([]*rule.CategoryConfig{}, c.Categories...)
+	rc.Headers = append([]*rule.HeaderConfig{}, c.Headers...)
+	rc.Redirects = append([]*rule.RedirectConfig{}, c.Redirects...)
+	rc.Content = &rule.ContentConfig{}
+	if c.Content != nil {
+		rc.Content = c.Content
+	}
+	rc.SafeSearch = &rule.SafeSearchConfig{}
+	if c.SafeSearch != nil {
+		rc.SafeSearch = c.SafeSearch
+	}
+	return rc, nil
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Parse(t *testing.T) {
+	c, err := Parse([]byte(`
+icap:
+  listen: 0.0.0.0:1344
+cache:
+  ttl: 5m
+groups:
+  - name: kids
+    clients: [192.168.1.10, 192.168.2.0/24]
+schedules:
+  - name: bedtime
+    days: [sun, mon, tue, wed, thu]
+    start: "21:00"
+    end: "07:00"
+categories:
+  - name: social
+    action: deny
+    domains: [facebook.com, tiktok.com]
+    groups: [kids]
+    schedule: bedtime
+`))
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	if c.ICAP.Listen != "0.0.0.0:1344" {
+		t.Fatalf("got %v wanted 0.0.0.0:1344", c.ICAP.Listen)
+	}
+	// unset values keep their defaults
+	if c.API.Listen != Default().API.Listen {
+		t.Fatalf("got %v wanted %v", c.API.Listen, Default().API.Listen)
+	}
+	if c.Cache.TTL != 5*time.Minute {
+		t.Fatalf("got %v wanted 5m", c.Cache.TTL)
+	}
+	if len(c.Categories) != 1 || c.Categories[0].Schedule != "bedtime" {
+		t.Fatalf("got %+v wanted the social category", c.Categories)
+	}
+
+	// the default lists don't exist here
+	c.Lists = ListsConfig{}
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatalf("got %v wanted no errors", errs)
+	}
+}
+
+func Test_Parse_Errors(t *testing.T) {
+	_, err := Parse([]byte(`
+icap:
+  listen: 0.0.0.0:1344
+  bogus: true
+cache:
+  size: many
+`))
+
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("got %v wanted Errors", err)
+	}
+
+	lines := map[int]bool{}
+	for _, e := range errs {
+		lines[e.Line] = true
+	}
+	if len(errs) != 2 || !lines[4] || !lines[6] {
+		t.Fatalf("got %v wanted errors on lines 4 and 6", errs)
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	c, err := Parse([]byte(`
+icap:
+  listen: nope
+  max_connections: 0
+  services:
+    - path: reqmod
+      method: reqmod
+    - path: /kids
+      method: filter
+      group: teens
+    - path: /kids
+      method: respmod
+lists:
+  whitelist: ""
+  blacklist: ""
+groups:
+  - name: kids
+    clients:
+      - 192.168.1.10
+      - 300.1.1.1
+schedules:
+  - name: bedtime
+    days: [sun, someday]
+    start: "21:00"
+    end: "25:00"
+categories:
+  - name: social
+    action: maybe
+    domains: [facebook.com]
+    groups: [kids, adults]
+    schedule: never
+content:
+  types: [video]
+  groups: [adults]
+  thresholds:
+    adults: 5
+safe_search:
+  enabled: true
+  engines: [google, altavista]
+  youtube: lenient
+headers:
+  - name: workspace
+    domains: [google.com]
+    set:
+      Host: example.com
+  - name: workspace
+    remove: [Referer]
+redirects:
+  - name: blocked
+    url: "/blocked?url={url}&by={who}"
+    status: 301
+`))
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	want := map[string]int{
+		"icap.listen":               3,
+		"icap.max_connections":      4,
+		"icap.services[0].path":     6,
+		"icap.services[1].method":   9,
+		"icap.services[1].group":    10,
+		"icap.services[2].path":     11,
+		"groups[0].clients[1]":      20,
+		"schedules[0].days[1]":      23,
+		"schedules[0].end":          25,
+		"categories[0].action":      28,
+		"categories[0].groups[1]":   30,
+		"categories[0].schedule":    31,
+		"content.types[0]":          33,
+		"content.groups[0]":         34,
+		"content.thresholds.adults": 36,
+		"safe_search.engines[1]":    39,
+		"safe_search.youtube":       40,
+		"headers[0].set.Host":       45,
+		"headers[1].name":           46,
+		"redirects[0].url":          50,
+		"redirects[0].status":       51,
+	}
+
+	errs := c.Validate()
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors wanted %d: %v", len(errs), len(want), errs)
+	}
+	for _, e := range errs {
+		line, ok := want[e.Path]
+		if !ok {
+			t.Fatalf("got unexpected error %v", e)
+		}
+		if line != e.Line {
+			t.Fatalf("got line %d wanted %d for %v", e.Line, line, e)
+		}
+	}
+}
+
+func Test_Validate_Connections(t *testing.T) {
+	c, err := Parse([]byte(`
+lists:
+  whitelist: ""
+  blacklist: ""
+icap:
+  max_connections: 100
+  services:
+    - path: /reqmod
+      method: reqmod
+      max_connections: 60
+    - path: /respmod
+      method: respmod
+    - path: /reqmod-kids
+      method: reqmod
+      max_connections: 40
+`))
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// /respmod needs a connection of its own
+	errs := c.Validate()
+	if len(errs) != 1 || errs[0].Path != "icap.services[2].max_connections" {
+		t.Fatalf("got %v wanted only icap.services[2].max_connections", errs)
+	}
+
+	c.ICAP.Services[2].MaxConnections = 39
+	if errs := c.Validate(); len(errs) > 0 {
+		t.Fatalf("got %v wanted no errors", errs)
+	}
+}
+
+func Test_Validate_Domains(t *testing.T) {
+	// domains are accepted as the api accepts them, however they're written
+	c, err := Parse([]byte(`
+lists:
+  whitelist: ""
+  blacklist: ""
+categories:
+  - name: social
+    action: deny
+    domains: [Facebook.COM., "not a domain"]
+headers:
+  - name: workspace
+    domains: [Google.com.]
+    remove: [Referer]
+`))
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	errs := c.Validate()
+	if len(errs) != 1 || errs[0].Path != "categories[0].domains[1]" {
+		t.Fatalf("got %v wanted only categories[0].domains[1]", errs)
+	}
+}
@@ -0,0 +1,93 @@
+// Package events records what babysitter decided so it can be reviewed
+// through the api
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultLogSize is how many decisions are kept by Decisions
+const DefaultLogSize = 1000
+
+// Decision is a single filtered request
+type Decision struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client,omitempty"`
+	User   string    `json:"user,omitempty"`
+	Group  string    `json:"group,omitempty"`
+	Host   string    `json:"host"`
+	URL    string    `json:"url"`
+	Allow  bool      `json:"allow"`
+	Rule   string    `json:"rule"`
+	// Reason is what a content rule found, like the MIME type
+	Reason string `json:"reason,omitempty"`
+	// Terms are the keywords and phrases a content rule found
+	Terms []string `json:"terms,omitempty"`
+}
+
+// Verdict is "allow" or "deny"
+func (d *Decision) Verdict() string {
+	if d.Allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// Log keeps the most recent decisions in a ring buffer
+type Log struct {
+	lock    sync.Mutex
+	entries []Decision
+	// next is where the next decision is written
+	next int
+	full bool
+}
+
+// NewLog creates a log holding up to size decisions
+func NewLog(size int) *Log {
+	if size < 1 {
+		size = 1
+	}
+	return &Log{entries: make([]Decision, size)}
+}
+
+// Add records d, overwriting the oldest decision if the log is full
+func (l *Log) Add(d Decision) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entries[l.next] = d
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Recent returns up to limit decisions for which match returns true, newest
+// first. A limit of zero or less returns every match and a nil match
+// matches everything.
+func (l *Log) Recent(limit int, match func(*Decision) bool) []Decision {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+
+	result := []Decision{}
+	for i := 1; i <= count; i++ {
+		d := &l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if match != nil && !match(d) {
+			continue
+		}
+		result = append(result, *d)
+		if limit > 0 && len(result) == limit {
+			break
+		}
+	}
+	return result
+}
+
+// Decisions is the log of decisions made by the ICAP server
+var Decisions = NewLog(DefaultLogSize)
@@ -0,0 +1,31 @@
+package events
+
+import (
+	"testing"
+)
+
+func Test_Log(t *testing.T) {
+	l := NewLog(3)
+	if got := l.Recent(0, nil); len(got) != 0 {
+		t.Fatalf("got %v wanted nothing", got)
+	}
+
+	for _, host := range []string{"a.com", "b.com", "c.com", "d.com"} {
+		l.Add(Decision{Host: host, Allow: host != "c.com"})
+	}
+
+	got := l.Recent(0, nil)
+	if len(got) != 3 || got[0].Host != "d.com" || got[2].Host != "b.com" {
+		t.Fatalf("got %v wanted d.com, c.com and b.com", got)
+	}
+
+	got = l.Recent(1, nil)
+	if len(got) != 1 || got[0].Host != "d.com" {
+		t.Fatalf("got %v wanted d.com", got)
+	}
+
+	got = l.Recent(0, func(d *Decision) bool { return !d.Allow })
+	if len(got) != 1 || got[0].Host != "c.com" {
+		t.Fatalf("got %v wanted c.com", got)
+	}
+}
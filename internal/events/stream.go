@@ -0,0 +1,125 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jcline/babysitter/internal/metrics"
+)
+
+// the types of live events
+const (
+	TypeDecision = "decision"
+	TypeRules    = "rules"
+)
+
+// DefaultBuffer is how many events a subscriber may fall behind before
+// events are dropped for it
+const DefaultBuffer = 256
+
+// Event is something that happened, published to everyone watching
+type Event struct {
+	// ID increases with every event published
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Data is a *Decision for decisions and a description of the change
+	// for rule changes
+	Data interface{} `json:"data"`
+}
+
+// Subscription receives events until it is closed
+type Subscription struct {
+	// C delivers the events, it is closed by Close
+	C      <-chan Event
+	c      chan Event
+	stream *Stream
+	match  func(*Event) bool
+
+	// dropped is guarded by the lock of the stream
+	dropped uint64
+}
+
+// Dropped returns how many events were dropped so far because the
+// subscriber didn't keep up
+func (s *Subscription) Dropped() uint64 {
+	s.stream.lock.Lock()
+	defer s.stream.lock.Unlock()
+	return s.dropped
+}
+
+// Close stops delivering events and closes C
+func (s *Subscription) Close() {
+	s.stream.lock.Lock()
+	defer s.stream.lock.Unlock()
+
+	if _, ok := s.stream.subscribers[s]; ok {
+		delete(s.stream.subscribers, s)
+		close(s.c)
+		metrics.EventSubscribers.Set(float64(len(s.stream.subscribers)))
+	}
+}
+
+// Stream fans events out to subscribers. Publishing never blocks, a
+// subscriber that falls behind loses events instead of holding up
+// whoever published them.
+type Stream struct {
+	lock        sync.Mutex
+	next        uint64
+	subscribers map[*Subscription]struct{}
+}
+
+// NewStream creates a stream without subscribers
+func NewStream() *Stream {
+	return &Stream{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe returns a subscription to the events for which match returns
+// true, a nil match matches everything. Up to buffer events are queued.
+func (s *Stream) Subscribe(buffer int, match func(*Event) bool) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	c := make(chan Event, buffer)
+	sub := &Subscription{C: c, c: c, stream: s, match: match}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers[sub] = struct{}{}
+	metrics.EventSubscribers.Set(float64(len(s.subscribers)))
+	return sub
+}
+
+// Publish sends an event of type typ carrying data to every matching
+// subscriber
+func (s *Stream) Publish(typ string, data interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.next++
+	if len(s.subscribers) == 0 {
+		return
+	}
+	event := Event{ID: s.next, Type: typ, Time: time.Now(), Data: data}
+
+	for sub := range s.subscribers {
+		if sub.match != nil && !sub.match(&event) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			sub.dropped++
+			metrics.EventsDropped.Inc()
+		}
+	}
+}
+
+// Live is the stream of decisions and rule changes served by the api
+var Live = NewStream()
+
+// Record adds d to Decisions and publishes it on Live
+func Record(d Decision) {
+	Decisions.Add(d)
+	Live.Publish(TypeDecision, &d)
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"testing"
+)
+
+func Test_Stream(t *testing.T) {
+	s := NewStream()
+	all := s.Subscribe(2, nil)
+	denied := s.Subscribe(10, func(e *Event) bool {
+		d, ok := e.Data.(*Decision)
+		return ok && !d.Allow
+	})
+
+	// publishing never blocks, the events all can't hold are dropped
+	for _, host := range []string{"a.com", "b.com", "c.com"} {
+		s.Publish(TypeDecision, &Decision{Host: host, Allow: host != "b.com"})
+	}
+	s.Publish(TypeRules, "changed")
+
+	if got := all.Dropped(); got != 2 {
+		t.Fatalf("got %d dropped wanted 2", got)
+	}
+	first := <-all.C
+	if first.ID != 1 || first.Data.(*Decision).Host != "a.com" {
+		t.Fatalf("got %+v wanted a.com", first)
+	}
+
+	if got := denied.Dropped(); got != 0 {
+		t.Fatalf("got %d dropped wanted 0", got)
+	}
+	e := <-denied.C
+	if e.ID != 2 || e.Data.(*Decision).Host != "b.com" {
+		t.Fatalf("got %+v wanted b.com", e)
+	}
+	if len(denied.C) != 0 {
+		t.Fatalf("got %d more events wanted none", len(denied.C))
+	}
+
+	denied.Close()
+	denied.Close()
+	if _, ok := <-denied.C; ok {
+		t.Fatalf("got an event wanted a closed channel")
+	}
+	s.Publish(TypeRules, "changed again")
+}
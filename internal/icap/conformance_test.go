@@ -0,0 +1,624 @@
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// rawRequest is an ICAP request as a client like squid sends it
+type rawRequest struct {
+	method  string
+	service string
+	// header are extra ICAP header lines
+	header []string
+	reqHdr string
+	resHdr string
+	body   string
+	// preview is how many bytes of the body are sent up front, no preview
+	// if negative
+	preview int
+}
+
+func chunk(data string) string {
+	if data == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x\r\n%s\r\n", len(data), data)
+}
+
+// split returns what the client sends first and what it sends after a
+// 100 Continue, which is empty if it has nothing left to send
+func (r rawRequest) split() (string, string) {
+	var encapsulated []string
+	offset := 0
+	if r.reqHdr != "" {
+		encapsulated = append(encapsulated, "req-hdr=0")
+		offset += len(r.reqHdr)
+	}
+	if r.resHdr != "" {
+		encapsulated = append(encapsulated, fmt.Sprintf("res-hdr=%d", offset))
+		offset += len(r.resHdr)
+	}
+	switch {
+	case r.body == "":
+		encapsulated = append(encapsulated, fmt.Sprintf("null-body=%d", offset))
+	case r.method == "REQMOD":
+		encapsulated = append(encapsulated, fmt.Sprintf("req-body=%d", offset))
+	default:
+		encapsulated = append(encapsulated, fmt.Sprintf("res-body=%d", offset))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s icap://127.0.0.1/%s ICAP/1.0\r\n", r.method, r.service)
+	b.WriteString("Host: 127.0.0.1\r\n")
+	for _, h := range r.header {
+		b.WriteString(h + "\r\n")
+	}
+	if r.preview >= 0 {
+		fmt.Fprintf(&b, "Preview: %d\r\n", r.preview)
+	}
+	fmt.Fprintf(&b, "Encapsulated: %s\r\n\r\n", strings.Join(encapsulated, ", "))
+	b.WriteString(r.reqHdr + r.resHdr)
+	if r.body == "" {
+		return b.String(), ""
+	}
+
+	if r.preview < 0 {
+		b.WriteString(chunk(r.body) + "0\r\n\r\n")
+		return b.String(), ""
+	}
+	if len(r.body) <= r.preview {
+		b.WriteString(chunk(r.body) + "0; ieof\r\n\r\n")
+		return b.String(), ""
+	}
+	b.WriteString(chunk(r.body[:r.preview]) + "0\r\n\r\n")
+	return b.String(), chunk(r.body[r.preview:]) + "0\r\n\r\n"
+}
+
+// rawResponse is the final answer to a rawRequest
+type rawResponse struct {
+	status int
+	header textproto.MIMEHeader
+	// http is the encapsulated HTTP header block
+	http string
+	body string
+	// continued is set if the server asked for the rest of the body
+	continued bool
+}
+
+// exchange sends request to the server at addr and reads its answer
+func exchange(t *testing.T, addr string, request rawRequest) *rawResponse {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	first, rest := request.split()
+	if _, err := io.WriteString(conn, first); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	tp := textproto.NewReader(reader)
+	response := &rawResponse{}
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			t.Fatalf("got %v wanted a status line", err)
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 || fields[0] != "ICAP/1.0" {
+			t.Fatalf("got %q wanted an ICAP status line", line)
+		}
+		response.status, err = strconv.Atoi(fields[1])
+		if err != nil {
+			t.Fatalf("got %v wanted nil", err)
+		}
+		response.header, err = tp.ReadMIMEHeader()
+		if err != nil {
+			t.Fatalf("got %v wanted nil", err)
+		}
+		if response.status != 100 {
+			break
+		}
+		if rest == "" {
+			t.Fatalf("got 100 Continue after the whole body was sent")
+		}
+		response.continued = true
+		if _, err := io.WriteString(conn, rest); err != nil {
+			t.Fatalf("got %v wanted nil", err)
+		}
+	}
+
+	hasBody := false
+	for _, item := range strings.Split(response.header.Get("Encapsulated"), ",") {
+		item = strings.TrimSpace(item)
+		eq := strings.Index(item, "=")
+		if eq < 0 {
+			t.Fatalf("got Encapsulated %q", response.header.Get("Encapsulated"))
+		}
+		offset, err := strconv.Atoi(item[eq+1:])
+		if err != nil {
+			t.Fatalf("got %v wanted nil", err)
+		}
+		switch item[:eq] {
+		case "req-hdr", "res-hdr":
+		case "req-body", "res-body":
+			hasBody = true
+			fallthrough
+		default:
+			// whatever came before the body or its absence are the
+			// HTTP headers
+			if offset > 0 {
+				buffer := make([]byte, offset)
+				if _, err := io.ReadFull(reader, buffer); err != nil {
+					t.Fatalf("got %v wanted nil", err)
+				}
+				response.http = string(buffer)
+			}
+		}
+	}
+
+	if hasBody {
+		body, err := ioutil.ReadAll(&dechunker{r: reader})
+		if err != nil {
+			t.Fatalf("got %v wanted nil", err)
+		}
+		response.body = string(body)
+	}
+	return response
+}
+
+// dechunker decodes an ICAP chunked body
+type dechunker struct {
+	r    *bufio.Reader
+	left int
+	done bool
+}
+
+func (d *dechunker) Read(p []byte) (int, error) {
+	if d.done {
+		return 0, io.EOF
+	}
+	if d.left == 0 {
+		line, err := d.r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		size := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+		n, err := strconv.ParseInt(size, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid chunk size %q", size)
+		}
+		if n == 0 {
+			d.done = true
+			d.r.ReadString('\n')
+			return 0, io.EOF
+		}
+		d.left = int(n)
+	}
+	if len(p) > d.left {
+		p = p[:d.left]
+	}
+	n, err := d.r.Read(p)
+	d.left -= n
+	if d.left == 0 && err == nil {
+		_, err = d.r.ReadString('\n')
+	}
+	return n, err
+}
+
+func Test_Conformance(t *testing.T) {
+	err := rule.RuleManager.Update(&rule.RuleConfig{
+		DomainBlacklistConfig: &rule.DomainBlacklistConfig{
+			Blacklist: []string{"blocked.com"},
+		},
+		Headers: []*rule.HeaderConfig{
+			{
+				Name:    "dnt",
+				Domains: []string{"modified.com"},
+				Set:     map[string]string{"DNT": "1"},
+			},
+		},
+		Content: &rule.ContentConfig{
+			Types:    []string{"video/*"},
+			Keywords: []string{"casino"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	s := NewServer(Config{Preview: 16})
+	go s.serve(listener)
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	get := func(host string) string {
+		return fmt.Sprintf("GET http://%s/ HTTP/1.1\r\nHost: %s\r\n\r\n", host, host)
+	}
+	post := func(host, body string) string {
+		return fmt.Sprintf(
+			"POST http://%s/form HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\n\r\n",
+			host, host, len(body))
+	}
+	res := func(contentType, body string) string {
+		return fmt.Sprintf(
+			"HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n",
+			contentType, len(body))
+	}
+	allow204 := []string{"Allow: 204"}
+	page := "<html><body>a page about gardening and nothing else</body></html>"
+	casino := "<html><body>the best online casino in town</body></html>"
+	gzipped := func(contentType, body string) (string, string) {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		io.WriteString(w, body)
+		w.Close()
+		return fmt.Sprintf(
+			"HTTP/1.1 200 OK\r\nContent-Type: %s\r\n"+
+				"Content-Encoding: gzip\r\nContent-Length: %d\r\n\r\n",
+			contentType, b.Len()), b.String()
+	}
+	// long enough to be compressed rather than stored
+	filler := strings.Repeat("<p>nothing to see here</p>", 20)
+	gzipPageHdr, gzipPage := gzipped("text/html", filler+page)
+	gzipCasinoHdr, gzipCasino := gzipped("text/html", filler+casino)
+
+	tests := []struct {
+		name    string
+		request rawRequest
+		status  int
+		// http and body are substrings of the encapsulated message
+		http      string
+		body      string
+		continued bool
+	}{
+		{
+			name:    "options reqmod",
+			request: rawRequest{method: "OPTIONS", service: "reqmod", preview: -1},
+			status:  200,
+		},
+		{
+			name:    "options respmod",
+			request: rawRequest{method: "OPTIONS", service: "respmod", preview: -1},
+			status:  200,
+		},
+		{
+			name: "reqmod allowed with Allow: 204",
+			request: rawRequest{
+				method: "REQMOD", service: "reqmod", header: allow204,
+				reqHdr: get("example.com"), preview: -1,
+			},
+			status: 204,
+		},
+		{
+			name: "reqmod allowed without Allow: 204",
+			request: rawRequest{
+				method: "REQMOD", service: "reqmod",
+				reqHdr: get("example.com"), preview: -1,
+			},
+			status: 200,
+			http:   "GET http://example.com/ HTTP/1.1",
+		},
+		{
+			name: "reqmod allowed without Allow: 204 keeps the body",
+			request: rawRequest{
+				method: "REQMOD", service: "reqmod",
+				reqHdr: post("example.com", "a=1&b=2"), body: "a=1&b=2", preview: -1,
+			},
+			status: 200,
+			http:   "POST http://example.com/form HTTP/1.1",
+			body:   "a=1&b=2",
+		},
+		{
+			name: "reqmod allowed in response to a preview",
+			request: rawRequest{
+				method: "REQMOD", service: "reqmod",
+				reqHdr: post("example.com", "a=1&b=2"), body: "a=1&b=2", preview: 0,
+			},
+			status: 204,
+		},
+		{
+			name: "reqmod denied",
+			request: rawRequest{
+				method: "REQMOD", service: "reqmod", header: allow204,
+				reqHdr: get("www.blocked.com"), preview: -1,
+			},
+			status: 200,
+			http:   "Permitted: no",
+		},
+		{
+			name: "reqmod modified after a preview asks for the body",
+			request: rawRequest{
+				method: "REQMOD", service: "reqmod", header: allow204,
+				reqHdr: post("modified.com", "a=1&b=2"), body: "a=1&b=2", preview: 0,
+			},
+			status:    200,
+			http:      "Dnt: 1",
+			body:      "a=1&b=2",
+			continued: true,
+		},
+		{
+			name: "respmod allowed in response to a preview",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod",
+				reqHdr: get("example.com"), resHdr: res("image/png", "PNG"),
+				body: "PNG", preview: 16,
+			},
+			status: 204,
+		},
+		{
+			name: "respmod allowed without Allow: 204",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod",
+				reqHdr: get("example.com"), resHdr: res("image/png", "PNG"),
+				body: "PNG", preview: -1,
+			},
+			status: 200,
+			http:   "HTTP/1.1 200 OK",
+			body:   "PNG",
+		},
+		{
+			name: "respmod blocked by type",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod",
+				reqHdr: get("example.com"), resHdr: res("video/mp4", page),
+				body: page, preview: 16,
+			},
+			status: 200,
+			http:   "403 Forbidden",
+		},
+		{
+			name: "respmod scans past the preview",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod", header: allow204,
+				reqHdr: get("example.com"), resHdr: res("text/html", page),
+				body: page, preview: 16,
+			},
+			status:    204,
+			continued: true,
+		},
+		{
+			name: "respmod blocked by keyword",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod", header: allow204,
+				reqHdr: get("example.com"), resHdr: res("text/html", casino),
+				body: casino, preview: 16,
+			},
+			status:    200,
+			http:      "403 Forbidden",
+			continued: true,
+		},
+		{
+			name: "respmod scans a gzip page decoded",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod", header: allow204,
+				reqHdr: get("example.com"), resHdr: gzipPageHdr,
+				body: gzipPage, preview: 16,
+			},
+			status:    204,
+			continued: true,
+		},
+		{
+			name: "respmod blocked by keyword in a gzip page",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod", header: allow204,
+				reqHdr: get("example.com"), resHdr: gzipCasinoHdr,
+				body: gzipCasino, preview: 16,
+			},
+			status:    200,
+			http:      "403 Forbidden",
+			continued: true,
+		},
+		{
+			name: "respmod passes on an encoding it can't scan",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod", header: allow204,
+				reqHdr: get("example.com"),
+				resHdr: "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n" +
+					"Content-Encoding: br\r\nContent-Length: 3\r\n\r\n",
+				body: "BR!", preview: 16,
+			},
+			status: 204,
+		},
+		{
+			name: "respmod without a response",
+			request: rawRequest{
+				method: "RESPMOD", service: "respmod", header: allow204,
+				reqHdr: get("example.com"), preview: -1,
+			},
+			status: 400,
+		},
+		{
+			name:    "unknown method",
+			request: rawRequest{method: "LIST", service: "reqmod", preview: -1},
+			status:  405,
+		},
+	}
+	for _, test := range tests {
+		response := exchange(t, addr, test.request)
+		if response.status != test.status {
+			t.Errorf("%s: got %d wanted %d", test.name, response.status, test.status)
+			continue
+		}
+		if response.header.Get("ISTag") == "" {
+			t.Errorf("%s: got no ISTag", test.name)
+		}
+		if !strings.Contains(response.http, test.http) {
+			t.Errorf("%s: got %q wanted %q in it", test.name, response.http, test.http)
+		}
+		if !strings.Contains(response.body, test.body) {
+			t.Errorf("%s: got body %q wanted %q in it", test.name, response.body, test.body)
+		}
+		if response.continued != test.continued {
+			t.Errorf("%s: got continued %v wanted %v", test.name, response.continued, test.continued)
+		}
+	}
+
+	options := exchange(t, addr, rawRequest{method: "OPTIONS", service: "respmod", preview: -1})
+	for name, want := range map[string]string{
+		"Methods": "RESPMOD",
+		"Allow":   "204",
+		"Preview": "16",
+	} {
+		if got := options.header.Get(name); got != want {
+			t.Errorf("got %s: %q wanted %q", name, got, want)
+		}
+	}
+}
+
+func Test_Services(t *testing.T) {
+	err := rule.RuleManager.Update(&rule.RuleConfig{
+		Groups: []*rule.GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+		Categories: []*rule.CategoryConfig{
+			{
+				Name:    "games",
+				Action:  "deny",
+				Domains: []string{"games.com"},
+				Groups:  []string{"kids"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	s := NewServer(Config{Services: []*ServiceConfig{
+		{Path: "/reqmod", Method: MethodReqmod},
+		{Path: "/respmod", Method: MethodRespmod},
+		{
+			Path:           "/reqmod-kids",
+			Method:         MethodReqmod,
+			Group:          "kids",
+			Name:           "Babysitter for kids",
+			MaxConnections: 10,
+			OptionsTTL:     90 * time.Second,
+		},
+	}})
+	go s.serve(listener)
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	options := exchange(t, addr, rawRequest{method: "OPTIONS", service: "reqmod-kids", preview: -1})
+	for name, want := range map[string]string{
+		"Methods":         "REQMOD",
+		"Service":         "Babysitter for kids",
+		"Max-Connections": "10",
+		"Options-TTL":     "90",
+	} {
+		if got := options.header.Get(name); got != want {
+			t.Errorf("got %s: %q wanted %q", name, got, want)
+		}
+	}
+	// /respmod splits the connections /reqmod-kids leaves with /reqmod
+	options = exchange(t, addr, rawRequest{method: "OPTIONS", service: "respmod", preview: -1})
+	for name, want := range map[string]string{
+		"Methods":         "RESPMOD",
+		"Service":         DefaultServiceName,
+		"Max-Connections": strconv.Itoa((DefaultMaxConnections - 10) / 2),
+		"Options-TTL":     "3600",
+	} {
+		if got := options.header.Get(name); got != want {
+			t.Errorf("got %s: %q wanted %q", name, got, want)
+		}
+	}
+
+	games := "GET http://games.com/ HTTP/1.1\r\nHost: games.com\r\n\r\n"
+	tests := []struct {
+		method  string
+		service string
+		status  int
+		http    string
+	}{
+		{"REQMOD", "reqmod", 204, ""},
+		{"REQMOD", "reqmod-kids", 200, "Permitted: no"},
+		{"RESPMOD", "reqmod", 405, ""},
+		{"REQMOD", "respmod", 405, ""},
+		{"REQMOD", "elsewhere", 404, ""},
+	}
+	for _, test := range tests {
+		request := rawRequest{
+			method: test.method, service: test.service, header: []string{"Allow: 204"},
+			reqHdr: games, preview: -1,
+		}
+		if test.method == "RESPMOD" {
+			request.resHdr = "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"
+		}
+		response := exchange(t, addr, request)
+		if response.status != test.status {
+			t.Errorf("%s /%s: got %d wanted %d", test.method, test.service, response.status, test.status)
+			continue
+		}
+		if !strings.Contains(response.http, test.http) {
+			t.Errorf("%s /%s: got %q wanted %q in it", test.method, test.service, response.http, test.http)
+		}
+	}
+}
+
+func Test_Respmod_MaxSize(t *testing.T) {
+	err := rule.RuleManager.Update(&rule.RuleConfig{
+		Content: &rule.ContentConfig{MaxSize: 16},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	s := NewServer(Config{})
+	go s.serve(listener)
+	defer listener.Close()
+
+	// without a Content-Length the size is only known once the body went
+	// past it
+	first, _ := rawRequest{
+		method: "RESPMOD", service: "respmod",
+		reqHdr: "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		resHdr: "HTTP/1.1 200 OK\r\nContent-Type: image/png\r\n\r\n",
+		body:   strings.Repeat("PNG", 100), preview: -1,
+	}.split()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, first); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	answer, _ := ioutil.ReadAll(conn)
+	if !strings.HasPrefix(string(answer), "ICAP/1.0 200 ") {
+		t.Fatalf("got %q wanted a 200", answer)
+	}
+	if strings.HasSuffix(string(answer), "0\r\n\r\n") {
+		t.Fatalf("got %q wanted the body cut off without its last chunk", answer)
+	}
+}
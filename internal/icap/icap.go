@@ -1,78 +1,168 @@
 package icap
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/elico/icap"
 	"github.com/rs/zerolog/log"
 
+	"github.com/jcline/babysitter/internal/events"
+	"github.com/jcline/babysitter/internal/metrics"
 	"github.com/jcline/babysitter/internal/rule"
 )
 
 var istag uint64
 
-func Start(address string, done *sync.WaitGroup) error {
-	defer done.Done()
-
-	atomic.StoreUint64(&istag, uint64(time.Now().Unix()))
-	icap.HandleFunc("/", icapHandler)
-	return icap.ListenAndServe(address, icap.HandlerFunc(icapHandler))
-}
-
 func IncrementTag() {
 	atomic.AddUint64(&istag, 1)
 }
 
-func icapHandler(response icap.ResponseWriter, request *icap.Request) {
+// icapHandler answers request for service, which is nil if there is no
+// service at the path of the request. abort closes the connection the
+// request came in on.
+func icapHandler(
+	conf *Config,
+	service *ServiceConfig,
+	abort func(),
+	response icap.ResponseWriter,
+	request *icap.Request,
+) {
 	start := time.Now()
 	headers := response.Header()
 	headers.Set("ISTag", fmt.Sprintf("\"%d\"", atomic.LoadUint64(&istag)))
-	headers.Set("Service", "Babysitter v1.0")
+	headers.Set("Service", DefaultServiceName)
 
 	var status int
 	var wrappedStatus int
+	var verdict string
+	var decision *rule.Decision
+	var modification *rule.Modification
+	var aborted bool
+
+	if service != nil {
+		headers.Set("Service", service.Name)
+		if request.Request != nil {
+			request.Request = identify(service, request, request.Request)
+		}
+	}
 
-	switch request.Method {
-	case "OPTIONS":
-		// We only support request modification with this server
-		headers.Set("Methods", "REQMOD")
+	switch {
+	case service == nil:
+		// squid was pointed at a service we don't have
+		response.WriteHeader(http.StatusNotFound, nil, false)
+		status = http.StatusNotFound
+		verdict = "unknown_service"
+	case request.Method == "OPTIONS":
+		// every service only supports a single method, respmod is the
+		// one that gets to see responses
 		headers.Set("Allow", "204")
 
 		// How many connections do we permit the client to establish
-		headers.Set("Max-Connections", "1024")
+		headers.Set("Max-Connections", strconv.Itoa(service.MaxConnections))
 
 		// How long can the client cache the response
-		headers.Set("Options-TTL", "3600")
+		headers.Set("Options-TTL",
+			strconv.Itoa(int(service.OptionsTTL/time.Second)))
 
-		// We don't want the client sending us bodies currently
-		headers.Set("Preview", "0")
-		//headers.Set("Transfer-Ignore", "*")
+		if service.isRespmod() {
+			headers.Set("Methods", "RESPMOD")
+			headers.Set("Preview", strconv.Itoa(conf.Preview))
+			headers.Set("Transfer-Preview", "*")
+		} else {
+			headers.Set("Methods", "REQMOD")
+			// We don't want the client sending us request bodies
+			headers.Set("Preview", "0")
+		}
 		response.WriteHeader(http.StatusOK, nil, false)
 		status = http.StatusOK
-	case "REQMOD":
+		verdict = "options"
+	case request.Method == "REQMOD" && !service.isRespmod():
 		headers.Set("Cache-Control", "no-cache")
 
-		if !rule.RuleManager.Allow(request.Request) {
-			request.Request.Header.Add("Permitted", "no")
+		if request.Request == nil {
+			response.WriteHeader(http.StatusBadRequest, nil, false)
+			status = http.StatusBadRequest
+			verdict = "invalid"
+			break
+		}
+
+		decision = rule.RuleManager.Decide(request.Request)
+		events.Record(events.Decision{
+			Time:   start,
+			Client: rule.ClientAddr(request.Request),
+			User:   rule.ClientUser(request.Request),
+			Group:  decision.Group,
+			Host:   request.Request.Host,
+			URL:    request.Request.URL.String(),
+			Allow:  decision.Allow,
+			Rule:   decision.Rule,
+		})
+		if !decision.Allow {
 			status = http.StatusOK
-			response.WriteHeader(status, request.Request, false)
-		} else {
+			verdict = "deny"
+			if to := rule.RuleManager.Redirect(request.Request, decision); to != nil {
+				// answer for the server, squid never contacts it
+				discardBody(request, request.Request.Body)
+				redirect(response, request.Request, to)
+			} else {
+				request.Request.Header.Add("Permitted", "no")
+				sendRequest(response, request)
+			}
+		} else if modification = rule.RuleManager.Modify(request.Request); modification != nil {
+			// allowed, but squid has to send the request as we
+			// changed it
+			modification.Apply(request.Request)
+			status = http.StatusOK
+			verdict = "modify"
+			sendRequest(response, request)
+		} else if may204(request) {
 			// if it's allowed we just return a 204 and squid
 			// proceeds
 			status = http.StatusNoContent
+			verdict = "allow"
 			response.WriteHeader(status, nil, false)
+		} else {
+			// the client can't take a 204, so it gets the request
+			// back as it was
+			status = http.StatusOK
+			verdict = "allow"
+			sendRequest(response, request)
+		}
+
+	case request.Method == "RESPMOD" && service.isRespmod():
+		headers.Set("Cache-Control", "no-cache")
+
+		status, decision = respmod(conf, service, response, request, func() {
+			aborted = true
+			abort()
+		})
+		recordResponse(start, request, decision)
+		switch {
+		case decision == nil:
+			verdict = "invalid"
+		case decision.Allow:
+			verdict = "allow"
+		default:
+			verdict = "deny"
 		}
 
 	default:
-		// wat, we only support OPTIONS and REQMOD
+		// wat, we only support OPTIONS and the method of the service
 		response.WriteHeader(http.StatusMethodNotAllowed, nil, false)
 		status = http.StatusMethodNotAllowed
+		verdict = "unsupported"
 	}
 
+	metrics.ICAPRequests.WithLabelValues(request.Method, verdict).Inc()
+
 	duration := time.Now().Sub(start)
 
 	event := log.Info().
@@ -80,14 +170,111 @@ func icapHandler(response icap.ResponseWriter, request *icap.Request) {
 		Stringer("url", request.URL).
 		Int("status", status).
 		Int("wrapped_status", wrappedStatus).
+		Str("verdict", verdict).
 		Int("preview_size", len(request.Preview)).
 		Str("proto_version", request.Proto).
 		Dur("duration", duration).
 		Str("remote_addr", request.RemoteAddr)
+	if service != nil && service.Path != "" {
+		event.Str("service", service.Path)
+	}
+	if decision != nil {
+		event.Str("rule", decision.Rule)
+	}
+	if modification != nil {
+		event.Strs("modified_by", modification.Rules)
+	}
+	if aborted {
+		event.Bool("aborted", true)
+	}
 	if request.Request != nil {
 		event.Str("domain", request.Request.Host).
-			Str("client", request.Request.RemoteAddr)
+			Str("client", rule.ClientAddr(request.Request)).
+			Str("user", rule.ClientUser(request.Request))
 	}
 
 	event.Msg("")
 }
+
+// allows204 reports whether the client accepts 204 No Content outside of
+// a preview
+func allows204(request *icap.Request) bool {
+	for _, value := range request.Header["Allow"] {
+		for _, code := range strings.Split(value, ",") {
+			if strings.TrimSpace(code) == "204" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasPreview reports whether the client sent a preview, it has to wait for
+// our answer before it sends the rest of the body
+func hasPreview(request *icap.Request) bool {
+	_, ok := request.Header["Preview"]
+	return ok
+}
+
+// may204 reports whether the message can be left alone with a 204 before
+// any of its body beyond the preview was read. RFC 3507 allows that in
+// response to a preview even if the client didn't send Allow: 204.
+func may204(request *icap.Request) bool {
+	return hasPreview(request) || allows204(request)
+}
+
+// discardBody reads the rest of body if the client is sending it anyway,
+// which it is unless it waits for an answer to its preview
+func discardBody(request *icap.Request, body io.Reader) {
+	if !hasPreview(request) && body != nil {
+		io.Copy(ioutil.Discard, body)
+	}
+}
+
+// restOfBody returns body after asking the client for whatever it didn't
+// send in the preview. The 100 Continue has to go out before our answer,
+// so this is done before the answer's headers are written.
+func restOfBody(request *icap.Request, body io.Reader) io.Reader {
+	if !hasPreview(request) {
+		return body
+	}
+	buffered := bufio.NewReader(body)
+	buffered.Peek(len(request.Preview) + 1)
+	return buffered
+}
+
+// sendRequest sends the HTTP request back to the client, with its body if
+// it has one
+func sendRequest(response icap.ResponseWriter, request *icap.Request) {
+	httpRequest := request.Request
+	hasBody := httpRequest.ContentLength != 0 && httpRequest.Body != nil
+	var body io.Reader
+	if hasBody {
+		body = restOfBody(request, httpRequest.Body)
+	}
+	response.WriteHeader(http.StatusOK, httpRequest, hasBody)
+	if !hasBody {
+		return
+	}
+	if _, err := io.Copy(response, body); err != nil {
+		log.Error().Err(err).Msg("could not pass on request body")
+	}
+}
+
+// redirect answers a request with a redirect, without a body
+func redirect(response icap.ResponseWriter, request *http.Request, to *rule.Redirect) {
+	redirected := &http.Response{
+		Status:     fmt.Sprintf("%d %s", to.Status, http.StatusText(to.Status)),
+		StatusCode: to.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Location":       {to.URL},
+			"Content-Length": {"0"},
+			"Cache-Control":  {"no-store"},
+		},
+		Request: request,
+	}
+	response.WriteHeader(http.StatusOK, redirected, false)
+}
@@ -0,0 +1,83 @@
+package icap
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/elico/icap"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// clientIdentity reads who a request is from out of the headers squid adds
+// to ICAP requests with adaptation_send_client_ip and
+// adaptation_send_username. It returns nil if there are none.
+//
+// X-Authenticated-User is base64 encoded and may name the scheme, like
+// Local://alice or WinNT://HOME/alice. X-Client-Username is squid's own
+// header and is used as it is, which requires icap_client_username_encode
+// to be off.
+func clientIdentity(request *icap.Request) *rule.ClientIdentity {
+	identity := &rule.ClientIdentity{}
+
+	if value := strings.TrimSpace(request.Header.Get("X-Client-IP")); value != "" {
+		identity.IP = net.ParseIP(value)
+		if identity.IP == nil {
+			log.Warn().Str("value", value).Msg("ignoring invalid X-Client-IP")
+		}
+	}
+
+	if value := strings.TrimSpace(request.Header.Get("X-Authenticated-User")); value != "" {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			log.Warn().Str("value", value).Msg("ignoring invalid X-Authenticated-User")
+		} else {
+			identity.User = normalizeUser(string(decoded))
+		}
+	}
+	if identity.User == "" {
+		identity.User = normalizeUser(request.Header.Get("X-Client-Username"))
+	}
+
+	if identity.IP == nil && identity.User == "" {
+		return nil
+	}
+	return identity
+}
+
+// normalizeUser strips the scheme and domain from a user name and
+// lowercases it, so that the same user is always named the same
+func normalizeUser(user string) string {
+	user = strings.TrimSpace(user)
+	if i := strings.Index(user, "://"); i >= 0 {
+		user = user[i+3:]
+	}
+	if i := strings.LastIndexAny(user, `/\`); i >= 0 {
+		user = user[i+1:]
+	}
+	if user == "-" {
+		// squid's placeholder for nobody
+		return ""
+	}
+	return strings.ToLower(user)
+}
+
+// identify applies who the client is, as far as request says, and the
+// policy of service to httpRequest, the HTTP request inside request
+func identify(
+	service *ServiceConfig,
+	request *icap.Request,
+	httpRequest *http.Request,
+) *http.Request {
+	if identity := clientIdentity(request); identity != nil {
+		httpRequest = rule.WithIdentity(httpRequest, identity)
+	}
+	if service.Group != "" {
+		// the service decides for its group whoever the client is
+		httpRequest = rule.WithGroup(httpRequest, service.Group)
+	}
+	return httpRequest
+}
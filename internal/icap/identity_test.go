@@ -0,0 +1,102 @@
+package icap
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/textproto"
+	"testing"
+
+	"github.com/elico/icap"
+
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+func Test_ClientIdentity(t *testing.T) {
+	encode := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		header textproto.MIMEHeader
+		ip     string
+		user   string
+	}{
+		{textproto.MIMEHeader{}, "", ""},
+		{textproto.MIMEHeader{"X-Client-Ip": {" 10.0.0.2 "}}, "10.0.0.2", ""},
+		{textproto.MIMEHeader{"X-Client-Ip": {"nope"}}, "", ""},
+		{
+			textproto.MIMEHeader{
+				"X-Client-Ip":          {"fd00::2"},
+				"X-Authenticated-User": {encode("Local://Alice")},
+			},
+			"fd00::2", "alice",
+		},
+		{
+			textproto.MIMEHeader{"X-Authenticated-User": {encode(`WinNT://HOME\bob`)}},
+			"", "bob",
+		},
+		{
+			textproto.MIMEHeader{
+				"X-Authenticated-User": {"not base64!"},
+				"X-Client-Username":    {"carol"},
+			},
+			"", "carol",
+		},
+		{textproto.MIMEHeader{"X-Client-Username": {"-"}}, "", ""},
+	}
+	for _, test := range tests {
+		identity := clientIdentity(&icap.Request{Header: test.header})
+		if test.ip == "" && test.user == "" {
+			if identity != nil {
+				t.Errorf("got %+v wanted nil for %v", identity, test.header)
+			}
+			continue
+		}
+		if identity == nil {
+			t.Errorf("got nil wanted %s %s for %v", test.ip, test.user, test.header)
+			continue
+		}
+		if ip := identity.IP; (ip == nil && test.ip != "") ||
+			(ip != nil && ip.String() != test.ip) || identity.User != test.user {
+			t.Errorf("got %+v wanted %s %s for %v", identity, test.ip, test.user, test.header)
+		}
+	}
+}
+
+func Test_Identify(t *testing.T) {
+	err := rule.RuleManager.Update(&rule.RuleConfig{
+		Groups: []*rule.GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+		Categories: []*rule.CategoryConfig{
+			{
+				Name:    "games",
+				Action:  "deny",
+				Domains: []string{"games.com"},
+				Groups:  []string{"kids"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	request := &icap.Request{Header: textproto.MIMEHeader{
+		"X-Client-Ip":       {"10.0.0.2"},
+		"X-Client-Username": {"Dave"},
+	}}
+	// squid sends the encapsulated request without a remote address
+	httpRequest, _ := http.NewRequest("GET", "http://games.com/", nil)
+
+	identified := identify(newServices(&Config{}).reqmod, request, httpRequest)
+	if got := rule.ClientAddr(identified); got != "10.0.0.2" {
+		t.Fatalf("got %q wanted 10.0.0.2", got)
+	}
+	if got := rule.ClientUser(identified); got != "dave" {
+		t.Fatalf("got %q wanted dave", got)
+	}
+	if decision := rule.RuleManager.Decide(identified); decision.Allow || decision.Group != "kids" {
+		t.Fatalf("got %+v wanted a denial for kids", decision)
+	}
+	if !rule.RuleManager.Allow(httpRequest) {
+		t.Fatalf("got deny wanted allow without the identity")
+	}
+}
@@ -0,0 +1,250 @@
+package icap
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elico/icap"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jcline/babysitter/internal/events"
+	"github.com/jcline/babysitter/internal/rule"
+)
+
+// Config tunes the ICAP services
+type Config struct {
+	Listen string `yaml:"listen"`
+	// Preview is how many bytes of a response body RESPMOD asks for up
+	// front, enough to sniff the type and often the whole of small pages
+	Preview int `yaml:"preview"`
+	// ScanLimit is how much of an HTML page is buffered to look for
+	// keywords, the rest is passed on unscanned
+	ScanLimit int64 `yaml:"scan_limit"`
+	// Services are the ICAP services. Without any every path is served,
+	// by RESPMOD if it ends in respmod and by REQMOD otherwise.
+	Services []*ServiceConfig `yaml:"services"`
+
+	// ReadTimeout limits reading a request including its body, and
+	// WriteTimeout writing the answer. 0 means no limit, which is what
+	// long downloads passing through RESPMOD need.
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// IdleTimeout closes connections nothing arrived on, or that the
+	// client stopped reading from, for this long, whether they wait for
+	// the next request or stall in the middle of one. Negative keeps them
+	// open.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// MaxConnections is how many connections are served at once. Up to
+	// MaxQueued more wait for up to QueueTimeout, they and any others are
+	// rejected with a 503. Negative MaxQueued rejects them right away.
+	MaxConnections int           `yaml:"max_connections"`
+	MaxQueued      int           `yaml:"max_queued"`
+	QueueTimeout   time.Duration `yaml:"queue_timeout"`
+	// KeepAlive is how often TCP keep-alive probes are sent on idle
+	// connections, so that connections to a proxy that went away are
+	// noticed. Negative turns them off.
+	KeepAlive time.Duration `yaml:"keep_alive"`
+}
+
+// the defaults for Config
+const (
+	DefaultPreview        = 4096
+	DefaultScanLimit      = 1 << 20
+	DefaultIdleTimeout    = 5 * time.Minute
+	DefaultMaxConnections = 1024
+	DefaultMaxQueued      = 256
+	DefaultQueueTimeout   = 5 * time.Second
+	DefaultKeepAlive      = 3 * time.Minute
+)
+
+// previewReader counts how much of a body was read, so that we know
+// whether it is still only the preview
+type previewReader struct {
+	io.Reader
+	read int
+}
+
+func (pr *previewReader) Read(p []byte) (int, error) {
+	n, err := pr.Reader.Read(p)
+	pr.read += n
+	return n, err
+}
+
+// respmod checks a response against the content rules. It returns the
+// ICAP status it answered with and the decision, which is nil if there was
+// no response to check. abort is called to cut off a response that turned
+// out to be too large after it was started.
+func respmod(
+	conf *Config,
+	service *ServiceConfig,
+	response icap.ResponseWriter,
+	request *icap.Request,
+	abort func(),
+) (int, *rule.Decision) {
+	if request.Response == nil {
+		response.WriteHeader(http.StatusBadRequest, nil, false)
+		return http.StatusBadRequest, nil
+	}
+	httpRequest := request.Request
+	if httpRequest == nil {
+		// squid always sends the request headers, but they're optional
+		httpRequest, _ = http.NewRequest("GET", "/", nil)
+		httpRequest = identify(service, request, httpRequest)
+	}
+
+	body := &previewReader{Reader: request.Response.Body}
+	if body.Reader == nil {
+		body.Reader = http.NoBody
+	}
+	// a 204 is fine in response to a preview as long as we didn't ask for
+	// more than the preview. Reading all of the preview doesn't ask for
+	// more, only reading past it sends the client a 100 Continue.
+	inPreview := func() bool {
+		return hasPreview(request) && body.read <= len(request.Preview)
+	}
+
+	check := rule.RuleManager.CheckResponse(
+		httpRequest, request.Response, request.Preview)
+	if !check.Allow {
+		return block(response, request, body, inPreview(), check.Decision), check.Decision
+	}
+
+	var scanned []byte
+	if check.Scan {
+		buffer, err := ioutil.ReadAll(io.LimitReader(body, conf.ScanLimit))
+		if err != nil {
+			log.Error().Err(err).Msg("could not read response body")
+			response.WriteHeader(http.StatusBadRequest, nil, false)
+			return http.StatusBadRequest, nil
+		}
+		scanned = buffer
+
+		// the body is passed on as it came, only the scan sees it decoded
+		text, err := rule.DecodeBody(check.Encodings, scanned, conf.ScanLimit)
+		if err != nil {
+			log.Warn().Err(err).Str("url", httpRequest.URL.String()).
+				Msg("passing on a page that can't be scanned")
+		} else {
+			decision := rule.RuleManager.CheckBody(httpRequest, text)
+			if !decision.Allow {
+				return block(response, request, body, inPreview(), decision), decision
+			}
+		}
+	}
+
+	if (inPreview() || allows204(request)) && check.MaxSize == 0 {
+		response.WriteHeader(http.StatusNoContent, nil, false)
+		return http.StatusNoContent, check.Decision
+	}
+
+	// pass the response on as it is, streaming whatever we didn't scan
+	rest := restOfBody(request, body)
+	response.WriteHeader(http.StatusOK, request.Response, true)
+	if _, err := response.Write(scanned); err != nil {
+		return http.StatusOK, check.Decision
+	}
+	if check.MaxSize > 0 {
+		// the length wasn't declared, so the size can only be enforced
+		// by cutting the body off
+		rest = io.LimitReader(rest, check.MaxSize-int64(len(scanned))+1)
+	}
+	written, err := io.Copy(response, rest)
+	if err != nil {
+		log.Error().Err(err).Msg("could not pass on response body")
+	}
+	if check.MaxSize > 0 && int64(len(scanned))+written > check.MaxSize {
+		// ending the body normally would pass the part that was sent on
+		// as the whole response, the connection is closed instead
+		abort()
+		return http.StatusOK, &rule.Decision{
+			Rule:   "content:size",
+			Group:  check.Group,
+			Reason: fmt.Sprintf("more than %d bytes", check.MaxSize),
+		}
+	}
+	return http.StatusOK, check.Decision
+}
+
+// block replaces the response with a page explaining why it was blocked,
+// or with a redirect if one applies
+func block(
+	response icap.ResponseWriter,
+	request *icap.Request,
+	body io.Reader,
+	inPreview bool,
+	decision *rule.Decision,
+) int {
+	// without a preview the whole body is on its way regardless
+	if !inPreview {
+		io.Copy(ioutil.Discard, body)
+	}
+
+	if request.Request != nil {
+		if to := rule.RuleManager.Redirect(request.Request, decision); to != nil {
+			redirect(response, request.Request, to)
+			return http.StatusOK
+		}
+	}
+
+	page := blockedPage(decision)
+	blocked := &http.Response{
+		Status:     "403 Forbidden",
+		StatusCode: http.StatusForbidden,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type":   {"text/html; charset=utf-8"},
+			"Content-Length": {strconv.Itoa(len(page))},
+			"Cache-Control":  {"no-store"},
+		},
+		ContentLength: int64(len(page)),
+		Request:       request.Request,
+	}
+	response.WriteHeader(http.StatusOK, blocked, true)
+	response.Write(page)
+	return http.StatusOK
+}
+
+func blockedPage(decision *rule.Decision) []byte {
+	var b bytes.Buffer
+	b.WriteString("<!DOCTYPE html>\n<html><head><title>Blocked</title></head>")
+	b.WriteString("<body><h1>Blocked by babysitter</h1>")
+	fmt.Fprintf(&b, "<p>This page was blocked by the %s rule",
+		html.EscapeString(decision.Rule))
+	if decision.Reason != "" {
+		fmt.Fprintf(&b, " (%s)", html.EscapeString(decision.Reason))
+	}
+	b.WriteString(".</p></body></html>\n")
+	return b.Bytes()
+}
+
+// recordResponse adds a content decision to the decision log, allowed
+// responses aren't recorded since their request already was
+func recordResponse(start time.Time, request *icap.Request, decision *rule.Decision) {
+	if decision == nil || decision.Allow || request.Request == nil {
+		return
+	}
+	var terms []string
+	for _, m := range decision.Matches {
+		terms = append(terms, m.Term)
+	}
+	events.Record(events.Decision{
+		Time:   start,
+		Client: rule.ClientAddr(request.Request),
+		User:   rule.ClientUser(request.Request),
+		Group:  decision.Group,
+		Host:   request.Request.Host,
+		URL:    request.Request.URL.String(),
+		Allow:  false,
+		Rule:   decision.Rule,
+		Reason: decision.Reason,
+		Terms:  terms,
+	})
+}
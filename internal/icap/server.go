@@ -0,0 +1,438 @@
+package icap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elico/icap"
+	"github.com/rs/zerolog/log"
+
+	"github.com/jcline/babysitter/internal/metrics"
+)
+
+// ErrServerClosed is returned by ListenAndServe after Shutdown was called
+var ErrServerClosed = errors.New("icap: server closed")
+
+// shutdownPollInterval is how often Shutdown checks for in flight requests
+const shutdownPollInterval = 100 * time.Millisecond
+
+// Server wraps the icap server so that it can be drained, the underlying
+// library has no way to stop serving
+type Server struct {
+	conf     Config
+	server   *icap.Server
+	services *services
+
+	// active is the number of requests currently being handled
+	active int64
+	// queued is the number of connections waiting for room to be served
+	queued int64
+	// slots holds a value for every connection being served, it is as
+	// large as MaxConnections
+	slots chan struct{}
+
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+}
+
+// NewServer creates a server for conf, zero values in conf are replaced
+// with the defaults
+func NewServer(conf Config) *Server {
+	if conf.Preview <= 0 {
+		conf.Preview = DefaultPreview
+	}
+	if conf.ScanLimit <= 0 {
+		conf.ScanLimit = DefaultScanLimit
+	}
+	if conf.IdleTimeout == 0 {
+		conf.IdleTimeout = DefaultIdleTimeout
+	}
+	if conf.MaxConnections <= 0 {
+		conf.MaxConnections = DefaultMaxConnections
+	}
+	if conf.MaxQueued == 0 {
+		conf.MaxQueued = DefaultMaxQueued
+	}
+	if conf.QueueTimeout <= 0 {
+		conf.QueueTimeout = DefaultQueueTimeout
+	}
+	if conf.KeepAlive == 0 {
+		conf.KeepAlive = DefaultKeepAlive
+	}
+
+	s := &Server{
+		conf:     conf,
+		services: newServices(&conf),
+		slots:    make(chan struct{}, conf.MaxConnections),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.server = &icap.Server{
+		Addr:         conf.Listen,
+		Handler:      icap.HandlerFunc(s.serveICAP),
+		ReadTimeout:  conf.ReadTimeout,
+		WriteTimeout: conf.WriteTimeout,
+	}
+	return s
+}
+
+func (s *Server) serveICAP(response icap.ResponseWriter, request *icap.Request) {
+	atomic.AddInt64(&s.active, 1)
+	defer atomic.AddInt64(&s.active, -1)
+	abort := func() { s.abort(request.RemoteAddr) }
+	icapHandler(&s.conf, s.services.lookup(request), abort, response, request)
+}
+
+// abort closes the connection from remoteAddr in the middle of a response,
+// so that the client doesn't take what it got for the whole of it
+func (s *Server) abort(remoteAddr string) {
+	s.lock.Lock()
+	var conn net.Conn
+	for c := range s.conns {
+		if c.RemoteAddr().String() == remoteAddr {
+			conn = c
+			break
+		}
+	}
+	s.lock.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// ListenAndServe listens on the configured address and serves icap requests
+// until Shutdown is called or the listener fails
+func (s *Server) ListenAndServe() error {
+	atomic.StoreUint64(&istag, uint64(time.Now().Unix()))
+
+	l, err := net.Listen("tcp", s.conf.Listen)
+	if err != nil {
+		return err
+	}
+	return s.serve(l)
+}
+
+// serve serves icap requests on l until Shutdown is called or l fails
+func (s *Server) serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closing {
+		s.lock.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = newTrackingListener(l, s)
+	s.lock.Unlock()
+
+	err := s.server.Serve(s.listener)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closing {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Shutdown stops accepting connections, waits for in flight requests to
+// finish and then closes every connection. If ctx is done first the
+// connections are closed anyway and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.closing = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+		// Serve closes the listener itself when it returns
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+	}
+	s.lock.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&s.active) > 0 {
+		select {
+		case <-ctx.Done():
+			s.closeConns()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	// Nothing is in flight, the remaining connections are idle keep-alives
+	s.closeConns()
+	return err
+}
+
+func (s *Server) closeConns() {
+	s.lock.Lock()
+	conns := make([]net.Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.lock.Unlock()
+
+	// closing a trackedConn removes it from s.conns, so this has to happen
+	// outside of the lock
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func (s *Server) track(c net.Conn, add bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if add {
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+}
+
+// acquire takes room to serve a connection, it reports whether there was
+// any
+func (s *Server) acquire() bool {
+	select {
+	case s.slots <- struct{}{}:
+		metrics.ICAPConnections.WithLabelValues("served").Inc()
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueue takes a place in the queue of connections waiting for room, it
+// reports whether there was one. wait has to be called if there was.
+func (s *Server) enqueue() bool {
+	if atomic.AddInt64(&s.queued, 1) > int64(s.conf.MaxQueued) {
+		atomic.AddInt64(&s.queued, -1)
+		metrics.ICAPConnections.WithLabelValues("rejected").Inc()
+		return false
+	}
+	metrics.ICAPConnectionsQueued.Inc()
+	return true
+}
+
+// wait waits in the queue for room to serve a connection, for up to
+// QueueTimeout or until done is closed. It reports whether there was room.
+func (s *Server) wait(done <-chan struct{}) bool {
+	defer func() {
+		atomic.AddInt64(&s.queued, -1)
+		metrics.ICAPConnectionsQueued.Dec()
+	}()
+	timer := time.NewTimer(s.conf.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		metrics.ICAPConnections.WithLabelValues("queued").Inc()
+		return true
+	case <-timer.C:
+		metrics.ICAPConnections.WithLabelValues("rejected").Inc()
+		return false
+	case <-done:
+		return false
+	}
+}
+
+// release makes room for the next connection
+func (s *Server) release() {
+	<-s.slots
+}
+
+// reject tells the client of c that we're too busy and closes it. The
+// client may still be sending its request, which we don't wait for.
+func reject(c net.Conn) {
+	c.SetWriteDeadline(time.Now().Add(time.Second))
+	fmt.Fprintf(c, "ICAP/1.0 503 Service Overloaded\r\n"+
+		"ISTag: \"%d\"\r\n"+
+		"Encapsulated: null-body=0\r\n"+
+		"Connection: close\r\n\r\n",
+		atomic.LoadUint64(&istag))
+	c.Close()
+}
+
+// trackingListener records every accepted connection so that Shutdown can
+// close them. It hands connections to the icap server only once there is
+// room to serve them, which the library can't limit itself.
+type trackingListener struct {
+	net.Listener
+	server *Server
+
+	admitted chan net.Conn
+	failed   chan error
+	// done is closed by Close, it stops connections waiting for room
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newTrackingListener(l net.Listener, s *Server) *trackingListener {
+	tl := &trackingListener{
+		Listener: l,
+		server:   s,
+		admitted: make(chan net.Conn),
+		failed:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	go tl.acceptLoop()
+	return tl
+}
+
+// acceptLoop accepts connections until the listener fails. Queued
+// connections wait for room on their own so that they don't hold up the
+// connections behind them, those that don't fit in the queue are rejected
+// right here.
+func (tl *trackingListener) acceptLoop() {
+	s := tl.server
+	for {
+		c, err := tl.Listener.Accept()
+		if err != nil {
+			select {
+			case tl.failed <- err:
+			case <-tl.done:
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+
+		switch {
+		case s.acquire():
+			go tl.admit(c, true)
+		case s.enqueue():
+			go tl.admit(c, false)
+		default:
+			log.Warn().
+				Str("remote_addr", c.RemoteAddr().String()).
+				Int("max_queued", s.conf.MaxQueued).
+				Msg("rejecting connection, too many are waiting to be served")
+			reject(c)
+		}
+	}
+}
+
+// admit hands c to the icap server, once it was served or waited in the
+// queue for room to be
+func (tl *trackingListener) admit(c net.Conn, served bool) {
+	s := tl.server
+	if tcp, ok := c.(*net.TCPConn); ok {
+		if s.conf.KeepAlive < 0 {
+			tcp.SetKeepAlive(false)
+		} else {
+			tcp.SetKeepAlive(true)
+			tcp.SetKeepAlivePeriod(s.conf.KeepAlive)
+		}
+	}
+
+	if !served && !s.wait(tl.done) {
+		log.Warn().
+			Str("remote_addr", c.RemoteAddr().String()).
+			Int("max_connections", s.conf.MaxConnections).
+			Msg("rejecting connection, there is no room to serve it")
+		reject(c)
+		return
+	}
+
+	tc := &trackedConn{Conn: c, server: s}
+	s.track(tc, true)
+	select {
+	case tl.admitted <- tc:
+	case <-tl.done:
+		tc.Close()
+	}
+}
+
+func (tl *trackingListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-tl.admitted:
+		return c, nil
+	case err := <-tl.failed:
+		return nil, err
+	case <-tl.done:
+		return nil, ErrServerClosed
+	}
+}
+
+func (tl *trackingListener) Close() error {
+	tl.closeOnce.Do(func() { close(tl.done) })
+	return tl.Listener.Close()
+}
+
+// trackedConn is a connection being served, it closes itself if nothing
+// arrives on it or the client doesn't read what is sent for IdleTimeout
+type trackedConn struct {
+	net.Conn
+	server *Server
+	once   sync.Once
+
+	lock sync.Mutex
+	// readDeadline and writeDeadline are the deadlines the icap library
+	// set, the idle timeout never extends past them
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// idleDeadline is when the connection counts as idle, or limit if that is
+// earlier
+func (tc *trackedConn) idleDeadline(limit *time.Time) time.Time {
+	deadline := time.Now().Add(tc.server.conf.IdleTimeout)
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	if !limit.IsZero() && limit.Before(deadline) {
+		return *limit
+	}
+	return deadline
+}
+
+func (tc *trackedConn) Read(p []byte) (int, error) {
+	if tc.server.conf.IdleTimeout > 0 {
+		tc.Conn.SetReadDeadline(tc.idleDeadline(&tc.readDeadline))
+	}
+	return tc.Conn.Read(p)
+}
+
+func (tc *trackedConn) Write(p []byte) (int, error) {
+	if tc.server.conf.IdleTimeout > 0 {
+		tc.Conn.SetWriteDeadline(tc.idleDeadline(&tc.writeDeadline))
+	}
+	return tc.Conn.Write(p)
+}
+
+func (tc *trackedConn) SetDeadline(t time.Time) error {
+	tc.lock.Lock()
+	tc.readDeadline = t
+	tc.writeDeadline = t
+	tc.lock.Unlock()
+	return tc.Conn.SetDeadline(t)
+}
+
+func (tc *trackedConn) SetReadDeadline(t time.Time) error {
+	tc.lock.Lock()
+	tc.readDeadline = t
+	tc.lock.Unlock()
+	return tc.Conn.SetReadDeadline(t)
+}
+
+func (tc *trackedConn) SetWriteDeadline(t time.Time) error {
+	tc.lock.Lock()
+	tc.writeDeadline = t
+	tc.lock.Unlock()
+	return tc.Conn.SetWriteDeadline(t)
+}
+
+func (tc *trackedConn) Close() error {
+	tc.once.Do(func() {
+		tc.server.track(tc, false)
+		tc.server.release()
+	})
+	return tc.Conn.Close()
+}
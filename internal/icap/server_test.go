@@ -0,0 +1,141 @@
+package icap
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Server_Limits(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	s := NewServer(Config{
+		MaxConnections: 1,
+		QueueTimeout:   100 * time.Millisecond,
+		IdleTimeout:    500 * time.Millisecond,
+	})
+	go s.serve(listener)
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	// the first connection takes the only slot without sending anything
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	defer idle.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	busy, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	defer busy.Close()
+	busy.SetDeadline(time.Now().Add(2 * time.Second))
+	answer, _ := ioutil.ReadAll(busy)
+	if !strings.HasPrefix(string(answer), "ICAP/1.0 503 ") {
+		t.Fatalf("got %q wanted a 503", answer)
+	}
+
+	// the idle connection is closed, which makes room again
+	idle.SetDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	if n, err := idle.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Fatalf("got %d, %v wanted the connection closed", n, err)
+	}
+	if waited := time.Since(start); waited > 1500*time.Millisecond {
+		t.Fatalf("got closed after %v wanted the idle timeout", waited)
+	}
+
+	options := exchange(t, addr, rawRequest{method: "OPTIONS", service: "reqmod", preview: -1})
+	if options.status != 200 || options.header.Get("Max-Connections") != "1" {
+		t.Fatalf("got %d %v wanted 200 with Max-Connections: 1", options.status, options.header)
+	}
+}
+
+func Test_Server_Queue(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	s := NewServer(Config{
+		MaxConnections: 1,
+		MaxQueued:      1,
+		QueueTimeout:   5 * time.Second,
+	})
+	go s.serve(listener)
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	// the first connection is served and the second waits
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("got %v wanted nil", err)
+		}
+		defer c.Close()
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// with the queue full the third doesn't wait for the queue timeout
+	full, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	defer full.Close()
+	full.SetDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	answer, _ := ioutil.ReadAll(full)
+	if !strings.HasPrefix(string(answer), "ICAP/1.0 503 ") {
+		t.Fatalf("got %q wanted a 503", answer)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("got rejected after %v wanted right away", waited)
+	}
+}
+
+func Test_TrackedConn_Write(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	s := &Server{conf: Config{IdleTimeout: 100 * time.Millisecond}}
+	tc := &trackedConn{Conn: server, server: s}
+
+	// nobody reads, the write gives up after the idle timeout
+	start := time.Now()
+	if _, err := tc.Write([]byte("ICAP/1.0 200 OK\r\n")); err == nil {
+		t.Fatalf("got nil wanted a timeout")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("got a timeout after %v wanted the idle timeout", waited)
+	}
+}
+
+func Test_Server_Shutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	s := NewServer(Config{})
+	served := make(chan error, 1)
+	go func() { served <- s.serve(listener) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// the listener failing closes it before Shutdown gets to
+	listener.Close()
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("got serve still running wanted it to return")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+}
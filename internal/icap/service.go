@@ -0,0 +1,138 @@
+package icap
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elico/icap"
+)
+
+// ServiceConfig is an ICAP service, squid picks one by the path of the
+// icap:// URL it was configured with. Zero values are replaced with the
+// defaults.
+type ServiceConfig struct {
+	// Path is where the service is, like /reqmod-kids
+	Path string `yaml:"path"`
+	// Method is reqmod or respmod
+	Method string `yaml:"method"`
+	// Group applies the policy of this client group to every request of
+	// the service, whatever the address of the client. Without a group
+	// clients get the policy of the group they are in.
+	Group string `yaml:"group"`
+	// Name is sent in the Service header
+	Name string `yaml:"name"`
+	// MaxConnections is how many connections squid may open to the
+	// service. If 0 the service gets an equal share of the connections
+	// the server serves at once that other services didn't claim.
+	MaxConnections int `yaml:"max_connections"`
+	// OptionsTTL is how long squid may cache the answer to OPTIONS
+	OptionsTTL time.Duration `yaml:"options_ttl"`
+}
+
+// the defaults for ServiceConfig
+const (
+	DefaultServiceName = "Babysitter v1.0"
+	DefaultOptionsTTL  = time.Hour
+)
+
+// the methods a service can have
+const (
+	MethodReqmod  = "reqmod"
+	MethodRespmod = "respmod"
+)
+
+func (sc *ServiceConfig) String() string {
+	if sc.Group == "" {
+		return fmt.Sprintf("%s: %s", sc.Path, sc.Method)
+	}
+	return fmt.Sprintf("%s: %s for group %s", sc.Path, sc.Method, sc.Group)
+}
+
+// withDefaults returns a copy of sc with the zero values replaced, a
+// service advertises its share of the connections the server serves
+func (sc ServiceConfig) withDefaults(maxConnections int) *ServiceConfig {
+	if sc.Name == "" {
+		sc.Name = DefaultServiceName
+	}
+	if sc.MaxConnections == 0 {
+		sc.MaxConnections = maxConnections
+	}
+	if sc.OptionsTTL == 0 {
+		sc.OptionsTTL = DefaultOptionsTTL
+	}
+	return &sc
+}
+
+// isRespmod reports whether the service is the one that gets to see
+// responses
+func (sc *ServiceConfig) isRespmod() bool {
+	return sc.Method == MethodRespmod
+}
+
+// services finds the service of a request by its path
+type services struct {
+	byPath map[string]*ServiceConfig
+	// reqmod and respmod serve every path if no services are configured.
+	// Paths ending in respmod are RESPMOD, as is every RESPMOD request.
+	reqmod  *ServiceConfig
+	respmod *ServiceConfig
+}
+
+func newServices(conf *Config) *services {
+	s := &services{}
+	if len(conf.Services) == 0 {
+		shared := share(conf.MaxConnections, 2)
+		s.reqmod = (&ServiceConfig{Method: MethodReqmod}).withDefaults(shared)
+		s.respmod = (&ServiceConfig{Method: MethodRespmod}).withDefaults(shared)
+		return s
+	}
+
+	// every service shares this listener's max_connections, so those
+	// that don't have a cap of their own split what the others leave
+	claimed, unclaimed := 0, 0
+	for _, sc := range conf.Services {
+		if sc.MaxConnections > 0 {
+			claimed += sc.MaxConnections
+		} else {
+			unclaimed++
+		}
+	}
+	shared := share(conf.MaxConnections-claimed, unclaimed)
+	s.byPath = make(map[string]*ServiceConfig, len(conf.Services))
+	for _, sc := range conf.Services {
+		s.byPath[sc.Path] = sc.withDefaults(shared)
+	}
+	return s
+}
+
+// share divides connections between services, each gets at least one
+func share(connections, services int) int {
+	if services > 0 && connections/services > 0 {
+		return connections / services
+	}
+	return 1
+}
+
+// lookup returns the service request is for, or nil if there is no
+// service at its path
+func (s *services) lookup(request *icap.Request) *ServiceConfig {
+	path := "/"
+	if request.URL != nil && request.URL.Path != "" {
+		path = request.URL.Path
+	}
+	if s.byPath == nil {
+		if request.Method == "RESPMOD" ||
+			strings.HasSuffix(strings.TrimSuffix(path, "/"), "respmod") {
+			return s.respmod
+		}
+		return s.reqmod
+	}
+	if sc, ok := s.byPath[path]; ok {
+		return sc
+	}
+	if len(path) > 1 {
+		return s.byPath[strings.TrimSuffix(path, "/")]
+	}
+	return nil
+}
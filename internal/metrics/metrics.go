@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "babysitter"
+
+var (
+	// ICAPRequests counts every ICAP request we handle, by ICAP method and
+	// the verdict we reached for it
+	ICAPRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "icap",
+			Name:      "requests_total",
+			Help:      "ICAP requests handled, by method and verdict.",
+		},
+		[]string{"method", "verdict"},
+	)
+
+	// ICAPConnections counts accepted ICAP connections by whether they
+	// were served at once, served after waiting for room or rejected
+	ICAPConnections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "icap",
+			Name:      "connections_total",
+			Help:      "ICAP connections accepted, by result (served, queued or rejected).",
+		},
+		[]string{"result"},
+	)
+
+	// ICAPConnectionsQueued is the number of connections waiting for room
+	// to be served
+	ICAPConnectionsQueued = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "icap",
+			Name:      "connections_queued",
+			Help:      "Number of ICAP connections waiting to be served.",
+		},
+	)
+
+	// RuleEvaluation tracks how long each rule takes to reach a result
+	RuleEvaluation = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "rule",
+			Name:      "evaluation_seconds",
+			Help:      "Time spent evaluating a single rule.",
+			// rules are evaluated in the order of micro seconds, the
+			// default buckets start at 5ms which is far too coarse
+			Buckets: prometheus.ExponentialBuckets(0.000001, 4, 10),
+		},
+		[]string{"rule"},
+	)
+
+	// DecisionCache counts lookups in the rule manager's decision cache
+	DecisionCache = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rule",
+			Name:      "cache_requests_total",
+			Help:      "Decision cache lookups, by result (hit, miss or expired).",
+		},
+		[]string{"result"},
+	)
+
+	// DecisionCacheEntries is the number of decisions currently cached
+	DecisionCacheEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "rule",
+			Name:      "cache_entries",
+			Help:      "Number of decisions currently held in the cache.",
+		},
+	)
+
+	// RuleSetSize is the number of entries in each loaded list
+	RuleSetSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "rule",
+			Name:      "entries",
+			Help:      "Number of entries in each loaded rule list.",
+		},
+		[]string{"list"},
+	)
+
+	// RuleGeneration is incremented every time the rule set is replaced
+	RuleGeneration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "rule",
+			Name:      "generation",
+			Help:      "Generation of the active rule set, incremented on every update.",
+		},
+	)
+
+	// APIRequests counts requests to the management API
+	APIRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "api",
+			Name:      "requests_total",
+			Help:      "Management API requests, by handler, method and status code.",
+		},
+		[]string{"handler", "method", "code"},
+	)
+
+	// EventSubscribers is the number of clients watching live events
+	EventSubscribers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "events",
+			Name:      "subscribers",
+			Help:      "Number of subscribers to the live event stream.",
+		},
+	)
+
+	// EventsDropped counts events a subscriber missed because it fell
+	// behind
+	EventsDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "events",
+			Name:      "dropped_total",
+			Help:      "Live events dropped because a subscriber fell behind.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		ICAPRequests,
+		ICAPConnections,
+		ICAPConnectionsQueued,
+		RuleEvaluation,
+		DecisionCache,
+		DecisionCacheEntries,
+		RuleSetSize,
+		RuleGeneration,
+		APIRequests,
+		EventSubscribers,
+		EventsDropped,
+	)
+}
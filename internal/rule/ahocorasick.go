@@ -0,0 +1,81 @@
+package rule
+
+// matcher finds every occurrence of a set of terms in a single pass over a
+// text, however many terms there are. It is an Aho–Corasick automaton over
+// bytes.
+type matcher struct {
+	nodes []acNode
+}
+
+type acNode struct {
+	next map[byte]int32
+	// fail is the node for the longest proper suffix of this node's path
+	// that is also a path in the trie
+	fail int32
+	// terms are the indexes of the terms ending at this node, including
+	// those reached through fail links
+	terms []int
+}
+
+func newMatcher(terms []string) *matcher {
+	m := &matcher{nodes: []acNode{{}}}
+	for i, term := range terms {
+		node := int32(0)
+		for j := 0; j < len(term); j++ {
+			next, ok := m.nodes[node].next[term[j]]
+			if !ok {
+				next = int32(len(m.nodes))
+				m.nodes = append(m.nodes, acNode{})
+				if m.nodes[node].next == nil {
+					m.nodes[node].next = make(map[byte]int32)
+				}
+				m.nodes[node].next[term[j]] = next
+			}
+			node = next
+		}
+		m.nodes[node].terms = append(m.nodes[node].terms, i)
+	}
+
+	// the fail links are set breadth first, so that the links of shorter
+	// paths are known when they are needed
+	queue := []int32{}
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for c, child := range m.nodes[node].next {
+			m.nodes[child].fail = m.step(m.nodes[node].fail, c)
+			m.nodes[child].terms = append(m.nodes[child].terms,
+				m.nodes[m.nodes[child].fail].terms...)
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+// step returns the node reached from node by c
+func (m *matcher) step(node int32, c byte) int32 {
+	for {
+		if next, ok := m.nodes[node].next[c]; ok {
+			return next
+		}
+		if node == 0 {
+			return 0
+		}
+		node = m.nodes[node].fail
+	}
+}
+
+// each calls fn with the index of the term and the offset in text just
+// past its end for every occurrence, overlapping occurrences included
+func (m *matcher) each(text []byte, fn func(term, end int)) {
+	node := int32(0)
+	for i, c := range text {
+		node = m.step(node, c)
+		for _, term := range m.nodes[node].terms {
+			fn(term, i+1)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package rule
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_Matcher(t *testing.T) {
+	terms := []string{"he", "she", "his", "hers", "s"}
+	m := newMatcher(terms)
+
+	tests := map[string]map[string]int{
+		"ushers": {"she": 1, "he": 1, "hers": 1, "s": 2},
+		"this":   {"his": 1, "s": 1},
+		"hehe":   {"he": 2},
+		"xyz":    {},
+		"":       {},
+	}
+	for text, want := range tests {
+		got := map[string]int{}
+		m.each([]byte(text), func(term, end int) {
+			if text[end-len(terms[term]):end] != terms[term] {
+				t.Errorf("got %s ending at %d in %q", terms[term], end, text)
+			}
+			got[terms[term]]++
+		})
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v wanted %v for %q", got, want, text)
+		}
+	}
+}
+
+func Test_NormalizeText(t *testing.T) {
+	tests := map[string]string{
+		"Online  CASINO":         "online casino",
+		"online\n\t casino ":     "online casino",
+		"&#99;asino &amp; poker": "casino & poker",
+		"online&nbsp;casino":     "online casino",
+		"  ":                     "",
+		"Ünïcode &Eacute;COLE":   "ünïcode école",
+	}
+	for text, want := range tests {
+		if got := normalizeText(text); got != want {
+			t.Errorf("got %q wanted %q for %q", got, want, text)
+		}
+	}
+}
+
+func Test_StripMarkup(t *testing.T) {
+	tests := map[string]string{
+		"<b>online</b> <i>casino</i>":          " online   casino ",
+		`<p class="ass">x</p>`:                 " x ",
+		`<a title="1 > 2">link</a>`:            " link ",
+		"a<script>var sex = '</b>';</script>b": "a b",
+		"a<STYLE>.casino {}</Style >b":         "a b",
+		"a<!-- casino -->b<!DOCTYPE html>":     "a b ",
+		"1 < 2 &lt;b&gt;":                      "1 < 2 &lt;b&gt;",
+		"cut off <script>casino":               "cut off  ",
+		"cut off <b":                           "cut off  ",
+		"trailing <":                           "trailing <",
+	}
+	for page, want := range tests {
+		if got := stripMarkup(page); got != want {
+			t.Errorf("got %q wanted %q for %q", got, want, page)
+		}
+	}
+}
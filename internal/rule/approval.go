@@ -0,0 +1,72 @@
+package rule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ApprovedCategory is the name of the category that allows the domains
+// approved for the clients of group
+func ApprovedCategory(group string) string {
+	return "approved-" + group
+}
+
+// Approve allows domain for the clients of group only, by adding it to the
+// category ApprovedCategory(group). The category is created the first time
+// a domain is approved for the group. Approving a domain that is already
+// approved doesn't create a version.
+func (rm *Manager) Approve(group, domain string, change Change) error {
+	d, err := NormalizeDomain(domain)
+	if err != nil {
+		return err
+	}
+	name := ApprovedCategory(group)
+
+	rm.updateLock.Lock()
+	defer rm.updateLock.Unlock()
+
+	current, _ := rm.Rules()
+	// the configuration is shared with readers, so the category is copied
+	// rather than changed
+	categories := make([]*CategoryConfig, 0, len(current.Categories)+1)
+	var approved *CategoryConfig
+	for _, c := range current.Categories {
+		if c != nil && c.Name == name {
+			if !strings.EqualFold(c.Action, "allow") ||
+				len(c.Groups) != 1 || c.Groups[0] != group {
+				return fmt.Errorf(
+					"category %s doesn't only allow group %s", name, group)
+			}
+			copied := *c
+			copied.Domains = append([]string(nil), c.Domains...)
+			approved = &copied
+			c = approved
+		}
+		categories = append(categories, c)
+	}
+	if approved == nil {
+		approved = &CategoryConfig{
+			Name:   name,
+			Action: "allow",
+			Groups: []string{group},
+		}
+		categories = append(categories, approved)
+	}
+
+	for _, existing := range approved.Domains {
+		if existing == d {
+			return nil
+		}
+	}
+	approved.Domains = append(approved.Domains, d)
+	sort.Strings(approved.Domains)
+	current.Categories = categories
+
+	rs, err := compile(current)
+	if err != nil {
+		return err
+	}
+	rm.update(rs, current, change)
+	return nil
+}
@@ -0,0 +1,54 @@
+package rule
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Manager_Approve(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		DomainBlacklistConfig: &DomainBlacklistConfig{
+			Blacklist: []string{"example.com"},
+		},
+		Groups: []*GroupConfig{
+			{Name: "kids", Clients: []string{"10.0.0.2"}},
+			{Name: "teens", Clients: []string{"10.0.0.3"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	kid := httptest.NewRequest("GET", "http://www.example.com", nil)
+	kid.RemoteAddr = "10.0.0.2:1234"
+	teen := httptest.NewRequest("GET", "http://www.example.com", nil)
+	teen.RemoteAddr = "10.0.0.3:1234"
+
+	if err := rm.Approve("kids", "Example.com", Change{}); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if !rm.Allow(kid) {
+		t.Fatalf("got deny wanted allow for the group it was approved for")
+	}
+	if rm.Allow(teen) {
+		t.Fatalf("got allow wanted deny for another group")
+	}
+
+	version := rm.Generation()
+	if err := rm.Approve("kids", "example.com", Change{}); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if rm.Generation() != version {
+		t.Fatalf("got version %d wanted %d for an approved domain",
+			rm.Generation(), version)
+	}
+
+	if err := rm.Approve("adults", "example.com", Change{}); err == nil {
+		t.Fatalf("got nil wanted an error for an unknown group")
+	}
+}
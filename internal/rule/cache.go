@@ -0,0 +1,143 @@
+package rule
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/jcline/babysitter/internal/metrics"
+)
+
+const (
+	// DefaultCacheSize is the number of decisions cached when no size has
+	// been configured
+	DefaultCacheSize = 10000
+)
+
+// CacheConfig controls the Manager's decision cache
+type CacheConfig struct {
+	// Size is the maximum number of client+host decisions kept
+	Size int `json:"size" yaml:"size"`
+	// TTL is how long a decision may be served from the cache, zero means
+	// decisions only expire when the rules change
+	TTL time.Duration `json:"ttl" yaml:"ttl"`
+}
+
+// CacheStats is a snapshot of the decision cache counters
+type CacheStats struct {
+	Entries  int           `json:"entries"`
+	Capacity int           `json:"capacity"`
+	TTL      time.Duration `json:"ttl"`
+	Hits     uint64        `json:"hits"`
+	Misses   uint64        `json:"misses"`
+	Expired  uint64        `json:"expired"`
+}
+
+type cacheEntry struct {
+	decision *Decision
+	expires  time.Time
+}
+
+// decisionCache caches the final decision of the Manager keyed by client
+// and host. It does not know anything about rules, the Manager is
+// responsible for flushing it whenever the rules change.
+type decisionCache struct {
+	entries  *lru.TwoQueueCache
+	capacity int
+	ttl      time.Duration
+
+	hits, misses, expired uint64
+}
+
+func newDecisionCache(config CacheConfig) (*decisionCache, error) {
+	if config.Size <= 0 {
+		return nil, fmt.Errorf("cache size must be positive, got %d", config.Size)
+	}
+	if config.TTL < 0 {
+		return nil, fmt.Errorf("cache ttl cannot be negative, got %s", config.TTL)
+	}
+
+	entries, err := lru.New2Q(config.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decisionCache{
+		entries:  entries,
+		capacity: config.Size,
+		ttl:      config.TTL,
+	}, nil
+}
+
+func (dc *decisionCache) get(key string, now time.Time) (*Decision, bool) {
+	value, ok := dc.entries.Get(key)
+	if !ok {
+		atomic.AddUint64(&dc.misses, 1)
+		metrics.DecisionCache.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	entry := value.(*cacheEntry)
+	if !entry.expires.IsZero() && !now.Before(entry.expires) {
+		dc.entries.Remove(key)
+		atomic.AddUint64(&dc.expired, 1)
+		metrics.DecisionCache.WithLabelValues("expired").Inc()
+		return nil, false
+	}
+
+	atomic.AddUint64(&dc.hits, 1)
+	metrics.DecisionCache.WithLabelValues("hit").Inc()
+	return entry.decision, true
+}
+
+// add caches decision until the TTL passes or notAfter, whichever is first.
+// A zero notAfter is ignored.
+func (dc *decisionCache) add(
+	key string,
+	decision *Decision,
+	now, notAfter time.Time,
+) {
+	entry := &cacheEntry{decision: decision}
+	if dc.ttl > 0 {
+		entry.expires = now.Add(dc.ttl)
+	}
+	if !notAfter.IsZero() &&
+		(entry.expires.IsZero() || notAfter.Before(entry.expires)) {
+		entry.expires = notAfter
+	}
+	dc.entries.Add(key, entry)
+	metrics.DecisionCacheEntries.Set(float64(dc.entries.Len()))
+}
+
+func (dc *decisionCache) flush() {
+	dc.entries.Purge()
+	metrics.DecisionCacheEntries.Set(0)
+}
+
+func (dc *decisionCache) stats() CacheStats {
+	return CacheStats{
+		Entries:  dc.entries.Len(),
+		Capacity: dc.capacity,
+		TTL:      dc.ttl,
+		Hits:     atomic.LoadUint64(&dc.hits),
+		Misses:   atomic.LoadUint64(&dc.misses),
+		Expired:  atomic.LoadUint64(&dc.expired),
+	}
+}
+
+// cacheKey builds the key a decision is cached under, the same host may be
+// decided differently for different clients, users and for the groups a
+// request may be bound to
+func cacheKey(request *http.Request) string {
+	key := ClientAddr(request) + " " + request.Host
+	if user := ClientUser(request); user != "" {
+		key += " user:" + user
+	}
+	if group, ok := boundGroup(request); ok {
+		key += " group:" + group
+	}
+	return key
+}
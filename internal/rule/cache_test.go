@@ -0,0 +1,95 @@
+package rule
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Manager_Cache(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	bl, err := LoadBlacklistFromArray([]string{"example.com"})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	err = rm.Update(&RuleConfig{DomainBlacklistConfig: bl})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	request := httptest.NewRequest("GET", "http://example.com", nil)
+	if rm.Allow(request) {
+		t.Fatalf("got allow wanted deny for %v", request.Host)
+	}
+	if rm.Allow(request) {
+		t.Fatalf("got allow wanted deny for cached %v", request.Host)
+	}
+
+	stats := rm.CacheStats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("got %+v wanted 1 hit, 1 miss and 1 entry", stats)
+	}
+
+	// another client doesn't get the first client's decision
+	other := httptest.NewRequest("GET", "http://example.com", nil)
+	other.RemoteAddr = "192.0.2.2"
+	if rm.Allow(other) {
+		t.Fatalf("got allow wanted deny for %v", other.Host)
+	}
+	stats = rm.CacheStats()
+	if stats.Misses != 2 || stats.Entries != 2 {
+		t.Fatalf("got %+v wanted 2 misses and 2 entries", stats)
+	}
+
+	// the cached deny must not survive the rules changing
+	bl, err = LoadBlacklistFromArray([]string{"example2.com"})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	err = rm.Update(&RuleConfig{DomainBlacklistConfig: bl})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	if !rm.Allow(request) {
+		t.Fatalf("got deny wanted allow after update for %v", request.Host)
+	}
+}
+
+func Test_DecisionCache_TTL(t *testing.T) {
+	dc, err := newDecisionCache(CacheConfig{Size: 10, TTL: time.Minute})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	now := time.Now()
+	dc.add("key", &Decision{Allow: true}, now, time.Time{})
+
+	if _, ok := dc.get("key", now.Add(time.Second)); !ok {
+		t.Fatalf("got miss wanted hit before the ttl")
+	}
+	if _, ok := dc.get("key", now.Add(time.Minute)); ok {
+		t.Fatalf("got hit wanted miss after the ttl")
+	}
+	if stats := dc.stats(); stats.Expired != 1 || stats.Entries != 0 {
+		t.Fatalf("got %+v wanted 1 expired and no entries", stats)
+	}
+}
+
+func Test_DecisionCache_Invalid(t *testing.T) {
+	tests := []CacheConfig{
+		{Size: 0},
+		{Size: -1},
+		{Size: 10, TTL: -time.Second},
+	}
+
+	for _, config := range tests {
+		if _, err := newDecisionCache(config); err == nil {
+			t.Fatalf("got nil wanted error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package rule
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CategoryConfig is a named list of domains that are allowed or denied,
+// optionally only for some groups of clients and only while a schedule is
+// active
+type CategoryConfig struct {
+	Name    string   `json:"name" yaml:"name"`
+	Action  string   `json:"action" yaml:"action"`
+	Domains []string `json:"domains" yaml:"domains"`
+	// Groups limits the category to these client groups, all clients if
+	// empty
+	Groups []string `json:"groups,omitempty" yaml:"groups"`
+	// Schedule limits the category to when the named schedule is active,
+	// always if empty
+	Schedule string `json:"schedule,omitempty" yaml:"schedule"`
+}
+
+func (cc *CategoryConfig) String() string {
+	return fmt.Sprintf("%s %s: %s", cc.Action, cc.Name,
+		strings.Join(cc.Domains, ", "))
+}
+
+// ParseAction converts the name of an action into its result
+func ParseAction(action string) (permitted, error) {
+	switch strings.ToLower(action) {
+	case "allow":
+		return allow, nil
+	case "deny":
+		return deny, nil
+	}
+	return 0, fmt.Errorf("invalid action %q, expected allow or deny", action)
+}
+
+// Validate checks everything that doesn't depend on other parts of the
+// configuration
+func (cc *CategoryConfig) Validate() error {
+	if cc.Name == "" {
+		return fmt.Errorf("category must have a name")
+	}
+	if _, err := ParseAction(cc.Action); err != nil {
+		return err
+	}
+	for _, d := range cc.Domains {
+		if ValidateDomain(d) != nil {
+			return fmt.Errorf("invalid host in category %s: %q", cc.Name, d)
+		}
+	}
+	return nil
+}
+
+type category struct {
+	conf     *CategoryConfig
+	action   permitted
+	re       *regexp.Regexp
+	groups   map[string]bool
+	schedule *schedule
+}
+
+func (c *category) String() string {
+	return c.conf.String()
+}
+
+func (c *category) allow(q *query) permitted {
+	if len(c.groups) > 0 && !c.groups[q.group] {
+		return pass
+	}
+	if c.schedule != nil && !c.schedule.active(q.now) {
+		return pass
+	}
+	if c.re != nil && c.re.MatchString(q.request.Host) {
+		return c.action
+	}
+	return pass
+}
+
+func newCategory(
+	config *CategoryConfig,
+	groups map[string]*clientGroup,
+	schedules map[string]*schedule,
+) (*category, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &category{conf: config}
+	c.action, _ = ParseAction(config.Action)
+
+	if len(config.Groups) > 0 {
+		c.groups = make(map[string]bool)
+		for _, g := range config.Groups {
+			if _, ok := groups[g]; !ok {
+				return nil, fmt.Errorf("unknown group %q", g)
+			}
+			c.groups[g] = true
+		}
+	}
+
+	if config.Schedule != "" {
+		var ok bool
+		c.schedule, ok = schedules[config.Schedule]
+		if !ok {
+			return nil, fmt.Errorf("unknown schedule %q", config.Schedule)
+		}
+	}
+
+	c.re, err = compileDomains(config.Domains)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// compileDomains builds a matcher for any of domains or their subdomains, it
+// returns nil if there are no domains since an empty pattern would match
+// everything. Domains are normalized, so they match however they were
+// written.
+func compileDomains(domains []string) (*regexp.Regexp, error) {
+	if len(domains) == 0 {
+		return nil, nil
+	}
+
+	quoted := make([]string, len(domains))
+	for i, domain := range domains {
+		d, err := NormalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		quoted[i] = `(.*\.|)` + regexp.QuoteMeta(d)
+	}
+	return regexp.Compile("(" + strings.Join(quoted, "|") + ")")
+}
@@ -0,0 +1,103 @@
+package rule
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Category(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Groups: []*GroupConfig{
+			{Name: "kids", Clients: []string{"192.168.1.0/24"}},
+		},
+		Categories: []*CategoryConfig{
+			{
+				Name:    "games",
+				Action:  "deny",
+				Domains: []string{"example.com"},
+				Groups:  []string{"kids"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	tests := map[string]bool{
+		"192.168.1.10:1234": false,
+		"192.168.2.10:1234": true,
+		"":                  true,
+	}
+
+	for client, permit := range tests {
+		request := httptest.NewRequest("GET", "http://www.example.com", nil)
+		request.RemoteAddr = client
+		if rm.Allow(request) != permit {
+			t.Fatalf("got %v wanted %v for %q", !permit, permit, client)
+		}
+	}
+
+	// a request bound to a group is decided for it whatever its client,
+	// and the decision isn't mixed up with the client's own in the cache
+	request := httptest.NewRequest("GET", "http://www.example.com", nil)
+	request.RemoteAddr = "192.168.2.10:1234"
+	bound := WithGroup(request, "kids")
+	if decision := rm.Decide(bound); decision.Allow || decision.Group != "kids" {
+		t.Fatalf("got %+v wanted a denial for kids", decision)
+	}
+	if !rm.Allow(request) {
+		t.Fatalf("got deny wanted allow for the unbound request")
+	}
+	if !rm.Allow(WithGroup(request, "")) {
+		t.Fatalf("got deny wanted allow for a request bound to no group")
+	}
+}
+
+func Test_Category_Invalid(t *testing.T) {
+	tests := []*RuleConfig{
+		{Categories: []*CategoryConfig{
+			{Name: "a", Action: "maybe", Domains: []string{"example.com"}},
+		}},
+		{Categories: []*CategoryConfig{
+			{Name: "a", Action: "deny", Groups: []string{"kids"}},
+		}},
+		{Categories: []*CategoryConfig{
+			{Name: "a", Action: "deny", Schedule: "bedtime"},
+		}},
+		{Categories: []*CategoryConfig{
+			{Name: "a", Action: "deny", Domains: []string{"not a domain"}},
+		}},
+	}
+
+	for _, rc := range tests {
+		if _, err := compile(rc); err == nil {
+			t.Fatalf("got nil wanted error for %+v", rc.Categories[0])
+		}
+	}
+}
+
+func Test_Category_Normalized(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Categories: []*CategoryConfig{
+			{Name: "games", Action: "deny", Domains: []string{"Example.COM."}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	request := httptest.NewRequest("GET", "http://www.example.com", nil)
+	if rm.Allow(request) {
+		t.Fatalf("got allow wanted deny for %v", request.Host)
+	}
+}
@@ -0,0 +1,581 @@
+package rule
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ContentConfig blocks responses by what they contain. It is applied by the
+// RESPMOD service, after the request was allowed.
+type ContentConfig struct {
+	// Types are MIME types to block, a type like video/* blocks every
+	// subtype
+	Types []string `json:"types,omitempty" yaml:"types"`
+	// MaxSize blocks responses larger than this many bytes, 0 allows any
+	// size
+	MaxSize int64 `json:"max_size,omitempty" yaml:"max_size"`
+	// Keywords block HTML pages whose text contains any of them as whole
+	// words, tags, scripts and styles aside. Clients are made to ask for
+	// pages compressed with gzip or deflate, which are decoded before they
+	// are scanned. Pages that servers compress any other way regardless
+	// are passed on unscanned.
+	Keywords []string `json:"keywords,omitempty" yaml:"keywords"`
+	// Terms are weighted keywords and phrases, a page is blocked once the
+	// weights of the terms it contains add up to the threshold. Like
+	// keywords they are whole words and ignore case, HTML entities, markup and
+	// runs of whitespace.
+	Terms []*TermConfig `json:"terms,omitempty" yaml:"terms"`
+	// Threshold is the score that blocks a page, 0 means the terms never
+	// block on their own
+	Threshold int `json:"threshold,omitempty" yaml:"threshold"`
+	// Thresholds replace Threshold for the clients of some groups
+	Thresholds map[string]int `json:"thresholds,omitempty" yaml:"thresholds"`
+	// Groups limits the content rules to these client groups, all clients
+	// if empty
+	Groups []string `json:"groups,omitempty" yaml:"groups"`
+}
+
+// TermConfig is a weighted keyword or phrase, a negative weight makes a
+// page less likely to be blocked
+type TermConfig struct {
+	Term   string `json:"term" yaml:"term"`
+	Weight int    `json:"weight" yaml:"weight"`
+}
+
+func (cc *ContentConfig) String() string {
+	terms := make([]string, 0, len(cc.Terms))
+	for _, t := range cc.Terms {
+		if t != nil {
+			terms = append(terms, fmt.Sprintf("%s=%d", t.Term, t.Weight))
+		}
+	}
+	return fmt.Sprintf(
+		"types: %s, max size: %d, keywords: %s, terms: %s, threshold: %d",
+		strings.Join(cc.Types, ", "), cc.MaxSize,
+		strings.Join(cc.Keywords, ", "), strings.Join(terms, ", "),
+		cc.Threshold)
+}
+
+// Validate checks everything that doesn't depend on other parts of the
+// configuration
+func (cc *ContentConfig) Validate() error {
+	for _, t := range cc.Types {
+		if _, err := parseTypePattern(t); err != nil {
+			return err
+		}
+	}
+	if cc.MaxSize < 0 {
+		return fmt.Errorf("max size cannot be negative")
+	}
+	seen := make(map[string]bool)
+	for _, k := range cc.Keywords {
+		term := normalizeText(k)
+		if term == "" {
+			return fmt.Errorf("keywords cannot be empty")
+		}
+		if seen[term] {
+			return fmt.Errorf("duplicate term %q", k)
+		}
+		seen[term] = true
+	}
+	for _, t := range cc.Terms {
+		if t == nil {
+			return fmt.Errorf("empty term")
+		}
+		term := normalizeText(t.Term)
+		if term == "" {
+			return fmt.Errorf("terms cannot be empty")
+		}
+		if seen[term] {
+			return fmt.Errorf("duplicate term %q", t.Term)
+		}
+		seen[term] = true
+		if t.Weight == 0 {
+			return fmt.Errorf("term %q needs a weight", t.Term)
+		}
+	}
+	if cc.Threshold < 0 {
+		return fmt.Errorf("threshold cannot be negative")
+	}
+	for g, threshold := range cc.Thresholds {
+		if threshold < 0 {
+			return fmt.Errorf("threshold of %s cannot be negative", g)
+		}
+	}
+	return nil
+}
+
+// IsEmpty reports whether cc has no rules at all
+func (cc *ContentConfig) IsEmpty() bool {
+	return len(cc.Types) == 0 && cc.MaxSize == 0 && len(cc.Keywords) == 0 &&
+		len(cc.Terms) == 0
+}
+
+// normalizeText prepares text for matching terms: entities are decoded,
+// letters lowercased and runs of whitespace made into a single space
+func normalizeText(text string) string {
+	text = strings.ToLower(html.UnescapeString(text))
+	var b strings.Builder
+	b.Grow(len(text))
+	space := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// stripMarkup returns the text of an HTML page: comments, tags and the
+// contents of script and style elements are replaced by a space, so that
+// terms are neither found in the markup nor kept apart by it
+func stripMarkup(page string) string {
+	var b strings.Builder
+	b.Grow(len(page))
+	for {
+		i := strings.IndexByte(page, '<')
+		if i < 0 || i+1 == len(page) {
+			b.WriteString(page)
+			return b.String()
+		}
+		b.WriteString(page[:i])
+		page = page[i:]
+
+		c := page[1]
+		switch {
+		case strings.HasPrefix(page, "<!--"):
+			page = skipPast(page[4:], "-->")
+		case c == '!' || c == '?' || c == '/' || isASCIILetter(c):
+			name := tagName(page)
+			page = skipTag(page)
+			if name == "script" || name == "style" {
+				// their contents aren't text, they end at the first
+				// closing tag
+				for {
+					j := strings.Index(page, "</")
+					if j < 0 {
+						page = ""
+						break
+					}
+					page = page[j:]
+					if tagName(page) == "/"+name {
+						page = skipTag(page)
+						break
+					}
+					page = page[2:]
+				}
+			}
+		default:
+			// a lone < is text
+			b.WriteByte('<')
+			page = page[1:]
+			continue
+		}
+		b.WriteByte(' ')
+	}
+}
+
+// tagName returns the lowercased name of the tag page starts with, with
+// the / of a closing tag
+func tagName(page string) string {
+	end := 1
+	if end < len(page) && page[end] == '/' {
+		end++
+	}
+	for end < len(page) && (isASCIILetter(page[end]) ||
+		(page[end] >= '0' && page[end] <= '9')) {
+		end++
+	}
+	return strings.ToLower(page[1:end])
+}
+
+// skipTag returns what follows the tag page starts with, the > of quoted
+// attribute values doesn't end it
+func skipTag(page string) string {
+	var quote byte
+	for i := 1; i < len(page); i++ {
+		switch c := page[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return page[i+1:]
+		}
+	}
+	return ""
+}
+
+// skipPast returns what follows the first end in text, nothing if there
+// is none
+func skipPast(text, end string) string {
+	if i := strings.Index(text, end); i >= 0 {
+		return text[i+len(end):]
+	}
+	return ""
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isWordRune reports whether r is part of a word, a term only matches
+// where it isn't preceded or followed by one
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// onWordBoundaries reports whether term, found in text ending at end,
+// isn't part of a longer word. An edge of term that isn't a word rune, like
+// the + of c++, needs no boundary.
+func onWordBoundaries(text, term string, end int) bool {
+	start := end - len(term)
+	first, _ := utf8.DecodeRuneInString(term)
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 &&
+		isWordRune(first) && isWordRune(before) {
+		return false
+	}
+	last, _ := utf8.DecodeLastRuneInString(term)
+	if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) &&
+		isWordRune(last) && isWordRune(after) {
+		return false
+	}
+	return true
+}
+
+// parseTypePattern normalizes a MIME type to block, the subtype may be *
+func parseTypePattern(pattern string) (string, error) {
+	t := strings.ToLower(strings.TrimSpace(pattern))
+	parts := strings.Split(t, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[0] == "*" || parts[1] == "" {
+		return "", fmt.Errorf("invalid MIME type %q", pattern)
+	}
+	if parts[1] != "*" {
+		if _, _, err := mime.ParseMediaType(t); err != nil {
+			return "", fmt.Errorf("invalid MIME type %q", pattern)
+		}
+	}
+	return t, nil
+}
+
+type contentRule struct {
+	conf  *ContentConfig
+	types map[string]bool
+	// wildcards are the main types of patterns like video/*
+	wildcards map[string]bool
+	// terms are the keywords followed by the weighted terms, normalized
+	terms    []string
+	weights  []int
+	keywords int
+	matcher  *matcher
+	groups   map[string]bool
+}
+
+func newContentRule(
+	cc *ContentConfig,
+	groups map[string]*clientGroup,
+) (*contentRule, error) {
+	if err := cc.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &contentRule{
+		conf:      cc,
+		types:     make(map[string]bool),
+		wildcards: make(map[string]bool),
+		groups:    make(map[string]bool),
+	}
+	for _, pattern := range cc.Types {
+		t, _ := parseTypePattern(pattern)
+		if strings.HasSuffix(t, "/*") {
+			c.wildcards[strings.TrimSuffix(t, "/*")] = true
+		} else {
+			c.types[t] = true
+		}
+	}
+	for _, k := range cc.Keywords {
+		c.terms = append(c.terms, normalizeText(k))
+		c.weights = append(c.weights, 0)
+	}
+	c.keywords = len(c.terms)
+	for _, t := range cc.Terms {
+		c.terms = append(c.terms, normalizeText(t.Term))
+		c.weights = append(c.weights, t.Weight)
+	}
+	c.matcher = newMatcher(c.terms)
+
+	for _, g := range cc.Groups {
+		if _, ok := groups[g]; !ok {
+			return nil, fmt.Errorf("unknown group %s", g)
+		}
+		c.groups[g] = true
+	}
+	for g := range cc.Thresholds {
+		if _, ok := groups[g]; !ok {
+			return nil, fmt.Errorf("unknown group %s", g)
+		}
+	}
+	return c, nil
+}
+
+// threshold is the score that blocks a page for clients in group, 0 if the
+// weighted terms don't apply
+func (c *contentRule) threshold(group string) int {
+	if threshold, ok := c.conf.Thresholds[group]; ok {
+		return threshold
+	}
+	return c.conf.Threshold
+}
+
+// scans reports whether pages have to be scanned for clients in group
+func (c *contentRule) scans(group string) bool {
+	return c.keywords > 0 ||
+		(len(c.terms) > c.keywords && c.threshold(group) > 0)
+}
+
+// appliesTo reports whether the content rules apply to clients in group
+func (c *contentRule) appliesTo(group string) bool {
+	return len(c.groups) == 0 || c.groups[group]
+}
+
+// modify makes clients whose pages are scanned ask only for the encodings
+// that DecodeBody can undo, so that compressed pages aren't passed on
+// unscanned. A client that accepts neither gzip nor deflate is left with
+// identity.
+func (c *contentRule) modify(q *query, m *Modification) bool {
+	if !c.appliesTo(q.group) || !c.scans(q.group) {
+		return false
+	}
+	accepted := q.request.Header.Values("Accept-Encoding")
+	if len(accepted) == 0 {
+		return false
+	}
+	var encodings []string
+	for _, value := range accepted {
+		for _, e := range strings.Split(value, ",") {
+			parts := strings.Split(e, ";")
+			name := strings.ToLower(strings.TrimSpace(parts[0]))
+			refused := false
+			for _, param := range parts[1:] {
+				param = strings.ToLower(strings.ReplaceAll(param, " ", ""))
+				refused = refused || strings.TrimRight(param, "0.") == "q="
+			}
+			if decodable(name) && !refused {
+				encodings = append(encodings, name)
+			}
+		}
+	}
+	if len(encodings) == 0 {
+		encodings = []string{"identity"}
+	}
+	m.Add.Del("Accept-Encoding")
+	m.Set.Set("Accept-Encoding", strings.Join(encodings, ", "))
+	return true
+}
+
+func (c *contentRule) blocksType(mediaType string) bool {
+	if c.types[mediaType] {
+		return true
+	}
+	i := strings.Index(mediaType, "/")
+	return i > 0 && c.wildcards[mediaType[:i]]
+}
+
+// ResponseCheck is what the content rules decided from the headers and the
+// preview of a response
+type ResponseCheck struct {
+	*Decision
+	// MediaType is the type of the body, sniffed from the preview if the
+	// response didn't declare it
+	MediaType string
+	// Scan is set if the body has to be passed to CheckBody before the
+	// response can be allowed
+	Scan bool
+	// MaxSize is what the body may not exceed if the response didn't
+	// declare its length, 0 if there is no limit
+	MaxSize int64
+	// Encodings are the content encodings that DecodeBody has to undo
+	// before the body is scanned
+	Encodings []string
+}
+
+// executables are the magic numbers of executables, which
+// http.DetectContentType doesn't know
+var executables = []struct {
+	magic     []byte
+	mediaType string
+}{
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{[]byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+}
+
+// mediaTypeOf returns the declared type of response, or the type sniffed
+// from preview if there is none or it is the generic octet-stream
+func mediaTypeOf(response *http.Response, preview []byte) string {
+	declared, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err == nil && declared != "application/octet-stream" {
+		return strings.ToLower(declared)
+	}
+	if len(preview) == 0 {
+		return "application/octet-stream"
+	}
+	for _, e := range executables {
+		if bytes.HasPrefix(preview, e.magic) {
+			return e.mediaType
+		}
+	}
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(preview))
+	return sniffed
+}
+
+// CheckResponse applies the content rules to the headers and the preview
+// of the response to request. Responses to requests that an override
+// allows are not checked.
+func (rm *Manager) CheckResponse(
+	request *http.Request,
+	response *http.Response,
+	preview []byte,
+) *ResponseCheck {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	q := rm.queryInLock(request, time.Now())
+	result := &ResponseCheck{
+		Decision:  &Decision{Allow: true, Rule: "default", Group: q.group},
+		MediaType: mediaTypeOf(response, preview),
+	}
+
+	if o := rm.overrides.match(q); o != nil && o.action == allow {
+		result.Rule = fmt.Sprintf("override:%d", o.ID)
+		return result
+	}
+	c := rm.content
+	if c == nil || !c.appliesTo(q.group) {
+		return result
+	}
+
+	if c.blocksType(result.MediaType) {
+		result.Decision = &Decision{
+			Rule:   "content:type",
+			Group:  q.group,
+			Reason: result.MediaType,
+		}
+		return result
+	}
+	if c.conf.MaxSize > 0 {
+		if response.ContentLength > c.conf.MaxSize {
+			result.Decision = &Decision{
+				Rule:   "content:size",
+				Group:  q.group,
+				Reason: fmt.Sprintf("%d bytes", response.ContentLength),
+			}
+			return result
+		}
+		if response.ContentLength < 0 {
+			result.MaxSize = c.conf.MaxSize
+		}
+	}
+	result.Scan = c.scans(q.group) &&
+		(result.MediaType == "text/html" ||
+			result.MediaType == "application/xhtml+xml")
+	if result.Scan {
+		// Modify asks for encodings that can be decoded, a server that
+		// ignores that sends a page that can't be scanned
+		result.Encodings = contentEncodings(response)
+		for _, e := range result.Encodings {
+			if !decodable(e) {
+				result.Scan = false
+				result.Encodings = nil
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Match is a term found in a page
+type Match struct {
+	Term string `json:"term"`
+	// Weight is 0 for keywords, which block regardless of the score
+	Weight int `json:"weight,omitempty"`
+	Count  int `json:"count"`
+}
+
+// CheckBody applies the keywords and weighted terms to the body of a
+// response that CheckResponse asked to be scanned. Every occurrence of a
+// term adds its weight to the score of the page.
+func (rm *Manager) CheckBody(request *http.Request, body []byte) *Decision {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	q := rm.queryInLock(request, time.Now())
+	c := rm.content
+	if c == nil || !c.appliesTo(q.group) || !c.scans(q.group) {
+		return &Decision{Allow: true, Rule: "default", Group: q.group}
+	}
+
+	counts := make([]int, len(c.terms))
+	text := normalizeText(stripMarkup(string(body)))
+	c.matcher.each([]byte(text), func(term, end int) {
+		if onWordBoundaries(text, c.terms[term], end) {
+			counts[term]++
+		}
+	})
+
+	var matches []Match
+	var keywords []string
+	score := 0
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		matches = append(matches, Match{
+			Term:   c.terms[i],
+			Weight: c.weights[i],
+			Count:  count,
+		})
+		if i < c.keywords {
+			keywords = append(keywords, c.terms[i])
+		}
+		score += c.weights[i] * count
+	}
+	if len(keywords) > 0 {
+		return &Decision{
+			Rule:    "content:keyword",
+			Group:   q.group,
+			Reason:  strings.Join(keywords, ", "),
+			Matches: matches,
+		}
+	}
+	if threshold := c.threshold(q.group); threshold > 0 && score >= threshold {
+		return &Decision{
+			Rule:    "content:score",
+			Group:   q.group,
+			Reason:  fmt.Sprintf("score %d of %d", score, threshold),
+			Matches: matches,
+		}
+	}
+	return &Decision{
+		Allow:   true,
+		Rule:    "default",
+		Group:   q.group,
+		Matches: matches,
+	}
+}
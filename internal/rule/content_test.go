@@ -0,0 +1,341 @@
+package rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newResponse(contentType string, length int64) *http.Response {
+	response := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		ContentLength: length,
+	}
+	if contentType != "" {
+		response.Header.Set("Content-Type", contentType)
+	}
+	return response
+}
+
+func Test_Manager_CheckResponse(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+		Content: &ContentConfig{
+			Types:    []string{"video/*", "application/x-msdownload"},
+			MaxSize:  1000,
+			Keywords: []string{"Casino"},
+			Groups:   []string{"kids"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	kid := httptest.NewRequest("GET", "http://www.example.com", nil)
+	kid.RemoteAddr = "10.0.0.2:1234"
+	parent := httptest.NewRequest("GET", "http://www.example.com", nil)
+	parent.RemoteAddr = "10.0.0.3:1234"
+
+	tests := []struct {
+		response *http.Response
+		preview  []byte
+		rule     string
+		scan     bool
+		maxSize  int64
+	}{
+		{newResponse("video/mp4", 10), nil, "content:type", false, 0},
+		{newResponse("Video/WebM; codecs=vp9", 10), nil, "content:type", false, 0},
+		{newResponse("", 10), []byte("MZ\x90\x00"), "content:type", false, 0},
+		{newResponse("application/octet-stream", 10), []byte("MZ"), "content:type", false, 0},
+		{newResponse("image/png", 2000), nil, "content:size", false, 0},
+		{newResponse("image/png", -1), nil, "default", false, 1000},
+		{newResponse("text/html; charset=utf-8", 10), nil, "default", true, 0},
+		{newResponse("", 10), []byte("<!DOCTYPE html><html>"), "default", true, 0},
+		{newResponse("text/plain", 10), nil, "default", false, 0},
+	}
+	for i, test := range tests {
+		check := rm.CheckResponse(kid, test.response, test.preview)
+		if check.Rule != test.rule || check.Scan != test.scan ||
+			check.MaxSize != test.maxSize {
+			t.Errorf("%d: got %+v %+v wanted %s scan %v max size %d",
+				i, check, check.Decision, test.rule, test.scan, test.maxSize)
+		}
+		if check.Allow != (test.rule == "default") {
+			t.Errorf("%d: got allow %v for %s", i, check.Allow, check.Rule)
+		}
+
+		// the rules only apply to kids
+		check = rm.CheckResponse(parent, test.response, test.preview)
+		if !check.Allow || check.Scan || check.MaxSize != 0 {
+			t.Errorf("%d: got %+v wanted allow for a parent", i, check.Decision)
+		}
+	}
+
+	// compressed pages are scanned once decoded, those that can't be are
+	// passed on unscanned
+	html := newResponse("text/html", 10)
+	html.Header.Set("Content-Encoding", "gzip")
+	if check := rm.CheckResponse(kid, html, nil); !check.Allow || !check.Scan ||
+		!reflect.DeepEqual(check.Encodings, []string{"gzip"}) {
+		t.Fatalf("got %+v wanted a gzip body to be scanned", check)
+	}
+	html.Header.Set("Content-Encoding", "br")
+	if check := rm.CheckResponse(kid, html, nil); !check.Allow || check.Scan ||
+		check.Encodings != nil {
+		t.Fatalf("got %+v wanted a br page to be passed on", check)
+	}
+
+	_, err = rm.AddOverride(Override{
+		Domain:  "example.com",
+		Action:  "allow",
+		Group:   "kids",
+		Expires: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	check := rm.CheckResponse(kid, newResponse("video/mp4", 10), nil)
+	if !check.Allow || check.Rule != "override:1" {
+		t.Fatalf("got %+v wanted allow by override:1", check.Decision)
+	}
+}
+
+func Test_Manager_CheckBody(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Content: &ContentConfig{Keywords: []string{"Casino", "poker "}},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	request := httptest.NewRequest("GET", "http://www.example.com", nil)
+	decision := rm.CheckBody(request, []byte("<p>Online CASINO and Poker nights</p>"))
+	if decision.Allow || decision.Rule != "content:keyword" ||
+		decision.Reason != "casino, poker" {
+		t.Fatalf("got %+v wanted deny by content:keyword", decision)
+	}
+	decision = rm.CheckBody(request, []byte("<p>Homework help</p>"))
+	if !decision.Allow {
+		t.Fatalf("got %+v wanted allow", decision)
+	}
+}
+
+func Test_Manager_CheckBody_Markup(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Content: &ContentConfig{
+			Keywords: []string{"ass", "sex", "online casino", "c++"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// terms are looked for in the text of the page, as whole words
+	tests := map[string]string{
+		`<p class="intro">Essex, Sussex and Middlesex</p>`:     "",
+		"<script>var sex = 1</script><style>.ass {}</style>hi": "",
+		"<!-- online casino -->Homework":                       "",
+		"<b>online</b>\n<i>casino</i> tonight":                 "online casino",
+		"<p>Sex education</p>":                                 "sex",
+		"<li>Ass.</li>":                                        "ass",
+		"<p>learn C++ today</p>":                               "c++",
+	}
+	request := httptest.NewRequest("GET", "http://www.example.com", nil)
+	for page, want := range tests {
+		decision := rm.CheckBody(request, []byte(page))
+		if want == "" {
+			if !decision.Allow {
+				t.Errorf("got %+v wanted allow for %q", decision, page)
+			}
+			continue
+		}
+		if decision.Allow || decision.Reason != want {
+			t.Errorf("got %+v wanted deny by %s for %q", decision, want, page)
+		}
+	}
+}
+
+func Test_Manager_CheckBody_Score(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Groups: []*GroupConfig{
+			{Name: "kids", Clients: []string{"10.0.0.2"}},
+			{Name: "teens", Clients: []string{"10.0.0.3"}},
+		},
+		Content: &ContentConfig{
+			Terms: []*TermConfig{
+				{Term: "Online Casino", Weight: 5},
+				{Term: "poker", Weight: 3},
+				{Term: "poker tutorial", Weight: -6},
+			},
+			Threshold:  10,
+			Thresholds: map[string]int{"kids": 5, "teens": 0},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	newRequest := func(client string) *http.Request {
+		request := httptest.NewRequest("GET", "http://www.example.com", nil)
+		request.RemoteAddr = client + ":1234"
+		return request
+	}
+	kid := newRequest("10.0.0.2")
+	teen := newRequest("10.0.0.3")
+	adult := newRequest("10.0.0.4")
+
+	page := []byte("<p>The best online\n&#67;asino!</p><p>Poker, POKER.</p>")
+	decision := rm.CheckBody(kid, page)
+	want := []Match{
+		{Term: "online casino", Weight: 5, Count: 1},
+		{Term: "poker", Weight: 3, Count: 2},
+	}
+	if decision.Allow || decision.Rule != "content:score" ||
+		decision.Reason != "score 11 of 5" ||
+		!reflect.DeepEqual(decision.Matches, want) {
+		t.Fatalf("got %+v wanted deny by content:score", decision)
+	}
+	if decision = rm.CheckBody(adult, page); decision.Allow {
+		t.Fatalf("got %+v wanted deny at the default threshold", decision)
+	}
+
+	// negative weights count against blocking
+	tutorial := append(page, []byte("<h1>Poker tutorial</h1>")...)
+	if decision = rm.CheckBody(adult, tutorial); !decision.Allow ||
+		len(decision.Matches) != 3 {
+		t.Fatalf("got %+v wanted allow with 3 matches", decision)
+	}
+
+	// a threshold of 0 turns the terms off
+	if decision = rm.CheckBody(teen, page); !decision.Allow {
+		t.Fatalf("got %+v wanted allow", decision)
+	}
+	html := newResponse("text/html", 10)
+	if check := rm.CheckResponse(teen, html, nil); check.Scan {
+		t.Fatalf("got scan wanted no scan without a threshold")
+	}
+	if check := rm.CheckResponse(kid, html, nil); !check.Scan {
+		t.Fatalf("got no scan wanted scan")
+	}
+}
+
+func Test_Manager_Modify_Content(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+		Content: &ContentConfig{
+			Keywords: []string{"casino"},
+			Groups:   []string{"kids"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// pages an override allows aren't scanned
+	_, err = rm.AddOverride(Override{
+		Domain:  "other.com",
+		Action:  "allow",
+		Group:   "kids",
+		Expires: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	tests := []struct {
+		url    string
+		client string
+		accept string
+		want   string
+	}{
+		{"http://www.example.com/", "10.0.0.2", "gzip, deflate, br", "gzip, deflate"},
+		{"http://www.example.com/", "10.0.0.2", "br;q=1.0, GZIP;q=0.5", "gzip"},
+		{"http://www.example.com/", "10.0.0.2", "gzip;q=0, deflate", "deflate"},
+		{"http://www.example.com/", "10.0.0.2", "br, zstd", "identity"},
+		{"http://www.example.com/", "10.0.0.2", "", ""},
+		{"http://www.example.com/", "10.0.0.3", "gzip, deflate, br", ""},
+		{"http://other.com/", "10.0.0.2", "br", ""},
+	}
+
+	for i, test := range tests {
+		request := httptest.NewRequest("GET", test.url, nil)
+		request.RemoteAddr = test.client + ":1234"
+		if test.accept != "" {
+			request.Header.Set("Accept-Encoding", test.accept)
+		}
+		m := rm.Modify(request)
+		if test.want == "" {
+			if m != nil {
+				t.Errorf("%d: got %+v wanted nil", i, m)
+			}
+			continue
+		}
+		if m == nil {
+			t.Errorf("%d: got nil wanted %s", i, test.want)
+			continue
+		}
+		if got := m.Set.Get("Accept-Encoding"); got != test.want ||
+			!reflect.DeepEqual(m.Rules, []string{"content"}) {
+			t.Errorf("%d: got %s by %v wanted %s", i, got, m.Rules, test.want)
+		}
+	}
+}
+
+func Test_ContentConfig_Invalid(t *testing.T) {
+	tests := []*ContentConfig{
+		{Types: []string{"video"}},
+		{Types: []string{"*/*"}},
+		{MaxSize: -1},
+		{Keywords: []string{" "}},
+		{Terms: []*TermConfig{{Term: "poker"}}},
+		{Terms: []*TermConfig{{Term: " ", Weight: 1}}},
+		{Terms: []*TermConfig{nil}},
+		{Keywords: []string{"Poker"}, Terms: []*TermConfig{{Term: "poker", Weight: 1}}},
+		{Threshold: -1},
+		{Thresholds: map[string]int{"kids": -1}},
+	}
+	for _, cc := range tests {
+		if err := cc.Validate(); err == nil {
+			t.Errorf("got nil wanted an error for %+v", cc)
+		}
+	}
+
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	err = rm.Update(&RuleConfig{
+		Content: &ContentConfig{Keywords: []string{"casino"}, Groups: []string{"kids"}},
+	})
+	if err == nil {
+		t.Fatalf("got nil wanted an error for an unknown group")
+	}
+}
@@ -4,26 +4,19 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"regexp"
 	"sort"
 	"strings"
 
-	valid "github.com/asaskevich/govalidator"
-	lru "github.com/hashicorp/golang-lru"
 	"github.com/rs/zerolog/log"
 )
 
 type DomainBlacklistConfig struct {
-	Blacklist []string `json:blacklist`
+	Blacklist []string `json:"blacklist"`
 }
 
 func (dbc *DomainBlacklistConfig) String() string {
-	var b strings.Builder
-	for _, v := range dbc.Blacklist {
-		fmt.Fprintf(&b, "%s, ", v)
-	}
-	return b.String()[:b.Len()-2]
+	return strings.Join(dbc.Blacklist, ", ")
 }
 
 func LoadBlacklist(path string) (*DomainBlacklistConfig, error) {
@@ -39,8 +32,8 @@ func LoadBlacklist(path string) (*DomainBlacklistConfig, error) {
 		if len(entry) == 0 || entry[0] == '#' {
 			continue
 		}
-		strEntry := strings.TrimSpace(string(entry))
-		if !valid.IsDNSName(strEntry) {
+		strEntry, err := NormalizeDomain(string(entry))
+		if err != nil {
 			return nil, fmt.Errorf(
 				"invalid host in blacklist %v",
 				entry)
@@ -61,37 +54,22 @@ func LoadBlacklistFromArray(array []string) (*DomainBlacklistConfig, error) {
 }
 
 type DomainBlacklist struct {
-	conf  *DomainBlacklistConfig
-	re    *regexp.Regexp
-	cache *lru.TwoQueueCache
+	conf *DomainBlacklistConfig
+	re   *regexp.Regexp
 }
 
 func (db *DomainBlacklist) String() string {
 	return db.conf.String()
 }
 
-func (db *DomainBlacklist) allow(request *http.Request) (permitted, bool) {
-	status, ok := db.cache.Get(request.Host)
-	if ok {
-		result, ok := status.(permitted)
-		if ok {
-			return result, ok
-		}
-		// If !ok let's log the error and continue... effectively it
-		// just means a disabled cache
-		log.Error().Str("type", fmt.Sprintf("%T", result)).
-			Msg("result was not of type permitted")
-	}
-
-	if db.re.MatchString(request.Host) {
-		db.cache.Add(request.Host, deny)
-		return deny, false
+func (db *DomainBlacklist) allow(q *query) permitted {
+	if db.re != nil && db.re.MatchString(q.request.Host) {
+		return deny
 	}
 
 	// If we don't have a rule for this domain we cannot presume it's
 	// permissibility
-	db.cache.Add(request.Host, pass)
-	return pass, false
+	return pass
 }
 
 //NewDomainBlacklist creates a DomainBlacklist or fails if the regular
@@ -102,32 +80,13 @@ func NewDomainBlacklist(
 	db := &DomainBlacklist{
 		conf: config,
 	}
-	prefix := `(.*\.|)`
-	pattern := "("
-	for i := 0; i < len(config.Blacklist); i += 1 {
-		domain := strings.Replace(
-			config.Blacklist[i],
-			".",
-			"\\.",
-			len(config.Blacklist[i]))
-		if i == 0 {
-			pattern = pattern + prefix + domain
-		} else {
-			pattern = pattern + "|" + prefix + domain
-		}
-	}
-	pattern += ")"
-	log.Debug().Str("pattern", pattern).Msg("blacklist matcher")
-
 	var err error
-	db.re, err = regexp.Compile(pattern)
+	db.re, err = compileDomains(config.Blacklist)
 	if err != nil {
 		return nil, err
 	}
-
-	db.cache, err = lru.New2Q(10000)
-	if err != nil {
-		return nil, err
+	if db.re != nil {
+		log.Debug().Stringer("pattern", db.re).Msg("blacklist matcher")
 	}
 
 	return db, nil
@@ -32,7 +32,9 @@ func Test_Blacklist(t *testing.T) {
 	}
 
 	for host, permit := range tests {
-		result, _ := bl.allow(httptest.NewRequest("GET", host, nil))
+		result := bl.allow(&query{
+			request: httptest.NewRequest("GET", host, nil),
+		})
 		if result != permit {
 			t.Fatalf("got %v, wanted %v for %v", result, permit, host)
 		}
@@ -4,26 +4,19 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"regexp"
 	"sort"
 	"strings"
 
-	valid "github.com/asaskevich/govalidator"
-	lru "github.com/hashicorp/golang-lru"
 	"github.com/rs/zerolog/log"
 )
 
 type DomainWhitelistConfig struct {
-	Whitelist []string `json:whitelist`
+	Whitelist []string `json:"whitelist"`
 }
 
 func (dwc *DomainWhitelistConfig) String() string {
-	var b strings.Builder
-	for _, v := range dwc.Whitelist {
-		fmt.Fprintf(&b, "%s, ", v)
-	}
-	return b.String()[:b.Len()-2]
+	return strings.Join(dwc.Whitelist, ", ")
 }
 
 func LoadWhitelist(path string) (*DomainWhitelistConfig, error) {
@@ -39,8 +32,8 @@ func LoadWhitelist(path string) (*DomainWhitelistConfig, error) {
 		if len(entry) == 0 || entry[0] == '#' {
 			continue
 		}
-		strEntry := strings.TrimSpace(string(entry))
-		if !valid.IsDNSName(strEntry) {
+		strEntry, err := NormalizeDomain(string(entry))
+		if err != nil {
 			return nil, fmt.Errorf(
 				"invalid host in whitelist %v",
 				entry)
@@ -61,37 +54,22 @@ func LoadWhitelistFromArray(array []string) (*DomainWhitelistConfig, error) {
 }
 
 type DomainWhitelist struct {
-	conf  *DomainWhitelistConfig
-	re    *regexp.Regexp
-	cache *lru.TwoQueueCache
+	conf *DomainWhitelistConfig
+	re   *regexp.Regexp
 }
 
 func (dw *DomainWhitelist) String() string {
 	return dw.conf.String()
 }
 
-func (dw *DomainWhitelist) allow(request *http.Request) (permitted, bool) {
-	status, ok := dw.cache.Get(request.Host)
-	if ok {
-		result, ok := status.(permitted)
-		if ok {
-			return result, ok
-		}
-		// If !ok let's log the error and continue... effectively it
-		// just means a disabled cache
-		log.Error().Str("type", fmt.Sprintf("%T", result)).
-			Msg("result was not of type permitted")
-	}
-
-	if dw.re.MatchString(request.Host) {
-		dw.cache.Add(request.Host, allow)
-		return allow, false
+func (dw *DomainWhitelist) allow(q *query) permitted {
+	if dw.re != nil && dw.re.MatchString(q.request.Host) {
+		return allow
 	}
 
 	// If we don't have a rule for this domain we cannot presume it's
 	// permissibility
-	dw.cache.Add(request.Host, pass)
-	return pass, false
+	return pass
 }
 
 //NewDomainWhitelist creates a DomainWhitelist or fails if the regular
@@ -102,32 +80,13 @@ func NewDomainWhitelist(
 	dw := &DomainWhitelist{
 		conf: config,
 	}
-	prefix := `(.*\.|)`
-	pattern := "("
-	for i := 0; i < len(config.Whitelist); i += 1 {
-		domain := strings.Replace(
-			config.Whitelist[i],
-			".",
-			"\\.",
-			len(config.Whitelist[i]))
-		if i == 0 {
-			pattern = pattern + prefix + domain
-		} else {
-			pattern = pattern + "|" + prefix + domain
-		}
-	}
-	pattern += ")"
-	log.Debug().Str("pattern", pattern).Msg("whitelist matcher")
-
 	var err error
-	dw.re, err = regexp.Compile(pattern)
+	dw.re, err = compileDomains(config.Whitelist)
 	if err != nil {
 		return nil, err
 	}
-
-	dw.cache, err = lru.New2Q(10000)
-	if err != nil {
-		return nil, err
+	if dw.re != nil {
+		log.Debug().Stringer("pattern", dw.re).Msg("whitelist matcher")
 	}
 
 	return dw, nil
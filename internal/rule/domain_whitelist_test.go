@@ -31,7 +31,9 @@ func Test_Whitelist(t *testing.T) {
 	}
 
 	for host, permit := range tests {
-		result, _ := wl.allow(httptest.NewRequest("GET", host, nil))
+		result := wl.allow(&query{
+			request: httptest.NewRequest("GET", host, nil),
+		})
 		if result != permit {
 			t.Fatalf("got %v, wanted %v for %v", result, permit, host)
 		}
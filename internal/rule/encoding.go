@@ -0,0 +1,72 @@
+package rule
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// contentEncodings returns the encodings applied to the body of response in
+// the order they were applied, without identity
+func contentEncodings(response *http.Response) []string {
+	var encodings []string
+	for _, value := range response.Header["Content-Encoding"] {
+		for _, e := range strings.Split(value, ",") {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if e != "" && e != "identity" {
+				encodings = append(encodings, e)
+			}
+		}
+	}
+	return encodings
+}
+
+// decodable reports whether DecodeBody can undo encoding
+func decodable(encoding string) bool {
+	switch encoding {
+	case "gzip", "x-gzip", "deflate":
+		return true
+	}
+	return false
+}
+
+// DecodeBody undoes the content encodings of a body that CheckResponse asked
+// to be scanned, so that the keywords are looked for in the text rather than
+// in the compressed bytes. At most limit bytes are decoded. The body may be
+// cut off, in which case what could be decoded of it is returned.
+func DecodeBody(encodings []string, body []byte, limit int64) ([]byte, error) {
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var decoder io.Reader
+		var err error
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			decoder, err = gzip.NewReader(bytes.NewReader(body))
+		case "deflate":
+			// deflate is supposed to be zlib, but some servers send the
+			// raw format
+			decoder, err = zlib.NewReader(bytes.NewReader(body))
+			if errors.Is(err, zlib.ErrHeader) {
+				decoder, err = flate.NewReader(bytes.NewReader(body)), nil
+			}
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %s", encodings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s body: %w", encodings[i], err)
+		}
+
+		decoded, err := ioutil.ReadAll(io.LimitReader(decoder, limit))
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("invalid %s body: %w", encodings[i], err)
+		}
+		body = decoded
+	}
+	return body, nil
+}
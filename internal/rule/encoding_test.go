@@ -0,0 +1,58 @@
+package rule
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+)
+
+func Test_DecodeBody(t *testing.T) {
+	page := []byte("<html><body>the best online casino in town</body></html>")
+	encode := func(w io.WriteCloser, b *bytes.Buffer) []byte {
+		w.Write(page)
+		w.Close()
+		return b.Bytes()
+	}
+	var gzipped, zlibbed, deflated bytes.Buffer
+	gzipBody := encode(gzip.NewWriter(&gzipped), &gzipped)
+	zlibBody := encode(zlib.NewWriter(&zlibbed), &zlibbed)
+	flateWriter, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	flateBody := encode(flateWriter, &deflated)
+
+	tests := []struct {
+		encodings []string
+		body      []byte
+		limit     int64
+		decoded   string
+	}{
+		{nil, page, 1000, string(page)},
+		{[]string{"gzip"}, gzipBody, 1000, string(page)},
+		{[]string{"x-gzip"}, gzipBody, 1000, string(page)},
+		{[]string{"deflate"}, zlibBody, 1000, string(page)},
+		{[]string{"deflate"}, flateBody, 1000, string(page)},
+		// a body cut off by the scan limit decodes as far as it goes
+		{[]string{"gzip"}, gzipBody[:len(gzipBody)-12], 1000, string(page[:20])},
+		{[]string{"gzip"}, gzipBody, 10, string(page[:10])},
+	}
+	for i, test := range tests {
+		decoded, err := DecodeBody(test.encodings, test.body, test.limit)
+		if err != nil {
+			t.Errorf("%d: got %v wanted nil", i, err)
+			continue
+		}
+		if !bytes.HasPrefix(decoded, []byte(test.decoded)) ||
+			len(decoded) > int(test.limit) {
+			t.Errorf("%d: got %q wanted %q", i, decoded, test.decoded)
+		}
+	}
+
+	if _, err := DecodeBody([]string{"br"}, page, 1000); err == nil {
+		t.Fatalf("got nil wanted an error for br")
+	}
+	if _, err := DecodeBody([]string{"gzip"}, page, 1000); err == nil {
+		t.Fatalf("got nil wanted an error for a body that isn't gzip")
+	}
+}
@@ -0,0 +1,45 @@
+package rule
+
+import (
+	"net/http"
+	"time"
+)
+
+// Step is the result of a single rule while explaining a decision
+type Step struct {
+	Rule   string `json:"rule"`
+	Result string `json:"result"`
+}
+
+// Explanation shows how the rules decide a request
+type Explanation struct {
+	URL    string `json:"url"`
+	Client string `json:"client,omitempty"`
+	Group  string `json:"group,omitempty"`
+	// Steps are the rules in the order they were applied, evaluation stops
+	// at the first rule that allows the request
+	Steps    []Step    `json:"steps"`
+	Decision *Decision `json:"decision"`
+}
+
+// Explain applies the rules to request like Decide, but bypasses the cache
+// and records what every rule returned
+func (rm *Manager) Explain(request *http.Request) *Explanation {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	q := rm.queryInLock(request, time.Now())
+	result := &Explanation{
+		URL:   request.URL.String(),
+		Group: q.group,
+		Steps: []Step{},
+	}
+	if q.client != nil {
+		result.Client = q.client.String()
+	}
+
+	result.Decision = rm.decideInLock(q, func(name string, p permitted) {
+		result.Steps = append(result.Steps, Step{Rule: name, Result: p.String()})
+	})
+	return result
+}
@@ -0,0 +1,121 @@
+package rule
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// GroupConfig names a set of clients by address or network, and by the
+// names of the users who authenticate to the proxy. A user is in their
+// group whatever their address.
+type GroupConfig struct {
+	Name    string   `json:"name" yaml:"name"`
+	Clients []string `json:"clients" yaml:"clients"`
+	Users   []string `json:"users,omitempty" yaml:"users"`
+}
+
+// Validate reports whether every client is a valid address or network
+func (gc *GroupConfig) Validate() error {
+	_, err := newClientGroup(gc)
+	return err
+}
+
+// ParseClient parses an address or a CIDR network, a single address is
+// treated as a network containing only itself
+func ParseClient(client string) (*net.IPNet, error) {
+	if strings.Contains(client, "/") {
+		_, network, err := net.ParseCIDR(client)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", client)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", client)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ValidateUser checks that user can be a member of a group
+func ValidateUser(user string) error {
+	if user == "" {
+		return fmt.Errorf("user cannot be empty")
+	}
+	for _, c := range user {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return fmt.Errorf("invalid user %q", user)
+		}
+	}
+	return nil
+}
+
+type clientGroup struct {
+	name     string
+	networks []*net.IPNet
+	// users are lowercased, like the names they are compared to
+	users map[string]bool
+}
+
+func newClientGroup(gc *GroupConfig) (*clientGroup, error) {
+	if gc.Name == "" {
+		return nil, fmt.Errorf("group must have a name")
+	}
+
+	cg := &clientGroup{name: gc.Name}
+	for _, c := range gc.Clients {
+		network, err := ParseClient(c)
+		if err != nil {
+			return nil, err
+		}
+		cg.networks = append(cg.networks, network)
+	}
+	for _, u := range gc.Users {
+		if err := ValidateUser(u); err != nil {
+			return nil, err
+		}
+		if cg.users == nil {
+			cg.users = make(map[string]bool)
+		}
+		cg.users[strings.ToLower(u)] = true
+	}
+	return cg, nil
+}
+
+func (cg *clientGroup) contains(ip net.IP) bool {
+	for _, n := range cg.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUser reports whether user, which is lowercased, is in the group
+func (cg *clientGroup) hasUser(user string) bool {
+	return cg.users[user]
+}
+
+// groupKey is the context key of the group a request is bound to
+type groupKey struct{}
+
+// WithGroup returns a copy of request that the rules treat as coming from a
+// client of group, whatever the address of the client
+func WithGroup(request *http.Request, group string) *http.Request {
+	return request.WithContext(
+		context.WithValue(request.Context(), groupKey{}, group))
+}
+
+// boundGroup returns the group request was bound to by WithGroup
+func boundGroup(request *http.Request) (string, bool) {
+	group, ok := request.Context().Value(groupKey{}).(string)
+	return group, ok
+}
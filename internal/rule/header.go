@@ -0,0 +1,178 @@
+package rule
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// HeaderConfig is a named rule that adds, sets or removes headers of the
+// allowed requests to some domains, optionally only for some groups of
+// clients. Headers are removed first, then set, then added.
+type HeaderConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// Domains limits the rule to these domains and their subdomains, all
+	// domains if empty
+	Domains []string `json:"domains,omitempty" yaml:"domains"`
+	// Groups limits the rule to these client groups, all clients if empty
+	Groups []string `json:"groups,omitempty" yaml:"groups"`
+	// Add adds a value to the headers, keeping the values they had
+	Add map[string]string `json:"add,omitempty" yaml:"add"`
+	// Set replaces the values of the headers
+	Set map[string]string `json:"set,omitempty" yaml:"set"`
+	// Remove removes the headers
+	Remove []string `json:"remove,omitempty" yaml:"remove"`
+}
+
+func (hc *HeaderConfig) String() string {
+	var changes []string
+	for _, name := range headerNames(hc.Add) {
+		changes = append(changes, fmt.Sprintf("add %s: %s", name, hc.Add[name]))
+	}
+	for _, name := range headerNames(hc.Set) {
+		changes = append(changes, fmt.Sprintf("set %s: %s", name, hc.Set[name]))
+	}
+	for _, name := range hc.Remove {
+		changes = append(changes, "remove "+name)
+	}
+	return fmt.Sprintf("%s: %s", hc.Name, strings.Join(changes, ", "))
+}
+
+// headerNames returns the names in m in order, so that headers are always
+// changed in the same order
+func headerNames(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// protectedHeaders can't be changed, they describe how the request is
+// sent rather than what it asks for
+var protectedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// ValidateHeader checks that name can be changed by a header rule
+func ValidateHeader(name string) error {
+	if name == "" {
+		return fmt.Errorf("header must have a name")
+	}
+	for _, c := range name {
+		// the token characters of RFC 7230
+		if c > 0x7e || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return fmt.Errorf("invalid header %q", name)
+		}
+	}
+	if protectedHeaders[http.CanonicalHeaderKey(name)] {
+		return fmt.Errorf("header %s cannot be changed", name)
+	}
+	return nil
+}
+
+// ValidateHeaderValue checks that value can be sent as a header value
+func ValidateHeaderValue(value string) error {
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("invalid header value %q", value)
+	}
+	return nil
+}
+
+// Validate checks everything that doesn't depend on other parts of the
+// configuration
+func (hc *HeaderConfig) Validate() error {
+	if hc.Name == "" {
+		return fmt.Errorf("header rule must have a name")
+	}
+	for _, d := range hc.Domains {
+		if ValidateDomain(d) != nil {
+			return fmt.Errorf("invalid host %q", d)
+		}
+	}
+	if len(hc.Add) == 0 && len(hc.Set) == 0 && len(hc.Remove) == 0 {
+		return fmt.Errorf("header rule %s changes nothing", hc.Name)
+	}
+	for _, headers := range []map[string]string{hc.Add, hc.Set} {
+		for name, value := range headers {
+			if err := ValidateHeader(name); err != nil {
+				return err
+			}
+			if err := ValidateHeaderValue(value); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range hc.Remove {
+		if err := ValidateHeader(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type headerRule struct {
+	conf   *HeaderConfig
+	re     *regexp.Regexp
+	groups map[string]bool
+}
+
+func newHeaderRule(
+	config *HeaderConfig,
+	groups map[string]*clientGroup,
+) (*headerRule, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &headerRule{conf: config}
+	if len(config.Groups) > 0 {
+		h.groups = make(map[string]bool)
+		for _, g := range config.Groups {
+			if _, ok := groups[g]; !ok {
+				return nil, fmt.Errorf("unknown group %q", g)
+			}
+			h.groups[g] = true
+		}
+	}
+
+	h.re, err = compileDomains(config.Domains)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// modify adds the changes of the rule to m if it applies to q, it reports
+// whether it applied
+func (h *headerRule) modify(q *query, m *Modification) bool {
+	if len(h.groups) > 0 && !h.groups[q.group] {
+		return false
+	}
+	if h.re != nil && !h.re.MatchString(hostOf(q.request)) {
+		return false
+	}
+
+	// a later rule undoes what earlier rules did to the same header
+	for _, name := range h.conf.Remove {
+		name = http.CanonicalHeaderKey(name)
+		m.Set.Del(name)
+		m.Add.Del(name)
+		m.Remove = append(m.Remove, name)
+	}
+	for _, name := range headerNames(h.conf.Set) {
+		m.Add.Del(name)
+		m.Set.Set(name, h.conf.Set[name])
+	}
+	for _, name := range headerNames(h.conf.Add) {
+		m.Add.Add(name, h.conf.Add[name])
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_Manager_Modify_Headers(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+		Headers: []*HeaderConfig{
+			{
+				Name:    "workspace",
+				Domains: []string{"google.com"},
+				Groups:  []string{"kids"},
+				Set:     map[string]string{"X-GoogApps-Allowed-Domains": "example.com"},
+			},
+			{
+				Name:   "tracking",
+				Remove: []string{"referer", "X-Client-Data"},
+				Add:    map[string]string{"DNT": "1"},
+			},
+			{
+				Name:    "referer",
+				Domains: []string{"example.com"},
+				Set:     map[string]string{"Referer": "http://example.com/"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	newRequest := func(url, client string) *http.Request {
+		request := httptest.NewRequest("GET", url, nil)
+		request.RemoteAddr = client + ":1234"
+		request.Header.Set("Referer", "http://elsewhere.com/")
+		request.Header.Set("X-Client-Data", "abc")
+		request.Header.Set("DNT", "0")
+		return request
+	}
+
+	tests := []struct {
+		url    string
+		client string
+		rules  []string
+		want   http.Header
+	}{
+		{
+			"http://mail.google.com/", "10.0.0.2",
+			[]string{"header:workspace", "header:tracking"},
+			http.Header{
+				"X-Googapps-Allowed-Domains": {"example.com"},
+				"Dnt":                        {"0", "1"},
+			},
+		},
+		{
+			"http://mail.google.com/", "10.0.0.3",
+			[]string{"header:tracking"},
+			http.Header{"Dnt": {"0", "1"}},
+		},
+		{
+			"http://www.example.com:8080/", "10.0.0.3",
+			[]string{"header:tracking", "header:referer"},
+			http.Header{
+				"Dnt":     {"0", "1"},
+				"Referer": {"http://example.com/"},
+			},
+		},
+	}
+	for _, test := range tests {
+		request := newRequest(test.url, test.client)
+		m := rm.Modify(request)
+		if m == nil || !reflect.DeepEqual(m.Rules, test.rules) {
+			t.Errorf("got %+v wanted rules %v for %s", m, test.rules, test.url)
+			continue
+		}
+		m.Apply(request)
+		if !reflect.DeepEqual(request.Header, test.want) {
+			t.Errorf("got %v wanted %v for %s", request.Header, test.want, test.url)
+		}
+	}
+
+	// the tunnel can't be changed
+	connect := httptest.NewRequest("CONNECT", "http://mail.google.com:443", nil)
+	if m := rm.Modify(connect); m != nil {
+		t.Fatalf("got %+v wanted no modification of CONNECT", m)
+	}
+}
+
+func Test_HeaderConfig_Invalid(t *testing.T) {
+	tests := []*HeaderConfig{
+		{Set: map[string]string{"DNT": "1"}},
+		{Name: "empty"},
+		{Name: "a", Domains: []string{"not a domain"}, Remove: []string{"DNT"}},
+		{Name: "a", Set: map[string]string{"Bad Header": "1"}},
+		{Name: "a", Set: map[string]string{"Host": "example.com"}},
+		{Name: "a", Remove: []string{"content-length"}},
+		{Name: "a", Add: map[string]string{"DNT": "1\r\nEvil: yes"}},
+	}
+	for _, hc := range tests {
+		if err := hc.Validate(); err == nil {
+			t.Errorf("got nil wanted an error for %+v", hc)
+		}
+	}
+
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	err = rm.Update(&RuleConfig{Headers: []*HeaderConfig{
+		{Name: "a", Remove: []string{"DNT"}},
+		{Name: "a", Remove: []string{"Referer"}},
+	}})
+	if err == nil {
+		t.Fatalf("got nil wanted an error for a duplicate rule")
+	}
+}
@@ -0,0 +1,398 @@
+package rule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HistorySize is how many versions of the rules are kept
+const HistorySize = 100
+
+// where a change to the rules came from
+const (
+	SourceFile     = "file"
+	SourceAPI      = "api"
+	SourceSit      = "sit"
+	SourceRollback = "rollback"
+)
+
+// ErrUnknownVersion is returned for a version that isn't in the history
+var ErrUnknownVersion = errors.New("unknown rules version")
+
+// Change describes who changed the rules and how
+type Change struct {
+	Author string `json:"author,omitempty"`
+	Source string `json:"source"`
+}
+
+// DiffEntry is a single difference between two versions of the rules
+type DiffEntry struct {
+	// Op is add, remove or change
+	Op string `json:"op"`
+	// Section is blacklist, whitelist, group, schedule, category, header,
+	// redirect, content or safe_search
+	Section string `json:"section"`
+	// Item is the domain or the name of the group, schedule, category,
+	// header rule or redirect, content and safe_search have a single item
+	// named after them
+	Item string `json:"item"`
+}
+
+// Snapshot is a version of the rules and the change that produced it
+type Snapshot struct {
+	Version uint64    `json:"version"`
+	Time    time.Time `json:"time"`
+	Change
+	Diff  []DiffEntry `json:"diff"`
+	Rules *RuleConfig `json:"rules,omitempty"`
+}
+
+// history is guarded by the lock of the Manager holding it
+type history struct {
+	snapshots []*Snapshot
+	// dir is where every snapshot is kept as version.json, so that the
+	// history survives restarts. Nothing is written if it is empty.
+	dir string
+}
+
+func (h *history) add(s *Snapshot) {
+	h.snapshots = append(h.snapshots, s)
+	h.save(s)
+	if len(h.snapshots) > HistorySize {
+		dropped := h.snapshots[:len(h.snapshots)-HistorySize]
+		h.snapshots = append(
+			[]*Snapshot(nil), h.snapshots[len(h.snapshots)-HistorySize:]...)
+		for _, d := range dropped {
+			h.remove(d.Version)
+		}
+	}
+}
+
+// latest returns the newest snapshot, or nil if there is none
+func (h *history) latest() *Snapshot {
+	if len(h.snapshots) == 0 {
+		return nil
+	}
+	return h.snapshots[len(h.snapshots)-1]
+}
+
+func (h *history) path(version uint64) string {
+	return filepath.Join(h.dir, fmt.Sprintf("%d.json", version))
+}
+
+// save writes s to dir, failing to only loses the version on restart so
+// it is logged rather than failing the update
+func (h *history) save(s *Snapshot) {
+	if h.dir == "" {
+		return
+	}
+	err := func() error {
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		// a snapshot is either there completely or not at all
+		tmp := h.path(s.Version) + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+			return err
+		}
+		return os.Rename(tmp, h.path(s.Version))
+	}()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Uint64("version", s.Version).
+			Msg("could not save rules version")
+	}
+}
+
+func (h *history) remove(version uint64) {
+	if h.dir == "" {
+		return
+	}
+	err := os.Remove(h.path(version))
+	if err != nil && !os.IsNotExist(err) {
+		log.Error().
+			Err(err).
+			Uint64("version", version).
+			Msg("could not remove old rules version")
+	}
+}
+
+// load reads the snapshots saved in dir, the newest HistorySize are kept
+// and the rest removed
+func (h *history) load(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	h.dir = dir
+	h.snapshots = nil
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		version, err := strconv.ParseUint(
+			strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		s := &Snapshot{}
+		if err := json.Unmarshal(data, s); err != nil || s.Version != version {
+			log.Warn().
+				Err(err).
+				Str("file", name).
+				Msg("ignoring invalid rules version")
+			continue
+		}
+		h.snapshots = append(h.snapshots, s)
+	}
+	sort.Slice(h.snapshots, func(i, j int) bool {
+		return h.snapshots[i].Version < h.snapshots[j].Version
+	})
+
+	if len(h.snapshots) > HistorySize {
+		for _, d := range h.snapshots[:len(h.snapshots)-HistorySize] {
+			h.remove(d.Version)
+		}
+		h.snapshots = h.snapshots[len(h.snapshots)-HistorySize:]
+	}
+	return nil
+}
+
+// ConfigureHistory keeps the history in dir, which is created if needed.
+// The versions saved there by earlier runs are loaded and the next change
+// continues from the newest of them. It has to be called before the rules
+// are first set.
+func (rm *Manager) ConfigureHistory(dir string) error {
+	rm.updateLock.Lock()
+	defer rm.updateLock.Unlock()
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	if rm.conf != nil {
+		return fmt.Errorf("the history has to be configured before the rules")
+	}
+	if err := rm.history.load(dir); err != nil {
+		return err
+	}
+	if latest := rm.history.latest(); latest != nil {
+		rm.generation = latest.Version
+	}
+	return nil
+}
+
+func (h *history) find(version uint64) *Snapshot {
+	for _, s := range h.snapshots {
+		if s.Version == version {
+			return s
+		}
+	}
+	return nil
+}
+
+// History returns up to limit versions of the rules, newest first and
+// without the rules themselves. A limit of zero or less returns them all.
+func (rm *Manager) History(limit int) []Snapshot {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	result := []Snapshot{}
+	for i := len(rm.history.snapshots) - 1; i >= 0; i-- {
+		s := *rm.history.snapshots[i]
+		s.Rules = nil
+		result = append(result, s)
+		if limit > 0 && len(result) == limit {
+			break
+		}
+	}
+	return result
+}
+
+// Version returns the snapshot of version, including its rules
+func (rm *Manager) Version(version uint64) (*Snapshot, error) {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	s := rm.history.find(version)
+	if s == nil {
+		return nil, ErrUnknownVersion
+	}
+	result := *s
+	return &result, nil
+}
+
+// Rollback restores the rules of version as a new version, as long as
+// ifVersion is the current version or AnyVersion. Rolling back to rules
+// identical to the current ones doesn't create a version.
+func (rm *Manager) Rollback(
+	version, ifVersion uint64,
+	change Change,
+) (*Snapshot, error) {
+	rm.updateLock.Lock()
+	defer rm.updateLock.Unlock()
+
+	if err := rm.checkVersion(ifVersion); err != nil {
+		return nil, err
+	}
+
+	target, err := rm.Version(version)
+	if err != nil {
+		return nil, err
+	}
+	restored := *target.Rules
+	rs, err := compile(&restored)
+	if err != nil {
+		return nil, err
+	}
+
+	current, _ := rm.Rules()
+	if len(diff(current, &restored)) > 0 {
+		rm.update(rs, &restored, change)
+	}
+
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	latest := rm.history.find(rm.generation)
+	if latest == nil {
+		return nil, ErrUnknownVersion
+	}
+	result := *latest
+	result.Rules = nil
+	return &result, nil
+}
+
+// diff lists what changed from old to new
+func diff(old, new *RuleConfig) []DiffEntry {
+	result := []DiffEntry{}
+
+	for _, name := range Lists {
+		before, _ := old.List(name)
+		after, _ := new.List(name)
+		result = append(result, diffDomains(name, before, after)...)
+	}
+
+	named := []struct {
+		section       string
+		before, after interface{}
+	}{
+		{"group", old.Groups, new.Groups},
+		{"schedule", old.Schedules, new.Schedules},
+		{"category", old.Categories, new.Categories},
+		{"header", old.Headers, new.Headers},
+		{"redirect", old.Redirects, new.Redirects},
+	}
+	for _, n := range named {
+		result = append(result,
+			diffNamed(n.section, byName(n.before), byName(n.after))...)
+	}
+
+	// sections that aren't lists have a single item named after them
+	result = append(result, diffNamed("content",
+		single("content", old.Content, old.Content != nil),
+		single("content", new.Content, new.Content != nil))...)
+	result = append(result, diffNamed("safe_search",
+		single("safe_search", old.SafeSearch, old.SafeSearch != nil),
+		single("safe_search", new.SafeSearch, new.SafeSearch != nil))...)
+
+	return result
+}
+
+func diffDomains(section string, before, after []string) []DiffEntry {
+	result := []DiffEntry{}
+	in := func(domains []string) map[string]bool {
+		m := make(map[string]bool, len(domains))
+		for _, d := range domains {
+			m[d] = true
+		}
+		return m
+	}
+	b, a := in(before), in(after)
+
+	for _, d := range after {
+		if !b[d] {
+			result = append(result, DiffEntry{"add", section, d})
+		}
+	}
+	for _, d := range before {
+		if !a[d] {
+			result = append(result, DiffEntry{"remove", section, d})
+		}
+	}
+	return result
+}
+
+// diffNamed lists the items of section that were added, removed or changed
+// from before to after, both map the names of the items to the items
+func diffNamed(section string, before, after map[string]interface{}) []DiffEntry {
+	var names []string
+	for name := range before {
+		names = append(names, name)
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := []DiffEntry{}
+	for _, name := range names {
+		b, inBefore := before[name]
+		a, inAfter := after[name]
+		switch {
+		case !inBefore:
+			result = append(result, DiffEntry{"add", section, name})
+		case !inAfter:
+			result = append(result, DiffEntry{"remove", section, name})
+		case !reflect.DeepEqual(a, b):
+			result = append(result, DiffEntry{"change", section, name})
+		}
+	}
+	return result
+}
+
+// byName maps the items of a section like Groups, a slice of pointers to
+// structs with a Name, by their names
+func byName(items interface{}) map[string]interface{} {
+	m := make(map[string]interface{})
+	v := reflect.ValueOf(items)
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i)
+		if item.IsNil() {
+			continue
+		}
+		m[item.Elem().FieldByName("Name").String()] = item.Interface()
+	}
+	return m
+}
+
+// single maps a section that isn't a list as an item called name, if it is
+// set
+func single(name string, section interface{}, set bool) map[string]interface{} {
+	m := make(map[string]interface{})
+	if set {
+		m[name] = section
+	}
+	return m
+}
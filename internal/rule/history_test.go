@@ -0,0 +1,156 @@
+package rule
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func Test_Manager_History(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		DomainBlacklistConfig: &DomainBlacklistConfig{
+			Blacklist: []string{"example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	_, err = rm.EditList("blacklist", ListEdit{
+		Add:    []string{"twitter.com"},
+		Remove: []string{"example.com"},
+	}, AnyVersion, Change{Author: "parent", Source: SourceSit})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	_, err = rm.UpdateIf(&RuleConfig{
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+	}, AnyVersion, Change{Author: "parent", Source: SourceAPI})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	history := rm.History(0)
+	if len(history) != 3 {
+		t.Fatalf("got %d versions wanted 3", len(history))
+	}
+	wanted := []DiffEntry{
+		{"add", "blacklist", "twitter.com"},
+		{"remove", "blacklist", "example.com"},
+	}
+	if history[1].Version != 2 || history[1].Author != "parent" ||
+		history[1].Source != SourceSit ||
+		!reflect.DeepEqual(history[1].Diff, wanted) {
+		t.Fatalf("got %+v wanted version 2 by parent through sit", history[1])
+	}
+	if history[0].Rules != nil {
+		t.Fatalf("got rules wanted none in the history listing")
+	}
+
+	// rolling back to the first version restores the list and drops the
+	// group, since the whole configuration is restored
+	snapshot, err := rm.Rollback(1, 3, Change{Source: SourceRollback})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	wanted = []DiffEntry{
+		{"add", "blacklist", "example.com"},
+		{"remove", "blacklist", "twitter.com"},
+		{"remove", "group", "kids"},
+	}
+	if snapshot.Version != 4 || !reflect.DeepEqual(snapshot.Diff, wanted) {
+		t.Fatalf("got %+v wanted version 4 with %v", snapshot, wanted)
+	}
+
+	_, err = rm.Rollback(2, 3, Change{Source: SourceRollback})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("got %v wanted %v", err, ErrVersionMismatch)
+	}
+	_, err = rm.Rollback(42, AnyVersion, Change{Source: SourceRollback})
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("got %v wanted %v", err, ErrUnknownVersion)
+	}
+}
+
+func Test_Manager_History_Saved(t *testing.T) {
+	dir := t.TempDir()
+	rc := &RuleConfig{
+		DomainBlacklistConfig: &DomainBlacklistConfig{
+			Blacklist: []string{"example.com"},
+		},
+		Groups: []*GroupConfig{
+			{Name: "kids", Clients: []string{"10.0.0.2"}},
+		},
+		Categories: []*CategoryConfig{
+			{Name: "games", Action: "deny", Domains: []string{"games.com"},
+				Groups: []string{"kids"}},
+		},
+		Content: &ContentConfig{Keywords: []string{"casino"}},
+	}
+
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if err := rm.ConfigureHistory(dir); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if err := rm.Update(rc); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	_, err = rm.EditList("blacklist", ListEdit{Add: []string{"twitter.com"}},
+		AnyVersion, Change{Author: "parent", Source: SourceAPI})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// a restart loads the history and continues from its newest version
+	restarted, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if err := restarted.ConfigureHistory(dir); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	history := restarted.History(0)
+	if len(history) != 2 || history[0].Version != 2 || history[0].Author != "parent" {
+		t.Fatalf("got %+v wanted versions 2 and 1", history)
+	}
+
+	// the configuration it starts with only makes a version if it differs
+	// from the newest one
+	unchanged := *rc
+	unchanged.DomainBlacklistConfig = &DomainBlacklistConfig{
+		Blacklist: []string{"example.com", "twitter.com"},
+	}
+	if err := restarted.Update(&unchanged); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if restarted.Generation() != 2 {
+		t.Fatalf("got version %d wanted 2 for unchanged rules",
+			restarted.Generation())
+	}
+	if err := restarted.Update(rc); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if restarted.Generation() != 3 {
+		t.Fatalf("got version %d wanted 3", restarted.Generation())
+	}
+
+	// versions from before the restart can be rolled back to
+	snapshot, err := restarted.Rollback(2, AnyVersion, Change{Source: SourceRollback})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if snapshot.Version != 4 {
+		t.Fatalf("got version %d wanted 4", snapshot.Version)
+	}
+	rules, _ := restarted.Rules()
+	if !reflect.DeepEqual(rules.Blacklist, []string{"example.com", "twitter.com"}) {
+		t.Fatalf("got %v wanted the blacklist of version 2", rules.Blacklist)
+	}
+}
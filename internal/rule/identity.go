@@ -0,0 +1,55 @@
+package rule
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// ClientIdentity is who a request comes from as far as the proxy knows,
+// which is more than the request itself says once squid passed it on
+type ClientIdentity struct {
+	// IP is the address of the client, nil if it is not known
+	IP net.IP
+	// User is the name the client authenticated to the proxy with, empty
+	// if it didn't
+	User string
+}
+
+// identityKey is the context key of the identity of a request
+type identityKey struct{}
+
+// WithIdentity returns a copy of request that the rules treat as coming
+// from identity rather than from its remote address
+func WithIdentity(request *http.Request, identity *ClientIdentity) *http.Request {
+	return request.WithContext(
+		context.WithValue(request.Context(), identityKey{}, identity))
+}
+
+// identityOf returns the identity request was given by WithIdentity, or nil
+func identityOf(request *http.Request) *ClientIdentity {
+	identity, _ := request.Context().Value(identityKey{}).(*ClientIdentity)
+	return identity
+}
+
+// ClientAddr returns the address of the client without the port, taken
+// from the identity of request if it has one with an address
+func ClientAddr(request *http.Request) string {
+	if identity := identityOf(request); identity != nil && identity.IP != nil {
+		return identity.IP.String()
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// ClientUser returns the name the client of request authenticated to the
+// proxy with, empty if it didn't
+func ClientUser(request *http.Request) string {
+	if identity := identityOf(request); identity != nil {
+		return identity.User
+	}
+	return ""
+}
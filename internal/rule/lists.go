@@ -0,0 +1,290 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/rs/zerolog/log"
+)
+
+// AnyVersion can be passed wherever a version is expected to apply a change
+// regardless of what changed in the meantime
+const AnyVersion uint64 = 0
+
+var (
+	// ErrVersionMismatch is returned when a change was based on a version
+	// of the rules that is no longer current
+	ErrVersionMismatch = errors.New("rules were changed by someone else")
+	// ErrUnknownList is returned for a list name that doesn't exist
+	ErrUnknownList = errors.New("unknown list")
+)
+
+// Lists are the names of the domain lists that can be edited
+var Lists = []string{"blacklist", "whitelist"}
+
+// ListEdit adds and removes domains from a list, removals are applied after
+// additions
+type ListEdit struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// ListChange is what an edit actually did to a list, domains that were
+// already present or absent are not reported
+type ListChange struct {
+	List    string   `json:"list"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Domains []string `json:"domains"`
+	Version uint64   `json:"version"`
+}
+
+// Changed reports whether the edit modified the list
+func (lc *ListChange) Changed() bool {
+	return len(lc.Added) > 0 || len(lc.Removed) > 0
+}
+
+// NormalizeDomain lowercases domain and strips a trailing dot, it fails if
+// the result is not a valid host name
+func NormalizeDomain(domain string) (string, error) {
+	d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if !valid.IsDNSName(d) {
+		return "", fmt.Errorf("'%s' is not a valid domain", domain)
+	}
+	return d, nil
+}
+
+// ValidateDomain reports whether domain is accepted by NormalizeDomain, every
+// domain in the rules is checked with it
+func ValidateDomain(domain string) error {
+	_, err := NormalizeDomain(domain)
+	return err
+}
+
+// List returns the domains in the list called name
+func (rc *RuleConfig) List(name string) ([]string, error) {
+	switch name {
+	case "blacklist":
+		if rc.DomainBlacklistConfig == nil {
+			return nil, nil
+		}
+		return rc.Blacklist, nil
+	case "whitelist":
+		if rc.DomainWhitelistConfig == nil {
+			return nil, nil
+		}
+		return rc.Whitelist, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownList, name)
+}
+
+// setList replaces the domains of the list called name
+func (rc *RuleConfig) setList(name string, domains []string) error {
+	switch name {
+	case "blacklist":
+		rc.DomainBlacklistConfig = &DomainBlacklistConfig{Blacklist: domains}
+	case "whitelist":
+		rc.DomainWhitelistConfig = &DomainWhitelistConfig{Whitelist: domains}
+	default:
+		return fmt.Errorf("%w %s", ErrUnknownList, name)
+	}
+	return nil
+}
+
+// Rules returns the current configuration together with its version
+func (rm *Manager) Rules() (*RuleConfig, uint64) {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	if rm.conf == nil {
+		return &RuleConfig{}, rm.generation
+	}
+	result := *rm.conf
+	return &result, rm.generation
+}
+
+// UpdateIf is Update for change, but fails with ErrVersionMismatch unless
+// version is the current version or AnyVersion. It returns the new version.
+func (rm *Manager) UpdateIf(
+	rc *RuleConfig,
+	version uint64,
+	change Change,
+) (uint64, error) {
+	rm.updateLock.Lock()
+	defer rm.updateLock.Unlock()
+
+	if err := rm.checkVersion(version); err != nil {
+		return 0, err
+	}
+
+	merged := rm.merge(rc)
+	rs, err := compile(merged)
+	if err != nil {
+		return 0, err
+	}
+
+	rm.update(rs, merged, change)
+	return rm.Generation(), nil
+}
+
+// Preview returns what UpdateIf would do with rc without applying it: the
+// resulting configuration and how it differs from the current one. The
+// version of the result is the current version.
+func (rm *Manager) Preview(rc *RuleConfig, version uint64) (*Snapshot, error) {
+	rm.updateLock.Lock()
+	defer rm.updateLock.Unlock()
+
+	if err := rm.checkVersion(version); err != nil {
+		return nil, err
+	}
+
+	merged := rm.merge(rc)
+	if _, err := compile(merged); err != nil {
+		return nil, err
+	}
+
+	current, generation := rm.Rules()
+	return &Snapshot{
+		Version: generation,
+		Diff:    diff(current, merged),
+		Rules:   merged,
+	}, nil
+}
+
+// EditList applies edit to the list called name, as long as version is the
+// current version or AnyVersion. Adding a domain that is already listed or
+// removing one that isn't is not an error. The version is only incremented
+// if the list changed.
+func (rm *Manager) EditList(
+	name string,
+	edit ListEdit,
+	version uint64,
+	change Change,
+) (*ListChange, error) {
+	ve := &ValidationError{}
+	add := validateDomains(ve, "add", edit.Add)
+	remove := validateDomains(ve, "remove", edit.Remove)
+	if err := ve.err(); err != nil {
+		return nil, err
+	}
+
+	return rm.changeList(name, version, change, func(set map[string]bool) {
+		for _, d := range add {
+			set[d] = true
+		}
+		for _, d := range remove {
+			delete(set, d)
+		}
+	})
+}
+
+// ReplaceList replaces every domain in the list called name, as long as
+// version is the current version or AnyVersion
+func (rm *Manager) ReplaceList(
+	name string,
+	domains []string,
+	version uint64,
+	change Change,
+) (*ListChange, error) {
+	ve := &ValidationError{}
+	replacement := validateDomains(ve, "domains", domains)
+	if err := ve.err(); err != nil {
+		return nil, err
+	}
+
+	return rm.changeList(name, version, change, func(set map[string]bool) {
+		for d := range set {
+			delete(set, d)
+		}
+		for _, d := range replacement {
+			set[d] = true
+		}
+	})
+}
+
+// changeList lets modify change the set of domains in the list called name
+// and swaps in the result if anything changed
+func (rm *Manager) changeList(
+	name string,
+	version uint64,
+	change Change,
+	modify func(set map[string]bool),
+) (*ListChange, error) {
+	rm.updateLock.Lock()
+	defer rm.updateLock.Unlock()
+
+	if err := rm.checkVersion(version); err != nil {
+		return nil, err
+	}
+
+	current, generation := rm.Rules()
+	domains, err := current.List(name)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		set[d] = true
+	}
+	modify(set)
+
+	result := &ListChange{
+		List:    name,
+		Added:   []string{},
+		Removed: []string{},
+		Domains: make([]string, 0, len(set)),
+		Version: generation,
+	}
+	for d := range set {
+		result.Domains = append(result.Domains, d)
+	}
+	sort.Strings(result.Domains)
+
+	old := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		old[d] = true
+		if !set[d] {
+			result.Removed = append(result.Removed, d)
+		}
+	}
+	for _, d := range result.Domains {
+		if !old[d] {
+			result.Added = append(result.Added, d)
+		}
+	}
+	sort.Strings(result.Removed)
+
+	if !result.Changed() {
+		return result, nil
+	}
+
+	if err := current.setList(name, result.Domains); err != nil {
+		return nil, err
+	}
+	rs, err := compile(current)
+	if err != nil {
+		return nil, err
+	}
+	rm.update(rs, current, change)
+
+	log.Info().
+		Str("list", name).
+		Strs("added", result.Added).
+		Strs("removed", result.Removed).
+		Msg("edited list")
+
+	result.Version = rm.Generation()
+	return result, nil
+}
+
+// checkVersion must be called with updateLock held
+func (rm *Manager) checkVersion(version uint64) error {
+	if version != AnyVersion && version != rm.Generation() {
+		return ErrVersionMismatch
+	}
+	return nil
+}
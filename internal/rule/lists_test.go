@@ -0,0 +1,113 @@
+package rule
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_Manager_EditList(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{DomainBlacklistConfig: &DomainBlacklistConfig{}})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	_, version := rm.Rules()
+	change, err := rm.EditList("blacklist", ListEdit{
+		Add: []string{"Example.com.", "example.com", "twitter.com"},
+	}, version, Change{})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	wanted := []string{"example.com", "twitter.com"}
+	if !reflect.DeepEqual(change.Domains, wanted) {
+		t.Fatalf("got %v wanted %v", change.Domains, wanted)
+	}
+	if change.Version == version {
+		t.Fatalf("got version %d wanted a new one", change.Version)
+	}
+
+	// an edit based on the old version must not apply
+	_, err = rm.EditList("blacklist", ListEdit{
+		Remove: []string{"twitter.com"},
+	}, version, Change{})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("got %v wanted %v", err, ErrVersionMismatch)
+	}
+
+	// removing the last domain must not leave a list that matches everything
+	change, err = rm.EditList("blacklist", ListEdit{
+		Remove: []string{"example.com", "twitter.com", "absent.com"},
+	}, change.Version, Change{})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if len(change.Removed) != 2 || len(change.Domains) != 0 {
+		t.Fatalf("got %+v wanted 2 removed and an empty list", change)
+	}
+	request := httptest.NewRequest("GET", "http://cat.com", nil)
+	if !rm.Allow(request) {
+		t.Fatalf("got deny wanted allow for %v", request.Host)
+	}
+
+	// an edit that changes nothing keeps the version
+	unchanged, err := rm.EditList("blacklist", ListEdit{
+		Remove: []string{"absent.com"},
+	}, AnyVersion, Change{})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if unchanged.Changed() || unchanged.Version != change.Version {
+		t.Fatalf("got %+v wanted no change at version %d",
+			unchanged, change.Version)
+	}
+
+	_, err = rm.EditList("greylist", ListEdit{}, AnyVersion, Change{})
+	if !errors.Is(err, ErrUnknownList) {
+		t.Fatalf("got %v wanted %v", err, ErrUnknownList)
+	}
+	_, err = rm.EditList("blacklist", ListEdit{Add: []string{"not a domain"}},
+		AnyVersion, Change{})
+	if err == nil {
+		t.Fatalf("got nil wanted an error for an invalid domain")
+	}
+}
+
+func Test_Manager_ReplaceList(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	wl, err := LoadWhitelistFromArray([]string{"a.com", "b.com"})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	err = rm.Update(&RuleConfig{DomainWhitelistConfig: wl})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	change, err := rm.ReplaceList("whitelist",
+		[]string{"c.com", "b.com"}, AnyVersion, Change{})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	wanted := &ListChange{
+		List:    "whitelist",
+		Added:   []string{"c.com"},
+		Removed: []string{"a.com"},
+		Domains: []string{"b.com", "c.com"},
+		Version: 2,
+	}
+	if !reflect.DeepEqual(change, wanted) {
+		t.Fatalf("got %+v wanted %+v", change, wanted)
+	}
+}
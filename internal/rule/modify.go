@@ -0,0 +1,107 @@
+package rule
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Modification is how an allowed request has to be changed before it is
+// passed on
+type Modification struct {
+	// Query are parameters to set in the URL, replacing any values they
+	// had
+	Query url.Values
+	// Remove are headers to remove, before Set and Add are applied
+	Remove []string
+	// Set are headers to set, replacing any values they had
+	Set http.Header
+	// Add are values to add to headers
+	Add http.Header
+	// Rules are the names of the rules that changed the request
+	Rules []string
+}
+
+func newModification() *Modification {
+	return &Modification{Query: url.Values{}, Set: http.Header{}, Add: http.Header{}}
+}
+
+// IsEmpty reports whether m leaves a request as it is
+func (m *Modification) IsEmpty() bool {
+	return len(m.Query) == 0 && len(m.Remove) == 0 && len(m.Set) == 0 &&
+		len(m.Add) == 0
+}
+
+// Apply changes request in place
+func (m *Modification) Apply(request *http.Request) {
+	if len(m.Query) > 0 {
+		query := request.URL.Query()
+		for name, values := range m.Query {
+			query[name] = values
+		}
+		request.URL.RawQuery = query.Encode()
+		// a proxy is sent the whole URL, a server only the path
+		if strings.HasPrefix(request.RequestURI, "/") {
+			request.RequestURI = request.URL.RequestURI()
+		} else if request.RequestURI != "" {
+			request.RequestURI = request.URL.String()
+		}
+	}
+	for _, name := range m.Remove {
+		request.Header.Del(name)
+	}
+	for name, values := range m.Set {
+		request.Header[name] = values
+	}
+	for name, values := range m.Add {
+		request.Header[name] = append(request.Header[name], values...)
+	}
+}
+
+// hostOf returns the lowercased host of request without the port
+func hostOf(request *http.Request) string {
+	host := request.Host
+	if host == "" && request.URL != nil {
+		host = request.URL.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Modify returns how request has to be changed, or nil if it can be
+// passed on as it is. It only applies to requests that were allowed.
+func (rm *Manager) Modify(request *http.Request) *Modification {
+	if request.Method == http.MethodConnect || request.URL == nil {
+		// the request inside the tunnel can't be seen
+		return nil
+	}
+
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	q := rm.queryInLock(request, time.Now())
+	m := newModification()
+	if rm.safeSearch != nil && rm.safeSearch.modify(q, m) {
+		m.Rules = append(m.Rules, "safe_search")
+	}
+	for _, h := range rm.headers {
+		if h.modify(q, m) {
+			m.Rules = append(m.Rules, "header:"+h.conf.Name)
+		}
+	}
+	// after the header rules, so that none of them asks for an encoding
+	// that can't be scanned
+	o := rm.overrides.match(q)
+	if rm.content != nil && (o == nil || o.action != allow) &&
+		rm.content.modify(q, m) {
+		m.Rules = append(m.Rules, "content")
+	}
+	if m.IsEmpty() {
+		return nil
+	}
+	return m
+}
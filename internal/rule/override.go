@@ -0,0 +1,154 @@
+package rule
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Override temporarily allows or denies a domain regardless of the rules,
+// for everyone or for a single group. Overrides are not part of the rule
+// configuration and are forgotten on restart.
+type Override struct {
+	ID      uint64    `json:"id"`
+	Domain  string    `json:"domain"`
+	Action  string    `json:"action"`
+	Group   string    `json:"group,omitempty"`
+	Expires time.Time `json:"expires"`
+	By      string    `json:"by,omitempty"`
+}
+
+type override struct {
+	Override
+	action permitted
+	re     *regexp.Regexp
+}
+
+// overrides are guarded by the lock of the Manager holding them
+type overrides struct {
+	next uint64
+	list []*override
+}
+
+// match returns the newest override that applies to q
+func (ov *overrides) match(q *query) *override {
+	for i := len(ov.list) - 1; i >= 0; i-- {
+		o := ov.list[i]
+		if !q.now.Before(o.Expires) {
+			continue
+		}
+		if o.Group != "" && o.Group != q.group {
+			continue
+		}
+		if o.re.MatchString(q.request.Host) {
+			return o
+		}
+	}
+	return nil
+}
+
+// nextExpiry returns when the next override expires, or the zero time
+func (ov *overrides) nextExpiry(now time.Time) time.Time {
+	var result time.Time
+	for _, o := range ov.list {
+		if o.Expires.After(now) &&
+			(result.IsZero() || o.Expires.Before(result)) {
+			result = o.Expires
+		}
+	}
+	return result
+}
+
+// prune drops expired overrides
+func (ov *overrides) prune(now time.Time) {
+	active := ov.list[:0]
+	for _, o := range ov.list {
+		if o.Expires.After(now) {
+			active = append(active, o)
+		}
+	}
+	ov.list = active
+}
+
+// AddOverride validates and activates o, the ID is assigned by the Manager
+func (rm *Manager) AddOverride(o Override) (*Override, error) {
+	var err error
+	o.Domain, err = NormalizeDomain(o.Domain)
+	if err != nil {
+		return nil, err
+	}
+	action, err := ParseAction(o.Action)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !o.Expires.After(now) {
+		return nil, fmt.Errorf("override must expire in the future")
+	}
+	re, err := compileDomains([]string{o.Domain})
+	if err != nil {
+		return nil, err
+	}
+
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	if o.Group != "" && !rm.hasGroupInLock(o.Group) {
+		return nil, fmt.Errorf("unknown group %s", o.Group)
+	}
+
+	rm.overrides.prune(now)
+	rm.overrides.next++
+	o.ID = rm.overrides.next
+	rm.overrides.list = append(rm.overrides.list, &override{
+		Override: o,
+		action:   action,
+		re:       re,
+	})
+	rm.cache.flush()
+
+	return &o, nil
+}
+
+// RemoveOverride ends the override with id early, it returns false if there
+// is no active override with id
+func (rm *Manager) RemoveOverride(id uint64) bool {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	rm.overrides.prune(time.Now())
+	for i, o := range rm.overrides.list {
+		if o.ID == id {
+			rm.overrides.list = append(
+				rm.overrides.list[:i], rm.overrides.list[i+1:]...)
+			rm.cache.flush()
+			return true
+		}
+	}
+	return false
+}
+
+// Overrides returns the overrides that are active at now, oldest first
+func (rm *Manager) Overrides(now time.Time) []Override {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	result := []Override{}
+	for _, o := range rm.overrides.list {
+		if o.Expires.After(now) {
+			result = append(result, o.Override)
+		}
+	}
+	return result
+}
+
+// hasGroupInLock reports whether a group called name exists, it assumes
+// that it is only called inside the lock
+func (rm *Manager) hasGroupInLock(name string) bool {
+	for _, g := range rm.groups {
+		if g.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package rule
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Manager_Override(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		DomainBlacklistConfig: &DomainBlacklistConfig{
+			Blacklist: []string{"example.com"},
+		},
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	kid := httptest.NewRequest("GET", "http://www.example.com", nil)
+	kid.RemoteAddr = "10.0.0.2:1234"
+	parent := httptest.NewRequest("GET", "http://www.example.com", nil)
+	parent.RemoteAddr = "10.0.0.3:1234"
+
+	if rm.Allow(kid) {
+		t.Fatalf("got allow wanted deny before the override")
+	}
+
+	o, err := rm.AddOverride(Override{
+		Domain:  "example.com",
+		Action:  "allow",
+		Group:   "kids",
+		Expires: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// the cached deny must not survive the override
+	decision := rm.Decide(kid)
+	if !decision.Allow || decision.Rule != "override:1" {
+		t.Fatalf("got %+v wanted allow by override:1", decision)
+	}
+	if rm.Allow(parent) {
+		t.Fatalf("got allow wanted deny outside the group")
+	}
+
+	explained := rm.Explain(kid)
+	if explained.Group != "kids" || len(explained.Steps) != 1 ||
+		explained.Steps[0].Rule != "override:1" {
+		t.Fatalf("got %+v wanted only override:1 applied", explained)
+	}
+
+	if !rm.RemoveOverride(o.ID) || rm.RemoveOverride(o.ID) {
+		t.Fatalf("wanted the override to be removed exactly once")
+	}
+	if rm.Allow(kid) {
+		t.Fatalf("got allow wanted deny after the override ended")
+	}
+
+	invalid := []Override{
+		{Domain: "example.com", Action: "allow"},
+		{Domain: "example.com", Action: "maybe", Expires: time.Now().Add(time.Hour)},
+		{Domain: "example.com", Action: "deny", Group: "adults",
+			Expires: time.Now().Add(time.Hour)},
+	}
+	for _, o := range invalid {
+		if _, err := rm.AddOverride(o); err == nil {
+			t.Errorf("got nil wanted an error for %+v", o)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package rule
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RedirectConfig answers the requests that some rules deny with a redirect
+// instead of the usual block, optionally only for some groups of clients
+type RedirectConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// URL is where to redirect to, {url}, {host}, {client}, {group} and
+	// {rule} are replaced with the escaped values of the denied request
+	URL string `json:"url" yaml:"url"`
+	// Status is 302 or 307, 302 if 0
+	Status int `json:"status,omitempty" yaml:"status"`
+	// Rules limits the redirect to denials by these rules, all rules if
+	// empty. A name without a colon, like category, stands for every rule
+	// of that kind.
+	Rules []string `json:"rules,omitempty" yaml:"rules"`
+	// Groups limits the redirect to these client groups, all clients if
+	// empty
+	Groups []string `json:"groups,omitempty" yaml:"groups"`
+}
+
+func (rc *RedirectConfig) String() string {
+	return fmt.Sprintf("%s: %d %s", rc.Name, rc.status(), rc.URL)
+}
+
+func (rc *RedirectConfig) status() int {
+	if rc.Status == 0 {
+		return http.StatusFound
+	}
+	return rc.Status
+}
+
+// placeholders are what can be used in the URL of a redirect
+var placeholders = []string{"{url}", "{host}", "{client}", "{group}", "{rule}"}
+
+// Validate checks everything that doesn't depend on other parts of the
+// configuration
+func (rc *RedirectConfig) Validate() error {
+	if rc.Name == "" {
+		return fmt.Errorf("redirect must have a name")
+	}
+	if err := ValidateRedirectURL(rc.URL); err != nil {
+		return err
+	}
+	switch rc.status() {
+	case http.StatusFound, http.StatusTemporaryRedirect:
+	default:
+		return fmt.Errorf("invalid status %d, expected 302 or 307", rc.Status)
+	}
+	for _, r := range rc.Rules {
+		if r == "" {
+			return fmt.Errorf("rules cannot be empty")
+		}
+	}
+	return nil
+}
+
+// ValidateRedirectURL checks that template is an absolute http or https URL
+// once the placeholders are replaced
+func ValidateRedirectURL(template string) error {
+	filled := template
+	for _, p := range placeholders {
+		filled = strings.Replace(filled, p, "x", -1)
+	}
+	if strings.ContainsAny(filled, "{}") {
+		return fmt.Errorf("unknown placeholder in %q", template)
+	}
+	u, err := url.Parse(filled)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid redirect URL %q", template)
+	}
+	return nil
+}
+
+// Redirect is how a denied request is answered instead of being blocked
+type Redirect struct {
+	// Name is the name of the redirect that applied
+	Name   string
+	URL    string
+	Status int
+}
+
+type redirect struct {
+	conf   *RedirectConfig
+	rules  map[string]bool
+	groups map[string]bool
+}
+
+func newRedirect(
+	config *RedirectConfig,
+	groups map[string]*clientGroup,
+) (*redirect, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &redirect{
+		conf:   config,
+		rules:  make(map[string]bool),
+		groups: make(map[string]bool),
+	}
+	for _, name := range config.Rules {
+		r.rules[name] = true
+	}
+	for _, g := range config.Groups {
+		if _, ok := groups[g]; !ok {
+			return nil, fmt.Errorf("unknown group %q", g)
+		}
+		r.groups[g] = true
+	}
+	return r, nil
+}
+
+// applies reports whether the redirect is used for decision
+func (r *redirect) applies(decision *Decision) bool {
+	if len(r.groups) > 0 && !r.groups[decision.Group] {
+		return false
+	}
+	if len(r.rules) == 0 || r.rules[decision.Rule] {
+		return true
+	}
+	kind := strings.SplitN(decision.Rule, ":", 2)[0]
+	return r.rules[kind]
+}
+
+// Redirect returns where to redirect request, which decision denied, or nil
+// if it is blocked as usual. The first redirect that applies is used.
+// CONNECT requests are never redirected, browsers don't follow a redirect
+// in answer to opening a tunnel.
+func (rm *Manager) Redirect(request *http.Request, decision *Decision) *Redirect {
+	if decision.Allow || request.Method == http.MethodConnect {
+		return nil
+	}
+
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	for _, r := range rm.redirects {
+		if !r.applies(decision) {
+			continue
+		}
+		values := strings.NewReplacer(
+			"{url}", url.QueryEscape(request.URL.String()),
+			"{host}", url.QueryEscape(hostOf(request)),
+			"{client}", url.QueryEscape(ClientAddr(request)),
+			"{group}", url.QueryEscape(decision.Group),
+			"{rule}", url.QueryEscape(decision.Rule),
+		)
+		return &Redirect{
+			Name:   r.conf.Name,
+			URL:    values.Replace(r.conf.URL),
+			Status: r.conf.status(),
+		}
+	}
+	return nil
+}
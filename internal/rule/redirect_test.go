@@ -0,0 +1,106 @@
+package rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Manager_Redirect(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		DomainBlacklistConfig: &DomainBlacklistConfig{
+			Blacklist: []string{"example.com"},
+		},
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+		Categories: []*CategoryConfig{
+			{Name: "games", Action: "deny", Domains: []string{"games.com"}},
+		},
+		Redirects: []*RedirectConfig{
+			{
+				Name:   "kids",
+				URL:    "https://blocked.lan/kids?url={url}&client={client}&rule={rule}",
+				Status: http.StatusTemporaryRedirect,
+				Rules:  []string{"category"},
+				Groups: []string{"kids"},
+			},
+			{
+				Name:  "blacklist",
+				URL:   "http://blocked.lan/{host}",
+				Rules: []string{"blacklist"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	tests := []struct {
+		url    string
+		client string
+		// want is where to redirect to, empty if the request is blocked
+		// as usual
+		want   string
+		status int
+	}{
+		{
+			"http://games.com/play?level=1&x=y", "10.0.0.2",
+			"https://blocked.lan/kids?url=http%3A%2F%2Fgames.com%2Fplay%3Flevel%3D1%26x%3Dy" +
+				"&client=10.0.0.2&rule=category%3Agames",
+			http.StatusTemporaryRedirect,
+		},
+		{"http://games.com/play", "10.0.0.3", "", 0},
+		{"http://www.example.com/", "10.0.0.2", "http://blocked.lan/www.example.com", http.StatusFound},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", test.url, nil)
+		request.RemoteAddr = test.client + ":1234"
+		decision := rm.Decide(request)
+		if decision.Allow {
+			t.Fatalf("got allow wanted deny for %s", test.url)
+		}
+		to := rm.Redirect(request, decision)
+		if test.want == "" {
+			if to != nil {
+				t.Errorf("got %+v wanted no redirect for %s", to, test.url)
+			}
+			continue
+		}
+		if to == nil || to.URL != test.want || to.Status != test.status {
+			t.Errorf("got %+v wanted %d %s", to, test.status, test.want)
+		}
+	}
+
+	request := httptest.NewRequest("GET", "http://allowed.com/", nil)
+	if to := rm.Redirect(request, rm.Decide(request)); to != nil {
+		t.Fatalf("got %+v wanted no redirect for an allowed request", to)
+	}
+
+	connect := httptest.NewRequest("CONNECT", "www.example.com:443", nil)
+	decision := rm.Decide(connect)
+	if decision.Allow {
+		t.Fatalf("got allow wanted deny for CONNECT")
+	}
+	if to := rm.Redirect(connect, decision); to != nil {
+		t.Fatalf("got %+v wanted CONNECT blocked as usual", to)
+	}
+}
+
+func Test_RedirectConfig_Invalid(t *testing.T) {
+	tests := []*RedirectConfig{
+		{URL: "http://blocked.lan/"},
+		{Name: "a", URL: "/blocked"},
+		{Name: "a", URL: "ftp://blocked.lan/"},
+		{Name: "a", URL: "http://blocked.lan/?u={uri}"},
+		{Name: "a", URL: "http://blocked.lan/", Status: http.StatusMovedPermanently},
+	}
+	for _, rc := range tests {
+		if err := rc.Validate(); err == nil {
+			t.Errorf("got nil wanted an error for %+v", rc)
+		}
+	}
+}
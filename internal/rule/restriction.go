@@ -0,0 +1,48 @@
+package rule
+
+import (
+	"sort"
+	"time"
+)
+
+// Restriction is a scheduled category that applies to a group of clients
+type Restriction struct {
+	Category string `json:"category"`
+	Action   string `json:"action"`
+	Schedule string `json:"schedule"`
+	// Active is whether the category currently applies
+	Active bool `json:"active"`
+	// Changes is when Active flips next, zero if it never does
+	Changes time.Time `json:"changes,omitempty"`
+}
+
+// Restrictions lists the scheduled categories that apply to clients in
+// group at now
+func (rm *Manager) Restrictions(group string, now time.Time) []Restriction {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	var result []Restriction
+	for _, r := range rm.rules {
+		c, ok := r.(*category)
+		if !ok || c.schedule == nil {
+			continue
+		}
+		if len(c.groups) > 0 && !c.groups[group] {
+			continue
+		}
+
+		result = append(result, Restriction{
+			Category: c.conf.Name,
+			Action:   c.action.String(),
+			Schedule: c.schedule.name,
+			Active:   c.schedule.active(now),
+			Changes:  c.schedule.flips(now),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Category < result[j].Category
+	})
+	return result
+}
@@ -2,11 +2,17 @@ package rule
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
+
+	"github.com/jcline/babysitter/internal/events"
+	"github.com/jcline/babysitter/internal/metrics"
 )
 
 type permitted int
@@ -32,6 +38,13 @@ func (p permitted) String() string {
 type RuleConfig struct {
 	*DomainBlacklistConfig
 	*DomainWhitelistConfig
+	Groups     []*GroupConfig    `json:"groups,omitempty"`
+	Categories []*CategoryConfig `json:"categories,omitempty"`
+	Schedules  []*ScheduleConfig `json:"schedules,omitempty"`
+	Content    *ContentConfig    `json:"content,omitempty"`
+	SafeSearch *SafeSearchConfig `json:"safe_search,omitempty"`
+	Headers    []*HeaderConfig   `json:"headers,omitempty"`
+	Redirects  []*RedirectConfig `json:"redirects,omitempty"`
 }
 
 func (rc *RuleConfig) String() string {
@@ -46,6 +59,29 @@ func (rc *RuleConfig) String() string {
 		b.WriteString(rc.DomainWhitelistConfig.String())
 		b.WriteString("\n")
 	}
+	for _, g := range rc.Groups {
+		fmt.Fprintf(&b, "group %s: %s\n", g.Name,
+			strings.Join(append(append([]string{}, g.Clients...), g.Users...), ", "))
+	}
+	for _, s := range rc.Schedules {
+		fmt.Fprintf(&b, "schedule %s: %s %s-%s\n",
+			s.Name, strings.Join(s.Days, ","), s.Start, s.End)
+	}
+	for _, c := range rc.Categories {
+		fmt.Fprintf(&b, "category %s\n", c)
+	}
+	if rc.Content != nil {
+		fmt.Fprintf(&b, "content %s\n", rc.Content)
+	}
+	if rc.SafeSearch != nil {
+		fmt.Fprintf(&b, "safe search %s\n", rc.SafeSearch)
+	}
+	for _, h := range rc.Headers {
+		fmt.Fprintf(&b, "headers %s\n", h)
+	}
+	for _, r := range rc.Redirects {
+		fmt.Fprintf(&b, "redirect %s\n", r)
+	}
 	return b.String()
 }
 
@@ -68,138 +104,562 @@ func NewRuleConfig(wlp, blp string) (*RuleConfig, error) {
 	return &rc, nil
 }
 
+// NewRuleConfigFromMap builds a RuleConfig from lists of domains keyed by
+// list name. Every domain is validated and normalized, and every problem is
+// reported in a *ValidationError.
 func NewRuleConfigFromMap(m map[string][]string) (*RuleConfig, error) {
-	var err error
-
+	ve := &ValidationError{}
 	rc := RuleConfig{}
 
-	for k, v := range m {
-		switch k {
-		case "blacklist":
-			rc.DomainBlacklistConfig, err = LoadBlacklistFromArray(v)
-		case "whitelist":
-			rc.DomainWhitelistConfig, err = LoadWhitelistFromArray(v)
+	for _, k := range sortedKeys(m) {
+		if !isList(k) {
+			ve.add(k, "", "unknown list, expected one of %v", Lists)
+			continue
 		}
-
+		err := rc.setList(k, validateDomains(ve, k, m[k]))
 		if err != nil {
-			return nil, fmt.Errorf("could not load %s: %v", k, err)
+			return nil, err
 		}
 	}
 
+	if err := ve.err(); err != nil {
+		return nil, err
+	}
 	return &rc, nil
 }
 
+// query is everything a rule may base its result on
+type query struct {
+	request *http.Request
+	// client is the address of the client, nil if it is not known
+	client net.IP
+	// user is the name the client authenticated to the proxy with, if any
+	user string
+	// group is the name of the group of the request, if any
+	group string
+	now   time.Time
+}
+
 type rule interface {
-	allow(request *http.Request) (permitted, bool)
+	allow(q *query) permitted
 	fmt.Stringer
 }
 
-type Manager struct {
+// Decision is the final result of applying every rule to a request
+type Decision struct {
+	Allow bool `json:"allow"`
+	// Rule is the name of the rule that decided, or "default" if no rule
+	// applied
+	Rule string `json:"rule"`
+	// Group is the group of the client, if it is in one
+	Group string `json:"group,omitempty"`
+	// Reason is what a content rule found, like the MIME type or the
+	// keywords
+	Reason string `json:"reason,omitempty"`
+	// Matches are the terms a content rule found in a page
+	Matches []Match `json:"matches,omitempty"`
+}
+
+// ruleSet is a compiled RuleConfig
+type ruleSet struct {
 	rules map[string]rule
-	conf  *RuleConfig
-	lock  *sync.RWMutex
+	// order is the names of rules sorted, rules are applied in this order
+	order     []string
+	groups    []*clientGroup
+	schedules []*schedule
+	// content is applied to responses rather than requests, nil if there
+	// are no content rules
+	content *contentRule
+	// safeSearch rewrites allowed requests, nil if it is off
+	safeSearch *safeSearch
+	// headers change the headers of allowed requests, in the order they
+	// were configured
+	headers []*headerRule
+	// redirects replace blocking denied requests, the first one that
+	// applies is used
+	redirects []*redirect
+}
+
+// compile builds every rule in rc, it fails with a *ValidationError
+// listing every entry that is invalid or refers to a group or schedule that
+// doesn't exist
+func compile(rc *RuleConfig) (*ruleSet, error) {
+	rs := &ruleSet{rules: make(map[string]rule)}
+	ve := &ValidationError{}
+
+	if rc.DomainBlacklistConfig != nil {
+		bl, err := NewDomainBlacklist(rc.DomainBlacklistConfig)
+		if err != nil {
+			ve.add("blacklist", "", "%v", err)
+		} else {
+			rs.rules["blacklist"] = bl
+		}
+	}
+
+	if rc.DomainWhitelistConfig != nil {
+		wl, err := NewDomainWhitelist(rc.DomainWhitelistConfig)
+		if err != nil {
+			ve.add("whitelist", "", "%v", err)
+		} else {
+			rs.rules["whitelist"] = wl
+		}
+	}
+
+	// invalid groups and schedules are still known by name, so that what
+	// refers to them isn't reported as well
+	groups := make(map[string]*clientGroup)
+	for i, gc := range rc.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		if gc == nil {
+			ve.add(field, "", "empty group")
+			continue
+		}
+		if _, ok := groups[gc.Name]; ok {
+			ve.add(field, gc.Name, "duplicate group %s", gc.Name)
+			continue
+		}
+		g, err := newClientGroup(gc)
+		if err != nil {
+			ve.add(field, gc.Name, "%v", err)
+			groups[gc.Name] = &clientGroup{name: gc.Name}
+			continue
+		}
+		groups[g.name] = g
+		rs.groups = append(rs.groups, g)
+	}
+
+	schedules := make(map[string]*schedule)
+	for i, sc := range rc.Schedules {
+		field := fmt.Sprintf("schedules[%d]", i)
+		if sc == nil {
+			ve.add(field, "", "empty schedule")
+			continue
+		}
+		if _, ok := schedules[sc.Name]; ok {
+			ve.add(field, sc.Name, "duplicate schedule %s", sc.Name)
+			continue
+		}
+		s, err := newSchedule(sc, time.Local)
+		if err != nil {
+			ve.add(field, sc.Name, "%v", err)
+			schedules[sc.Name] = &schedule{name: sc.Name}
+			continue
+		}
+		schedules[s.name] = s
+		rs.schedules = append(rs.schedules, s)
+	}
+
+	for i, cc := range rc.Categories {
+		field := fmt.Sprintf("categories[%d]", i)
+		if cc == nil {
+			ve.add(field, "", "empty category")
+			continue
+		}
+		name := "category:" + cc.Name
+		if _, ok := rs.rules[name]; ok {
+			ve.add(field, cc.Name, "duplicate category %s", cc.Name)
+			continue
+		}
+		c, err := newCategory(cc, groups, schedules)
+		if err != nil {
+			ve.add(field, cc.Name, "%v", err)
+			continue
+		}
+		rs.rules[name] = c
+	}
+
+	if rc.Content != nil {
+		c, err := newContentRule(rc.Content, groups)
+		if err != nil {
+			ve.add("content", "", "%v", err)
+		}
+		rs.content = c
+	}
+
+	if rc.SafeSearch != nil && !rc.SafeSearch.IsEmpty() {
+		s, err := newSafeSearch(rc.SafeSearch, groups)
+		if err != nil {
+			ve.add("safe_search", "", "%v", err)
+		}
+		rs.safeSearch = s
+	}
+
+	headers := make(map[string]bool)
+	for i, hc := range rc.Headers {
+		field := fmt.Sprintf("headers[%d]", i)
+		if hc == nil {
+			ve.add(field, "", "empty header rule")
+			continue
+		}
+		if headers[hc.Name] {
+			ve.add(field, hc.Name, "duplicate header rule %s", hc.Name)
+			continue
+		}
+		headers[hc.Name] = true
+		h, err := newHeaderRule(hc, groups)
+		if err != nil {
+			ve.add(field, hc.Name, "%v", err)
+			continue
+		}
+		rs.headers = append(rs.headers, h)
+	}
+
+	redirects := make(map[string]bool)
+	for i, rdc := range rc.Redirects {
+		field := fmt.Sprintf("redirects[%d]", i)
+		if rdc == nil {
+			ve.add(field, "", "empty redirect")
+			continue
+		}
+		if redirects[rdc.Name] {
+			ve.add(field, rdc.Name, "duplicate redirect %s", rdc.Name)
+			continue
+		}
+		redirects[rdc.Name] = true
+		r, err := newRedirect(rdc, groups)
+		if err != nil {
+			ve.add(field, rdc.Name, "%v", err)
+			continue
+		}
+		rs.redirects = append(rs.redirects, r)
+	}
+
+	if err := ve.err(); err != nil {
+		return nil, err
+	}
+
+	for name := range rs.rules {
+		rs.order = append(rs.order, name)
+	}
+	sort.Strings(rs.order)
+
+	return rs, nil
+}
+
+type Manager struct {
+	rules      map[string]rule
+	order      []string
+	overrides  *overrides
+	history    *history
+	groups     []*clientGroup
+	schedules  []*schedule
+	content    *contentRule
+	safeSearch *safeSearch
+	headers    []*headerRule
+	redirects  []*redirect
+	conf       *RuleConfig
+	lock       *sync.RWMutex
+	// updateLock serializes updates, so that merging a partial update into
+	// conf can't race with another update
+	updateLock *sync.Mutex
+	cache      *decisionCache
+	// generation is incremented on every successful update
+	generation uint64
 }
 
 func NewManager() (*Manager, error) {
+	cache, err := newDecisionCache(CacheConfig{Size: DefaultCacheSize})
+	if err != nil {
+		return nil, err
+	}
+
 	return &Manager{
-		rules: make(map[string]rule),
-		lock:  &sync.RWMutex{},
+		rules:      make(map[string]rule),
+		overrides:  &overrides{},
+		history:    &history{},
+		lock:       &sync.RWMutex{},
+		updateLock: &sync.Mutex{},
+		cache:      cache,
 	}, nil
 }
 
+// ConfigureCache replaces the decision cache with an empty one using config
+func (rm *Manager) ConfigureCache(config CacheConfig) error {
+	cache, err := newDecisionCache(config)
+	if err != nil {
+		return err
+	}
+
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+	rm.cache = cache
+	return nil
+}
+
+// FlushCache drops every cached decision
+func (rm *Manager) FlushCache() {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	rm.cache.flush()
+}
+
+// CacheStats returns the current decision cache counters
+func (rm *Manager) CacheStats() CacheStats {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	return rm.cache.stats()
+}
+
 func (rm *Manager) GetRules() *RuleConfig {
 	rm.lock.RLock()
 	defer rm.lock.RUnlock()
 
+	if rm.conf == nil {
+		return &RuleConfig{}
+	}
 	result := *rm.conf
 
 	return &result
 }
 
-// updateConfInLock updates Manager.conf, it assumes that it is only called
-// inside the write lock
-func (rm *Manager) updateConfInLock(rc *RuleConfig) error {
+// merge returns a copy of the current configuration with every section set
+// in rc replaced
+func (rm *Manager) merge(rc *RuleConfig) *RuleConfig {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
 	if rm.conf == nil {
-		rm.conf = rc
-	} else {
-		if rc.DomainBlacklistConfig != nil {
-			rm.conf.DomainBlacklistConfig = rc.DomainBlacklistConfig
+		result := *rc
+		return &result
+	}
+
+	result := *rm.conf
+	if rc.DomainBlacklistConfig != nil {
+		result.DomainBlacklistConfig = rc.DomainBlacklistConfig
+	}
+	if rc.DomainWhitelistConfig != nil {
+		result.DomainWhitelistConfig = rc.DomainWhitelistConfig
+	}
+	if rc.Groups != nil {
+		result.Groups = rc.Groups
+	}
+	if rc.Categories != nil {
+		result.Categories = rc.Categories
+	}
+	if rc.Schedules != nil {
+		result.Schedules = rc.Schedules
+	}
+	if rc.Content != nil {
+		result.Content = rc.Content
+		// an empty configuration removes the content rules
+		if rc.Content.IsEmpty() {
+			result.Content = nil
 		}
-		if rc.DomainWhitelistConfig != nil {
-			rm.conf.DomainWhitelistConfig = rc.DomainWhitelistConfig
+	}
+	if rc.Headers != nil {
+		result.Headers = rc.Headers
+	}
+	if rc.Redirects != nil {
+		result.Redirects = rc.Redirects
+	}
+	if rc.SafeSearch != nil {
+		result.SafeSearch = rc.SafeSearch
+		if rc.SafeSearch.IsEmpty() {
+			result.SafeSearch = nil
 		}
 	}
-
-	return nil
+	return &result
 }
 
-//update the rule map with new rules in a threadsafe manner
-func (rm *Manager) update(rules map[string]rule, rc *RuleConfig) error {
+//update swaps in the compiled rules in a threadsafe manner and records the
+//new version in the history
+func (rm *Manager) update(rs *ruleSet, rc *RuleConfig, change Change) {
 	rm.lock.Lock()
 	defer rm.lock.Unlock()
-	for k, v := range rules {
-		rm.rules[k] = v
+
+	old := rm.conf
+	// the rules are set again after a restart, they are only a new version
+	// if they differ from the newest version in the history
+	restarted := false
+	if old == nil {
+		old = &RuleConfig{}
+		if latest := rm.history.latest(); latest != nil && latest.Rules != nil {
+			old = latest.Rules
+			restarted = true
+		}
 	}
+	changes := diff(old, rc)
+
+	rm.rules = rs.rules
+	rm.order = rs.order
+	rm.groups = rs.groups
+	rm.schedules = rs.schedules
+	rm.content = rs.content
+	rm.safeSearch = rs.safeSearch
+	rm.headers = rs.headers
+	rm.redirects = rs.redirects
+	rm.conf = rc
+	// every cached decision was made with the old rules
+	rm.cache.flush()
+
+	if !restarted || len(changes) > 0 {
+		rm.generation++
+		snapshot := &Snapshot{
+			Version: rm.generation,
+			Time:    time.Now(),
+			Change:  change,
+			Diff:    changes,
+			Rules:   rc,
+		}
+		rm.history.add(snapshot)
 
-	rm.updateConfInLock(rc)
-	return nil
+		published := *snapshot
+		published.Rules = nil
+		events.Live.Publish(events.TypeRules, &published)
+	}
+
+	metrics.RuleGeneration.Set(float64(rm.generation))
+	metrics.RuleSetSize.Reset()
+	if rm.conf.DomainBlacklistConfig != nil {
+		metrics.RuleSetSize.WithLabelValues("blacklist").
+			Set(float64(len(rm.conf.Blacklist)))
+	}
+	if rm.conf.DomainWhitelistConfig != nil {
+		metrics.RuleSetSize.WithLabelValues("whitelist").
+			Set(float64(len(rm.conf.Whitelist)))
+	}
+	for _, c := range rm.conf.Categories {
+		metrics.RuleSetSize.WithLabelValues("category:" + c.Name).
+			Set(float64(len(c.Domains)))
+	}
 }
 
-func (rm *Manager) Update(rc *RuleConfig) error {
-	var err error
+// Generation returns the generation of the active rule set
+func (rm *Manager) Generation() uint64 {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+	return rm.generation
+}
 
+// Update replaces every section of the configuration that is set in rc, the
+// rest of the configuration is kept. The change is recorded as coming from
+// the configuration files.
+func (rm *Manager) Update(rc *RuleConfig) error {
 	log.Info().Msg("updating config")
 	defer log.Info().Msg("updated config")
 
-	newRules := make(map[string]rule)
+	_, err := rm.UpdateIf(rc, AnyVersion, Change{Source: SourceFile})
+	return err
+}
 
-	if rc.DomainBlacklistConfig != nil {
-		bl, err := NewDomainBlacklist(rc.DomainBlacklistConfig)
-		if err != nil {
-			return err
-		}
-		newRules["blacklist"] = bl
+// Allow reports whether request is permitted
+func (rm *Manager) Allow(request *http.Request) bool {
+	return rm.Decide(request).Allow
+}
+
+// Decide applies the rules to request, the result is cached per client and
+// host until the rules change, a schedule starts or ends, or the cache TTL
+// passes
+func (rm *Manager) Decide(request *http.Request) *Decision {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	now := time.Now()
+	key := cacheKey(request)
+	if decision, ok := rm.cache.get(key, now); ok {
+		return decision
 	}
 
-	if rc.DomainWhitelistConfig != nil {
-		wl, err := NewDomainWhitelist(rc.DomainWhitelistConfig)
-		if err != nil {
-			return err
+	decision := rm.decideInLock(rm.queryInLock(request, now), nil)
+	rm.cache.add(key, decision, now, rm.nextChangeInLock(now))
+	return decision
+}
+
+// queryInLock resolves the client of request and its group: the group
+// request is bound to, or else the group of its user, or else the group of
+// its address. It assumes that it is only called inside the read lock.
+func (rm *Manager) queryInLock(request *http.Request, now time.Time) *query {
+	q := &query{
+		request: request,
+		client:  net.ParseIP(ClientAddr(request)),
+		user:    ClientUser(request),
+		now:     now,
+	}
+	if group, ok := boundGroup(request); ok {
+		q.group = group
+		return q
+	}
+	// a user is in their group on any device, before the group of the
+	// device's address
+	if q.user != "" {
+		for _, g := range rm.groups {
+			if g.hasUser(q.user) {
+				q.group = g.name
+				return q
+			}
 		}
-		newRules["whitelist"] = wl
 	}
-
-	err = rm.update(newRules, rc)
-	if err != nil {
-		return err
+	if q.client != nil {
+		for _, g := range rm.groups {
+			if g.contains(q.client) {
+				q.group = g.name
+				break
+			}
+		}
 	}
+	return q
+}
 
-	return nil
+// nextChangeInLock returns the next time a schedule starts or ends or an
+// override expires, or the zero time if there is no such time. It assumes
+// that it is only called inside the read lock.
+func (rm *Manager) nextChangeInLock(now time.Time) time.Time {
+	result := rm.overrides.nextExpiry(now)
+	for _, s := range rm.schedules {
+		next := s.next(now)
+		if result.IsZero() || next.Before(result) {
+			result = next
+		}
+	}
+	return result
 }
 
-func (rm *Manager) Allow(request *http.Request) bool {
-	rm.lock.RLock()
-	defer rm.lock.RUnlock()
+// decideInLock applies every rule to q, it assumes that it is only called
+// inside the read lock. If trace is set it is called with the result of
+// every rule that was applied.
+func (rm *Manager) decideInLock(
+	q *query,
+	trace func(name string, result permitted),
+) *Decision {
+	// an active override beats every rule
+	if o := rm.overrides.match(q); o != nil {
+		name := fmt.Sprintf("override:%d", o.ID)
+		if trace != nil {
+			trace(name, o.action)
+		}
+		return &Decision{Allow: o.action == allow, Rule: name, Group: q.group}
+	}
 
 	// default allow
-	ret := true
-	for name, r := range rm.rules {
-		status, cached := r.allow(request)
+	ret := &Decision{Allow: true, Rule: "default", Group: q.group}
+	for _, name := range rm.order {
+		r := rm.rules[name]
+		start := time.Now()
+		status := r.allow(q)
+		metrics.RuleEvaluation.WithLabelValues(name).
+			Observe(time.Since(start).Seconds())
+
 		if e := log.Debug(); e.Enabled() {
 			e.Str("rule", name).
-				Str("uri", request.URL.String()).
+				Str("uri", q.request.URL.String()).
+				Str("group", q.group).
+				Str("user", q.user).
 				Str("status", status.String()).
-				Bool("cached", cached).
 				Msg("applied rule")
 		}
+		if trace != nil {
+			trace(name, status)
+		}
 
 		switch status {
 		case allow:
 			// If we've whitelisted it all's good
-			return true
+			return &Decision{Allow: true, Rule: name, Group: q.group}
 		case deny:
 			// If we ever fail a check then we'll mark it as failed
-			ret = false
+			if ret.Allow {
+				ret = &Decision{Allow: false, Rule: name, Group: q.group}
+			}
 		case pass:
 			// this rule didn't apply
 			continue
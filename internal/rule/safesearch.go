@@ -0,0 +1,166 @@
+package rule
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SafeSearchConfig makes search engines hide explicit results and YouTube
+// hide mature videos, by rewriting the requests sent to them. Requests
+// inside HTTPS tunnels can only be rewritten if the proxy decrypts them.
+type SafeSearchConfig struct {
+	// Enabled turns safe search on, the rest only refines it
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Engines are any of google, bing, duckduckgo and youtube, all of them
+	// if empty
+	Engines []string `json:"engines,omitempty" yaml:"engines"`
+	// YouTube is the restricted mode, strict or moderate, strict if empty
+	YouTube string `json:"youtube,omitempty" yaml:"youtube"`
+	// Groups limits safe search to these client groups, all clients if
+	// empty
+	Groups []string `json:"groups,omitempty" yaml:"groups"`
+}
+
+func (sc *SafeSearchConfig) String() string {
+	return fmt.Sprintf("engines: %s, youtube: %s, groups: %s",
+		strings.Join(sc.engines(), ", "), sc.youTube(),
+		strings.Join(sc.Groups, ", "))
+}
+
+// Validate checks everything that doesn't depend on other parts of the
+// configuration
+func (sc *SafeSearchConfig) Validate() error {
+	for _, e := range sc.Engines {
+		if _, ok := engines[e]; !ok {
+			return fmt.Errorf("unknown search engine %q", e)
+		}
+	}
+	switch sc.YouTube {
+	case "", "strict", "moderate":
+	default:
+		return fmt.Errorf("invalid YouTube mode %q", sc.YouTube)
+	}
+	return nil
+}
+
+// IsEmpty reports whether sc turns safe search off
+func (sc *SafeSearchConfig) IsEmpty() bool {
+	return !sc.Enabled
+}
+
+func (sc *SafeSearchConfig) engines() []string {
+	if len(sc.Engines) > 0 {
+		return sc.Engines
+	}
+	return []string{"google", "bing", "duckduckgo", "youtube"}
+}
+
+func (sc *SafeSearchConfig) youTube() string {
+	if sc.YouTube == "" {
+		return "strict"
+	}
+	return sc.YouTube
+}
+
+// engine rewrites the requests for a single search engine
+type engine struct {
+	// serves reports whether host belongs to the engine
+	serves func(host string) bool
+	modify func(q *query, m *Modification, sc *SafeSearchConfig)
+}
+
+// searchParameter sets a parameter on search requests, which are the ones
+// with a query
+func searchParameter(name, value string) func(*query, *Modification, *SafeSearchConfig) {
+	return func(q *query, m *Modification, sc *SafeSearchConfig) {
+		if _, ok := q.request.URL.Query()["q"]; ok {
+			m.Query.Set(name, value)
+		}
+	}
+}
+
+func subdomainOf(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+var youTubeHosts = map[string]bool{
+	"youtube.com":              true,
+	"www.youtube.com":          true,
+	"m.youtube.com":            true,
+	"youtubei.googleapis.com":  true,
+	"youtube.googleapis.com":   true,
+	"www.youtube-nocookie.com": true,
+}
+
+var engines = map[string]*engine{
+	"google": {
+		// google.com, www.google.co.uk and so on, but not mail.google.com
+		serves: func(host string) bool {
+			host = strings.TrimPrefix(host, "www.")
+			if !strings.HasPrefix(host, "google.") {
+				return false
+			}
+			tld := strings.TrimPrefix(host, "google.")
+			return tld != "" && strings.Count(tld, ".") <= 1
+		},
+		modify: searchParameter("safe", "active"),
+	},
+	"bing": {
+		serves: func(host string) bool { return subdomainOf(host, "bing.com") },
+		modify: searchParameter("adlt", "strict"),
+	},
+	"duckduckgo": {
+		serves: func(host string) bool { return subdomainOf(host, "duckduckgo.com") },
+		modify: searchParameter("kp", "1"),
+	},
+	"youtube": {
+		serves: func(host string) bool { return youTubeHosts[host] },
+		modify: func(q *query, m *Modification, sc *SafeSearchConfig) {
+			mode := sc.youTube()
+			m.Set.Set("YouTube-Restrict", strings.ToUpper(mode[:1])+mode[1:])
+		},
+	},
+}
+
+type safeSearch struct {
+	conf    *SafeSearchConfig
+	engines []*engine
+	groups  map[string]bool
+}
+
+func newSafeSearch(
+	sc *SafeSearchConfig,
+	groups map[string]*clientGroup,
+) (*safeSearch, error) {
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &safeSearch{conf: sc, groups: make(map[string]bool)}
+	for _, name := range sc.engines() {
+		s.engines = append(s.engines, engines[name])
+	}
+	for _, g := range sc.Groups {
+		if _, ok := groups[g]; !ok {
+			return nil, fmt.Errorf("unknown group %s", g)
+		}
+		s.groups[g] = true
+	}
+	return s, nil
+}
+
+// modify adds what q needs to m, it reports whether it changed anything
+func (s *safeSearch) modify(q *query, m *Modification) bool {
+	if len(s.groups) > 0 && !s.groups[q.group] {
+		return false
+	}
+	host := hostOf(q.request)
+	for _, e := range s.engines {
+		if e.serves(host) {
+			before := len(m.Query) + len(m.Set)
+			e.modify(q, m, s.conf)
+			return len(m.Query)+len(m.Set) != before
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package rule
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Manager_Modify_SafeSearch(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Groups: []*GroupConfig{{Name: "kids", Clients: []string{"10.0.0.2"}}},
+		SafeSearch: &SafeSearchConfig{
+			Enabled: true,
+			YouTube: "moderate",
+			Groups:  []string{"kids"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	tests := []struct {
+		url string
+		// want is the URL after modification, empty if the request is not
+		// modified
+		want    string
+		youTube string
+	}{
+		{"http://www.google.com/search?q=cats", "http://www.google.com/search?q=cats&safe=active", ""},
+		{"http://google.co.uk/search?q=cats&safe=off", "http://google.co.uk/search?q=cats&safe=active", ""},
+		{"http://www.bing.com/images/search?q=cats", "http://www.bing.com/images/search?adlt=strict&q=cats", ""},
+		{"http://duckduckgo.com/?q=cats", "http://duckduckgo.com/?kp=1&q=cats", ""},
+		{"http://www.youtube.com/watch?v=1", "http://www.youtube.com/watch?v=1", "Moderate"},
+		{"http://www.google.com/", "", ""},
+		{"http://mail.google.com/search?q=cats", "", ""},
+		{"http://www.example.com/search?q=cats", "", ""},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", test.url, nil)
+		request.RemoteAddr = "10.0.0.2:1234"
+		m := rm.Modify(request)
+		if test.want == "" {
+			if m != nil {
+				t.Errorf("got %+v wanted no modification for %s", m, test.url)
+			}
+			continue
+		}
+		if m == nil || len(m.Rules) != 1 || m.Rules[0] != "safe_search" {
+			t.Errorf("got %+v wanted a modification by safe_search for %s", m, test.url)
+			continue
+		}
+		m.Apply(request)
+		if request.URL.String() != test.want || request.RequestURI != test.want {
+			t.Errorf("got %s %s wanted %s", request.URL, request.RequestURI, test.want)
+		}
+		if got := request.Header.Get("YouTube-Restrict"); got != test.youTube {
+			t.Errorf("got %q wanted %q for %s", got, test.youTube, test.url)
+		}
+
+		// only kids get safe search
+		request = httptest.NewRequest("GET", test.url, nil)
+		request.RemoteAddr = "10.0.0.3:1234"
+		if m := rm.Modify(request); m != nil {
+			t.Errorf("got %+v wanted no modification outside the group", m)
+		}
+	}
+
+	// turning it off removes it
+	if err := rm.Update(&RuleConfig{SafeSearch: &SafeSearchConfig{}}); err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	request := httptest.NewRequest("GET", "http://www.google.com/search?q=cats", nil)
+	request.RemoteAddr = "10.0.0.2:1234"
+	if m := rm.Modify(request); m != nil || rm.GetRules().SafeSearch != nil {
+		t.Fatalf("got %+v wanted safe search off", m)
+	}
+}
+
+func Test_SafeSearchConfig_Invalid(t *testing.T) {
+	tests := []*SafeSearchConfig{
+		{Enabled: true, Engines: []string{"altavista"}},
+		{Enabled: true, YouTube: "lenient"},
+	}
+	for _, sc := range tests {
+		if err := sc.Validate(); err == nil {
+			t.Errorf("got nil wanted an error for %+v", sc)
+		}
+	}
+}
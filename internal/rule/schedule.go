@@ -0,0 +1,149 @@
+package rule
+
+import (
+	"fmt"
+	"time"
+)
+
+// clockLayout is how schedule times are written
+const clockLayout = "15:04"
+
+// ScheduleConfig is a named weekly window. Start and End are wall clock
+// times in the local time zone, if End is not after Start the window runs
+// past midnight into the following day. Without Days the window applies
+// every day.
+type ScheduleConfig struct {
+	Name  string   `json:"name" yaml:"name"`
+	Days  []string `json:"days,omitempty" yaml:"days"`
+	Start string   `json:"start" yaml:"start"`
+	End   string   `json:"end" yaml:"end"`
+}
+
+// Validate reports whether the schedule can be compiled
+func (sc *ScheduleConfig) Validate() error {
+	_, err := newSchedule(sc, time.Local)
+	return err
+}
+
+type schedule struct {
+	name   string
+	ranges []*TimeRange
+}
+
+// ParseClock parses a wall clock time, "24:00" is accepted as the last
+// instant of the day
+func ParseClock(value string, loc *time.Location) (time.Time, error) {
+	if value == "24:00" {
+		return time.Date(0, 1, 1, 23, 59, 59, 999999999, loc), nil
+	}
+	t, err := time.ParseInLocation(clockLayout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t, nil
+}
+
+func newSchedule(sc *ScheduleConfig, loc *time.Location) (*schedule, error) {
+	if sc.Name == "" {
+		return nil, fmt.Errorf("schedule must have a name")
+	}
+
+	var days []time.Weekday
+	var seen int
+	for _, d := range sc.Days {
+		day, err := ParseWeekday(d)
+		if err != nil {
+			return nil, err
+		}
+		if seen&(1<<day) != 0 {
+			return nil, fmt.Errorf("cannot have duplicates in list %s", d)
+		}
+		seen |= 1 << day
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			days = append(days, d)
+		}
+	}
+
+	start, err := ParseClock(sc.Start, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start: %v", err)
+	}
+	end, err := ParseClock(sc.End, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end: %v", err)
+	}
+	if start.Equal(end) {
+		return nil, fmt.Errorf("start and end cannot be the same")
+	}
+
+	s := &schedule{name: sc.Name}
+	if start.Before(end) {
+		s.ranges = append(s.ranges, NewTimeRangeInexact(days, start, end))
+		return s, nil
+	}
+
+	// The window wraps past midnight, split it into the evening on the
+	// configured days and the morning of the day after each of them
+	midnight := time.Date(0, 1, 1, 0, 0, 0, 0, loc)
+	endOfDay, _ := ParseClock("24:00", loc)
+	var following []time.Weekday
+	for _, d := range days {
+		following = append(following, (d+1)%7)
+	}
+	s.ranges = append(s.ranges,
+		NewTimeRangeInexact(days, start, endOfDay),
+		NewTimeRangeInexact(following, midnight, end),
+	)
+	return s, nil
+}
+
+// active reports whether t falls within the schedule
+func (s *schedule) active(t time.Time) bool {
+	for _, tr := range s.ranges {
+		if ok, _ := tr.Within(t); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the earliest time after t at which active may change
+func (s *schedule) next(t time.Time) time.Time {
+	var result time.Time
+	for _, tr := range s.ranges {
+		t := t.In(tr.loc)
+		year, month, day := t.Date()
+		// midnight is a boundary as well since the day changes
+		for _, b := range []time.Time{tr.Start, tr.End, {}} {
+			hour, min, sec := b.Clock()
+			candidate := time.Date(
+				year, month, day, hour, min, sec, b.Nanosecond(), tr.loc)
+			if !candidate.After(t) {
+				candidate = time.Date(
+					year, month, day+1, hour, min, sec, b.Nanosecond(), tr.loc)
+			}
+			if result.IsZero() || candidate.Before(result) {
+				result = candidate
+			}
+		}
+	}
+	return result
+}
+
+// flips returns the next time after t at which active changes, or the zero
+// time if it never does
+func (s *schedule) flips(t time.Time) time.Time {
+	current := s.active(t)
+	// every range has at most three boundaries a day, a week's worth of
+	// them is enough to find the flip if there is one
+	for i := 0; i < 7*len(s.ranges)*3+1; i++ {
+		t = s.next(t)
+		if s.active(t) != current {
+			return t
+		}
+	}
+	return time.Time{}
+}
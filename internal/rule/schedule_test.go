@@ -0,0 +1,75 @@
+package rule
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Schedule_Active(t *testing.T) {
+	s, err := newSchedule(&ScheduleConfig{
+		Name:  "bedtime",
+		Days:  []string{"sun"},
+		Start: "21:00",
+		End:   "07:00",
+	}, time.UTC)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	// january 4th, 1970 was a sunday, cal 1970
+	tests := map[time.Time]bool{
+		time.Date(1970, 1, 4, 20, 59, 0, 0, time.UTC): false,
+		time.Date(1970, 1, 4, 21, 0, 0, 0, time.UTC):  true,
+		time.Date(1970, 1, 4, 23, 59, 0, 0, time.UTC): true,
+		time.Date(1970, 1, 5, 0, 0, 0, 0, time.UTC):   true,
+		time.Date(1970, 1, 5, 6, 59, 0, 0, time.UTC):  true,
+		time.Date(1970, 1, 5, 7, 0, 0, 0, time.UTC):   false,
+		// monday night isn't part of the schedule
+		time.Date(1970, 1, 5, 22, 0, 0, 0, time.UTC): false,
+		time.Date(1970, 1, 6, 1, 0, 0, 0, time.UTC):  false,
+	}
+
+	for when, active := range tests {
+		if s.active(when) != active {
+			t.Fatalf("got %v wanted %v for %v", !active, active, when)
+		}
+	}
+}
+
+func Test_Schedule_Next(t *testing.T) {
+	s, err := newSchedule(&ScheduleConfig{
+		Name:  "homework",
+		Start: "16:00",
+		End:   "18:30",
+	}, time.UTC)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	tests := map[time.Time]time.Time{
+		time.Date(1970, 1, 5, 12, 0, 0, 0, time.UTC): time.Date(1970, 1, 5, 16, 0, 0, 0, time.UTC),
+		time.Date(1970, 1, 5, 16, 0, 0, 0, time.UTC): time.Date(1970, 1, 5, 18, 30, 0, 0, time.UTC),
+		time.Date(1970, 1, 5, 19, 0, 0, 0, time.UTC): time.Date(1970, 1, 6, 0, 0, 0, 0, time.UTC),
+	}
+
+	for when, next := range tests {
+		if got := s.next(when); !got.Equal(next) {
+			t.Fatalf("got %v wanted %v for %v", got, next, when)
+		}
+	}
+}
+
+func Test_Schedule_Invalid(t *testing.T) {
+	tests := []*ScheduleConfig{
+		{Start: "21:00", End: "07:00"},
+		{Name: "a", Start: "9pm", End: "07:00"},
+		{Name: "a", Start: "21:00", End: "21:00"},
+		{Name: "a", Days: []string{"mon", "monday"}, Start: "21:00", End: "22:00"},
+	}
+
+	for _, sc := range tests {
+		if err := sc.Validate(); err == nil {
+			t.Fatalf("got nil wanted error for %+v", sc)
+		}
+	}
+}
@@ -23,11 +23,14 @@ type TimeRange struct {
 	// only difference will be that we ignore the date component for inexact
 	// comparisons
 	Start, End time.Time
-	// startNs, endNs are the pre-calculated result of
-	//   start.Sub(start.Truncate(24 * time.Hour)
+	// startNs, endNs are the pre-calculated number of nanoseconds since the
+	// day began for Start and End in loc.
 	// Doing this makes things a tad more fragile, but sped up the
 	// computation by 2.6x
 	startNs, endNs int64
+	// loc is the location of Start, inexact comparisons happen on the wall
+	// clock there
+	loc *time.Location
 	// Days is the list of days the inexact comparison should validate
 	// against
 	Days []time.Weekday
@@ -40,9 +43,9 @@ type TimeRange struct {
 
 func (tr *TimeRange) UnmarshalJSON(data []byte) error {
 	type Intermediate struct {
-		Start time.Time `json:start`
-		End   time.Time `json:end`
-		Days  *[]string `json:days`
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+		Days  *[]string `json:"days"`
 	}
 
 	var i Intermediate
@@ -53,37 +56,51 @@ func (tr *TimeRange) UnmarshalJSON(data []byte) error {
 
 	tr.Start = i.Start
 	tr.End = i.End
+	tr.Days = nil
+	if i.Days == nil {
+		tr.Exact = true
+		return nil
+	}
+
 	var seen int
-	if i.Days != nil {
-		for _, d := range *i.Days {
-			var day time.Weekday
-			switch strings.ToLower(d) {
-			case "m", "mon", "monday":
-				day = time.Monday
-			case "tu", "tue", "tuesday":
-				day = time.Tuesday
-			case "w", "wed", "wednesday":
-				day = time.Wednesday
-			case "th", "thu", "thursday":
-				day = time.Thursday
-			case "f", "fri", "friday":
-				day = time.Friday
-			case "sa", "sat", "saturday":
-				day = time.Saturday
-			case "su", "sun", "sunday":
-				day = time.Sunday
-			default:
-				return fmt.Errorf("invalid weekday %s", d)
-			}
-			if seen & 1 << day {
-				return fmt.Errorf("cannot have duplicates in list %s", d)
-			}
-			seen |= 1 << day
+	for _, d := range *i.Days {
+		day, err := ParseWeekday(d)
+		if err != nil {
+			return err
 		}
+		if seen&(1<<day) != 0 {
+			return fmt.Errorf("cannot have duplicates in list %s", d)
+		}
+		seen |= 1 << day
+		tr.Days = append(tr.Days, day)
 	}
+	tr.Exact = false
+	tr.preComputeInexact()
 	return nil
 }
 
+// ParseWeekday accepts the full name of a day or its usual abbreviations,
+// case insensitively
+func ParseWeekday(d string) (time.Weekday, error) {
+	switch strings.ToLower(d) {
+	case "m", "mon", "monday":
+		return time.Monday, nil
+	case "tu", "tue", "tuesday":
+		return time.Tuesday, nil
+	case "w", "wed", "wednesday":
+		return time.Wednesday, nil
+	case "th", "thu", "thursday":
+		return time.Thursday, nil
+	case "f", "fri", "friday":
+		return time.Friday, nil
+	case "sa", "sat", "saturday":
+		return time.Saturday, nil
+	case "su", "sun", "sunday":
+		return time.Sunday, nil
+	}
+	return 0, fmt.Errorf("invalid weekday %s", d)
+}
+
 func (tr *TimeRange) computeBitmask() {
 	var bitmask int
 	for _, d := range tr.Days {
@@ -94,8 +111,18 @@ func (tr *TimeRange) computeBitmask() {
 
 func (tr *TimeRange) preComputeInexact() {
 	tr.computeBitmask()
-	tr.startNs = tr.Start.Sub(tr.Start.Truncate(24 * time.Hour)).Nanoseconds()
-	tr.endNs = tr.End.Sub(tr.End.Truncate(24 * time.Hour)).Nanoseconds()
+	tr.loc = tr.Start.Location()
+	tr.startNs = clockNs(tr.Start)
+	tr.endNs = clockNs(tr.End)
+}
+
+// clockNs is the number of nanoseconds since the day began in t's location
+func clockNs(t time.Time) int64 {
+	hour, min, sec := t.Clock()
+	return (int64(hour)*int64(time.Hour) +
+		int64(min)*int64(time.Minute) +
+		int64(sec)*int64(time.Second) +
+		int64(t.Nanosecond()))
 }
 
 //Within determines if a given time (t) is within a defined time range (tr)
@@ -106,23 +133,26 @@ func (tr *TimeRange) Within(t time.Time) (bool, error) {
 		return t.Equal(tr.Start) ||
 			(t.After(tr.Start) && t.Before(tr.End)), nil
 	} else {
+		// Inexact ranges are wall clock times, so the day and time of
+		// day have to be taken in the range's location
+		if tr.loc != nil {
+			t = t.In(tr.loc)
+		}
 		weekday := 1 << t.Weekday()
 		found := (tr.dayBitmask & weekday) > 0
 
 		// The idea here is to calculate the number of nanoseconds since
 		// the day began, then we can setup a standard range comparison
 		// without having to fiddle with hours/minutes/nanoseconds
-		candidate := t.Sub(t.Truncate(24 * time.Hour))
+		candidate := clockNs(t)
 
-		if candidate.Nanoseconds() >= tr.startNs &&
-			candidate.Nanoseconds() < tr.endNs {
+		if candidate >= tr.startNs &&
+			candidate < tr.endNs {
 			return found, nil
 		}
 
 		return false, nil
 	}
-
-	return false, nil
 }
 
 func (tr *TimeRange) String() string {
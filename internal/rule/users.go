@@ -0,0 +1,66 @@
+package rule
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// LoadUsers reads a users file, which puts the users who authenticate to
+// the proxy in groups. Every line is a user name and the name of their
+// group separated by whitespace, like "alice parents". Empty lines and
+// lines starting with # are ignored.
+func LoadUsers(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]string)
+	for i, line := range bytes.Split(contents, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(
+				"line %d: expected a user and a group, got %q", i+1, line)
+		}
+		user := strings.ToLower(fields[0])
+		if err := ValidateUser(user); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if _, ok := users[user]; ok {
+			return nil, fmt.Errorf("line %d: duplicate user %q", i+1, fields[0])
+		}
+		users[user] = fields[1]
+	}
+	return users, nil
+}
+
+// AddUsers returns a copy of groups with users, as read by LoadUsers, added
+// to their groups. It fails if a group doesn't exist.
+func AddUsers(groups []*GroupConfig, users map[string]string) ([]*GroupConfig, error) {
+	members := make(map[string][]string)
+	for user, group := range users {
+		members[group] = append(members[group], user)
+	}
+
+	result := make([]*GroupConfig, 0, len(groups))
+	for _, g := range groups {
+		if more, ok := members[g.Name]; ok {
+			copied := *g
+			sort.Strings(more)
+			copied.Users = append(append([]string{}, g.Users...), more...)
+			g = &copied
+			delete(members, g.Name)
+		}
+		result = append(result, g)
+	}
+	if unknown := sortedKeys(members); len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown group %q", unknown[0])
+	}
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package rule
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_LoadUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "users")
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(contents string) string {
+		path := filepath.Join(dir, "users")
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("got %v wanted nil", err)
+		}
+		return path
+	}
+
+	users, err := LoadUsers(write("# the family\nAlice parents\n\n  bob\tparents\ncarol kids\n"))
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	want := map[string]string{"alice": "parents", "bob": "parents", "carol": "kids"}
+	if !reflect.DeepEqual(users, want) {
+		t.Fatalf("got %v wanted %v", users, want)
+	}
+
+	groups := []*GroupConfig{
+		{Name: "kids", Clients: []string{"10.0.0.2"}},
+		{Name: "parents", Users: []string{"dave"}},
+	}
+	added, err := AddUsers(groups, users)
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+	if !reflect.DeepEqual(added[1].Users, []string{"dave", "alice", "bob"}) ||
+		!reflect.DeepEqual(added[0].Users, []string{"carol"}) {
+		t.Fatalf("got %+v %+v wanted the users added", added[0], added[1])
+	}
+	if len(groups[1].Users) != 1 {
+		t.Fatalf("got %v wanted the groups left alone", groups[1].Users)
+	}
+	if _, err := AddUsers(groups, map[string]string{"eve": "guests"}); err == nil {
+		t.Fatalf("got nil wanted an error for an unknown group")
+	}
+
+	for _, contents := range []string{"alice\n", "alice parents kids\n", "alice a\nALICE b\n"} {
+		if _, err := LoadUsers(write(contents)); err == nil {
+			t.Errorf("got nil wanted an error for %q", contents)
+		}
+	}
+}
+
+func Test_Manager_UserGroups(t *testing.T) {
+	rm, err := NewManager()
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	err = rm.Update(&RuleConfig{
+		Groups: []*GroupConfig{
+			{Name: "kids", Clients: []string{"10.0.0.0/24"}},
+			{Name: "parents", Users: []string{"Alice"}},
+		},
+		Categories: []*CategoryConfig{
+			{
+				Name:    "games",
+				Action:  "deny",
+				Domains: []string{"games.com"},
+				Groups:  []string{"kids"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("got %v wanted nil", err)
+	}
+
+	laptop := httptest.NewRequest("GET", "http://games.com/", nil)
+	laptop.RemoteAddr = "10.0.0.2:1234"
+
+	tests := []struct {
+		user  string
+		group string
+		allow bool
+	}{
+		{"", "kids", false},
+		{"alice", "parents", true},
+		{"carol", "kids", false},
+	}
+	for _, test := range tests {
+		request := WithIdentity(laptop, &ClientIdentity{User: test.user})
+		decision := rm.Decide(request)
+		if decision.Group != test.group || decision.Allow != test.allow {
+			t.Errorf("got %+v wanted group %s allow %v for %q",
+				decision, test.group, test.allow, test.user)
+		}
+	}
+
+	// a service bound to a group still decides for it
+	bound := WithGroup(WithIdentity(laptop, &ClientIdentity{User: "alice"}), "kids")
+	if decision := rm.Decide(bound); decision.Allow || decision.Group != "kids" {
+		t.Fatalf("got %+v wanted a denial for kids", decision)
+	}
+
+	if err := rm.Update(&RuleConfig{Groups: []*GroupConfig{
+		{Name: "parents", Users: []string{"alice smith"}},
+	}}); err == nil {
+		t.Fatalf("got nil wanted an error for an invalid user")
+	}
+}
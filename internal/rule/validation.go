@@ -0,0 +1,88 @@
+package rule
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FieldError is a single invalid entry in a change to the rules
+type FieldError struct {
+	// Field locates the entry, like blacklist[2] or groups[0]
+	Field   string `json:"field"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid entry in a change to the rules
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (ve *ValidationError) Error() string {
+	if len(ve.Errors) == 1 {
+		return fmt.Sprintf("%s: %s", ve.Errors[0].Field, ve.Errors[0].Message)
+	}
+	return fmt.Sprintf("%d invalid entries, first %s: %s",
+		len(ve.Errors), ve.Errors[0].Field, ve.Errors[0].Message)
+}
+
+func (ve *ValidationError) add(field, value, format string, args ...interface{}) {
+	ve.Errors = append(ve.Errors, FieldError{
+		Field:   field,
+		Value:   value,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// err returns ve if it holds any errors and nil otherwise
+func (ve *ValidationError) err() error {
+	if len(ve.Errors) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// validateDomains normalizes every domain, dropping duplicates, and reports
+// each invalid one as field[index]
+func validateDomains(
+	ve *ValidationError,
+	field string,
+	domains []string,
+) []string {
+	result := make([]string, 0, len(domains))
+	seen := make(map[string]bool, len(domains))
+	for i, domain := range domains {
+		d, err := NormalizeDomain(domain)
+		if err != nil {
+			ve.add(fmt.Sprintf("%s[%d]", field, i), domain,
+				"not a valid domain")
+			continue
+		}
+		if !seen[d] {
+			seen[d] = true
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// isList reports whether name is one of Lists
+func isList(name string) bool {
+	for _, l := range Lists {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in order, so errors are reported in a
+// stable order
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,135 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrShutdownTimeout is returned by Run when the services did not finish
+// draining before the shutdown timeout
+var ErrShutdownTimeout = errors.New("services did not drain before the timeout")
+
+// Service is a long running server, http.Server satisfies it
+type Service interface {
+	// ListenAndServe blocks until the service stops, it must return after
+	// Shutdown is called
+	ListenAndServe() error
+	// Shutdown stops accepting new work and waits for in flight work to
+	// finish or ctx to be done
+	Shutdown(ctx context.Context) error
+}
+
+// ServiceError is returned by Run when a service stopped on its own
+type ServiceError struct {
+	Name string
+	Err  error
+}
+
+func (se *ServiceError) Error() string {
+	return fmt.Sprintf("%s stopped: %v", se.Name, se.Err)
+}
+
+func (se *ServiceError) Unwrap() error {
+	return se.Err
+}
+
+type named struct {
+	name    string
+	service Service
+}
+
+// Supervisor runs a set of services, if any of them stops they are all shut
+// down
+type Supervisor struct {
+	// Timeout bounds how long shutting down may take
+	Timeout  time.Duration
+	services []named
+}
+
+func New(timeout time.Duration) *Supervisor {
+	return &Supervisor{Timeout: timeout}
+}
+
+// Add registers a service to be started by Run
+func (s *Supervisor) Add(name string, service Service) {
+	s.services = append(s.services, named{name: name, service: service})
+}
+
+type result struct {
+	name string
+	err  error
+}
+
+// Run starts every service and blocks until ctx is done or a service stops,
+// then drains all of them. It returns nil if shutdown was requested through
+// ctx and every service drained, a *ServiceError if a service stopped on its
+// own, or ErrShutdownTimeout if draining did not finish in time.
+func (s *Supervisor) Run(ctx context.Context) error {
+	results := make(chan result, len(s.services))
+	for _, n := range s.services {
+		go func(n named) {
+			log.Info().Str("service", n.name).Msg("starting")
+			results <- result{name: n.name, err: n.service.ListenAndServe()}
+		}(n)
+	}
+
+	var failure error
+	running := len(s.services)
+	select {
+	case <-ctx.Done():
+		log.Info().Msg("shutdown requested")
+	case r := <-results:
+		running--
+		if r.err == nil {
+			r.err = errors.New("exited unexpectedly")
+		}
+		failure = &ServiceError{Name: r.name, Err: r.err}
+		log.Error().Str("service", r.name).Err(r.err).Msg("service failed")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	shutdown := make(chan error, len(s.services))
+	for _, n := range s.services {
+		go func(n named) {
+			err := n.service.Shutdown(shutdownCtx)
+			if err != nil {
+				log.Error().Str("service", n.name).Err(err).
+					Msg("could not drain")
+			}
+			shutdown <- err
+		}(n)
+	}
+
+	timedOut := false
+	for i := 0; i < len(s.services); i++ {
+		if err := <-shutdown; err != nil {
+			timedOut = true
+		}
+	}
+
+	// The services have been told to stop, wait for them to return but
+	// don't wait longer than we were willing to wait for draining
+	for running > 0 {
+		select {
+		case r := <-results:
+			running--
+			log.Info().Str("service", r.name).Msg("stopped")
+		case <-shutdownCtx.Done():
+			return ErrShutdownTimeout
+		}
+	}
+
+	if failure != nil {
+		return failure
+	}
+	if timedOut {
+		return ErrShutdownTimeout
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeService blocks in ListenAndServe until Shutdown is called, or returns
+// err straight away if it is set
+type fakeService struct {
+	err     error
+	drain   time.Duration
+	stopped chan struct{}
+}
+
+func newFakeService(err error, drain time.Duration) *fakeService {
+	return &fakeService{err: err, drain: drain, stopped: make(chan struct{})}
+}
+
+func (fs *fakeService) ListenAndServe() error {
+	if fs.err != nil {
+		return fs.err
+	}
+	<-fs.stopped
+	return errors.New("closed")
+}
+
+func (fs *fakeService) Shutdown(ctx context.Context) error {
+	defer func() {
+		select {
+		case <-fs.stopped:
+		default:
+			close(fs.stopped)
+		}
+	}()
+
+	select {
+	case <-time.After(fs.drain):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func Test_Supervisor_Run(t *testing.T) {
+	bindErr := errors.New("address already in use")
+
+	tc := []struct {
+		name     string
+		services []*fakeService
+		cancel   bool
+		check    func(error) bool
+	}{
+		{
+			name: "signal drains cleanly",
+			services: []*fakeService{
+				newFakeService(nil, 0),
+				newFakeService(nil, 0),
+			},
+			cancel: true,
+			check:  func(err error) bool { return err == nil },
+		},
+		{
+			name: "failed listener stops everything",
+			services: []*fakeService{
+				newFakeService(nil, 0),
+				newFakeService(bindErr, 0),
+			},
+			check: func(err error) bool {
+				var se *ServiceError
+				return errors.As(err, &se) &&
+					se.Name == "1" &&
+					errors.Is(err, bindErr)
+			},
+		},
+		{
+			name: "slow drain times out",
+			services: []*fakeService{
+				newFakeService(nil, time.Hour),
+			},
+			cancel: true,
+			check: func(err error) bool {
+				return errors.Is(err, ErrShutdownTimeout)
+			},
+		},
+	}
+
+	for _, test := range tc {
+		s := New(50 * time.Millisecond)
+		for i, fs := range test.services {
+			s.Add(string(rune('0'+i)), fs)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if test.cancel {
+			cancel()
+		}
+
+		err := s.Run(ctx)
+		cancel()
+		if !test.check(err) {
+			t.Fatalf("%s got unexpected error %v", test.name, err)
+		}
+	}
+}